@@ -0,0 +1,706 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"web_crawler/internal/blob"
+	"web_crawler/internal/db"
+	"web_crawler/internal/downloader"
+	"web_crawler/internal/language"
+	"web_crawler/internal/logging"
+	"web_crawler/internal/metrics"
+	"web_crawler/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = tracing.Tracer("crawler")
+
+// queueSize емкость очереди URL одного обхода
+const queueSize = 100000
+
+var errQueueFull = errors.New("crawl queue is full")
+
+// Состояния обхода
+const (
+	crawlRunning  = "running"
+	crawlPaused   = "paused"
+	crawlStopping = "stopping"
+	crawlFinished = "finished"
+)
+
+// CrawlSpec описание обхода. Его принимают и CLI, и HTTP API
+type CrawlSpec struct {
+	// Domain главный домен: ссылки собираются только со страниц домена и его поддоменов.
+	// По умолчанию - хост первого стартового URL
+	Domain string   `json:"domain"`
+	Seeds  []string `json:"seeds"`
+	// Workers число параллельных воркеров, по умолчанию 5
+	Workers int `json:"workers"`
+	// IdleTimeout сколько секунд воркер ждет новый URL перед остановкой, по умолчанию 10
+	IdleTimeout int `json:"idle_timeout"`
+	// ProgressInterval как часто (в секундах) печатать строку прогресса; 0 - не печатать
+	ProgressInterval int `json:"progress_interval"`
+	// Languages языки страниц, ссылки с которых обходятся, например ["ru", "en"]; пустой - любые.
+	// Ссылки со страниц, язык которых не определен, обходятся всегда
+	Languages []string `json:"languages"`
+}
+
+func (s *CrawlSpec) validate() error {
+	if len(s.Seeds) == 0 {
+		return fmt.Errorf("crawl spec has no seeds")
+	}
+	for _, seed := range s.Seeds {
+		if _, err := downloader.GetHost(seed); err != nil {
+			return fmt.Errorf("invalid seed %q: %v", seed, err)
+		}
+	}
+	if s.Domain == "" {
+		s.Domain, _ = downloader.GetHost(s.Seeds[0])
+	}
+	if s.Workers <= 0 {
+		s.Workers = 5
+	}
+	if s.IdleTimeout <= 0 {
+		s.IdleTimeout = 10
+	}
+	for i, l := range s.Languages {
+		if s.Languages[i] = language.Normalize(l); s.Languages[i] == language.Unknown {
+			return fmt.Errorf("invalid language %q", l)
+		}
+	}
+	return nil
+}
+
+// follows обходятся ли ссылки со страницы на языке lang
+func (s *CrawlSpec) follows(lang string) bool {
+	return len(s.Languages) == 0 || lang == language.Unknown || slices.Contains(s.Languages, lang)
+}
+
+// crawlTask URL в очереди вместе с глубиной, на которой он был найден
+type crawlTask struct {
+	url   string
+	host  string
+	depth int
+}
+
+// inDomain проверяет, что host совпадает с domain или является его поддоменом
+func inDomain(host, domain string) bool {
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// HostProgress прогресс обхода одного хоста
+type HostProgress struct {
+	Queued int64 `json:"queued"`
+	Done   int64 `json:"done"`
+	Failed int64 `json:"failed"`
+}
+
+// Progress текущее состояние обхода
+type Progress struct {
+	RunID     int64                   `json:"run_id"`
+	State     string                  `json:"state"`
+	StartedAt time.Time               `json:"started_at"`
+	Queued    int                     `json:"queued"`
+	InFlight  int64                   `json:"in_flight"`
+	Done      int64                   `json:"done"`
+	Failed    int64                   `json:"failed"`
+	Saved     int64                   `json:"saved"`
+	Hosts     map[string]HostProgress `json:"hosts"`
+}
+
+// String однострочная сводка прогресса для терминала
+func (p Progress) String() string {
+	elapsed := time.Since(p.StartedAt).Round(time.Second)
+	rate := 0.0
+	if elapsed > 0 {
+		rate = float64(p.Done) / elapsed.Seconds()
+	}
+	return fmt.Sprintf("run %d %s %s: queued %d, in flight %d, done %d, failed %d, saved %d, %.1f pages/s",
+		p.RunID, p.State, elapsed, p.Queued, p.InFlight, p.Done, p.Failed, p.Saved, rate)
+}
+
+// Crawl запущенный обход: воркеры, frontier и запись в базу
+type Crawl struct {
+	spec    CrawlSpec
+	run     *db.CrawlRun
+	fetcher *downloader.Fetcher
+	blobs   blob.Store
+	storage *db.PostgresStorage
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	log    *slog.Logger
+	pool   *downloader.URLsPool
+	queue  chan crawlTask
+	writer *db.BatchWriter
+	wg     sync.WaitGroup
+	done   chan struct{}
+	err    error
+
+	counters db.RunCounters
+	inFlight int64
+	// dnsBefore статистика DNS-серверов на момент старта; резолвер общий для всех запусков
+	dnsBefore []downloader.UpstreamStats
+
+	mu    sync.Mutex
+	state string
+	// resume не nil, пока обход на паузе; закрывается при продолжении
+	resume chan struct{}
+	hosts  map[string]*HostProgress
+	// limitHits загрузки, прерванные ограничениями, по имени ограничения
+	limitHits map[string]int64
+}
+
+// Run обходит сайт до исчерпания очереди (CLI-режим spider)
+func (c *Crawler) Run(maindomain string, starturl string, numWorkers int) error {
+	crawl, err := c.StartCrawl(CrawlSpec{
+		Domain:           maindomain,
+		Seeds:            []string{starturl},
+		Workers:          numWorkers,
+		ProgressInterval: c.snapshot.ProgressInterval,
+		Languages:        c.snapshot.FollowLanguages,
+	})
+	if err != nil {
+		return err
+	}
+	return crawl.Wait()
+}
+
+// StartCrawl создает запуск в базе и запускает воркеры в фоне
+func (c *Crawler) StartCrawl(spec CrawlSpec) (*Crawl, error) {
+	if err := spec.validate(); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	run, err := c.storage.StartRun(ctx, spec.Seeds, c.snapshot)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("failed to start crawl run: %v", err)
+	}
+	ctx = logging.With(ctx, logging.KeyRunID, run.ID)
+	logger := logging.FromContext(ctx)
+	logger.Info("Crawl run started", "seeds", spec.Seeds, "workers", spec.Workers)
+
+	var m sync.RWMutex
+	cr := &Crawl{
+		spec:    spec,
+		run:     run,
+		fetcher: c.fetcher,
+		blobs:   c.blobs,
+		storage: c.storage,
+		ctx:     ctx,
+		log:     logger,
+		cancel:  cancel,
+		pool:    downloader.CreatePool(&m),
+		queue:   make(chan crawlTask, queueSize),
+		done:    make(chan struct{}),
+		state:   crawlRunning,
+		hosts:   make(map[string]*HostProgress),
+
+		limitHits: make(map[string]int64),
+
+		dnsBefore: c.fetcher.Resolver.Stats(),
+	}
+	cr.writer = c.storage.NewBatchWriter(c.batch, cr.onSaved)
+
+	for _, seed := range spec.Seeds {
+		if _, err := cr.enqueue(seed, 0); err != nil {
+			cr.abort(err)
+			return nil, err
+		}
+	}
+
+	for i := 1; i <= spec.Workers; i++ {
+		cr.wg.Add(1)
+		worker := Worker{
+			id:      i,
+			crawl:   cr,
+			fetcher: c.fetcher,
+			timeout: time.Duration(spec.IdleTimeout),
+		}
+		go worker.Start(ctx)
+	}
+
+	go cr.finish()
+	if spec.ProgressInterval > 0 {
+		go cr.report(time.Duration(spec.ProgressInterval) * time.Second)
+	}
+	return cr, nil
+}
+
+// abort закрывает запуск, который не удалось начать: воркеры и finish еще не запущены
+func (cr *Crawl) abort(cause error) {
+	cr.cancel()
+	metrics.QueueDepth.Sub(float64(len(cr.queue)))
+	if err := cr.writer.Close(); err != nil {
+		cr.log.Warn("Failed to close batch writer", logging.Err(err))
+	}
+	if err := cr.storage.FinishRun(context.Background(), cr.run.ID, db.RunFailed, cr.counterSnapshot()); err != nil {
+		cr.log.Warn("Failed to mark crawl run as failed", logging.Err(err))
+	}
+	cr.log.Error("Crawl run failed to start", logging.Err(cause))
+}
+
+// report печатает строку прогресса каждые interval, пока обход не завершится
+func (cr *Crawl) report(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			cr.log.Info(cr.Progress().String())
+		case <-cr.done:
+			return
+		}
+	}
+}
+
+func (cr *Crawl) onSaved(res db.RowResult) {
+	switch {
+	case res.Err != nil:
+		atomic.AddInt64(&cr.counters.SaveErrors, 1)
+		cr.log.Error("Failed to save content", logging.KeyURL, res.Content.URL, logging.Err(res.Err))
+	case !res.Saved:
+		atomic.AddInt64(&cr.counters.Duplicates, 1)
+		cr.log.Debug("Content already exists, skipping", logging.KeyURL, res.Content.URL)
+	default:
+		atomic.AddInt64(&cr.counters.Saved, 1)
+		cr.log.Debug("Content saved", logging.KeyURL, res.Content.URL)
+	}
+}
+
+// finish дожидается воркеров, дописывает очередь записи и закрывает запуск
+func (cr *Crawl) finish() {
+	defer close(cr.done)
+
+	cr.wg.Wait()
+	if err := cr.writer.Close(); err != nil {
+		cr.log.Warn("Failed to close batch writer", logging.Err(err))
+	}
+	// Оставшиеся в очереди URL больше не будут обработаны
+	metrics.QueueDepth.Sub(float64(len(cr.queue)))
+
+	status := db.RunFinished
+	if cr.ctx.Err() != nil {
+		status = db.RunStopped
+	}
+	cr.cancel()
+
+	cr.mu.Lock()
+	cr.state = crawlFinished
+	cr.mu.Unlock()
+
+	c := cr.counterSnapshot()
+	if err := cr.storage.FinishRun(context.Background(), cr.run.ID, status, c); err != nil {
+		cr.err = fmt.Errorf("failed to finish crawl run %d: %v", cr.run.ID, err)
+		return
+	}
+	if err := cr.storage.SetRunDNSStats(context.Background(), cr.run.ID, cr.dnsStats()); err != nil {
+		cr.log.Warn("Failed to save DNS stats", logging.Err(err))
+	}
+	cr.mu.Lock()
+	hits := maps.Clone(cr.limitHits)
+	cr.mu.Unlock()
+	if err := cr.storage.SetRunLimitHits(context.Background(), cr.run.ID, hits); err != nil {
+		cr.log.Warn("Failed to save limit hits", logging.Err(err))
+	}
+	if err := cr.storage.SaveCookies(context.Background(), cr.cookies()); err != nil {
+		cr.log.Warn("Failed to save cookies", logging.Err(err))
+	}
+	cr.log.Info("Crawl run "+status, "fetched", c.Fetched, "saved", c.Saved,
+		"fetch_errors", c.FetchErrors, "save_errors", c.SaveErrors)
+}
+
+// dnsStats статистика DNS-серверов за время запуска
+func (cr *Crawl) dnsStats() []db.DNSUpstream {
+	diff := downloader.DiffStats(cr.dnsBefore, cr.fetcher.Resolver.Stats())
+	out := make([]db.DNSUpstream, len(diff))
+	for i, s := range diff {
+		out[i] = db.DNSUpstream(s)
+	}
+	return out
+}
+
+// cookies содержимое jar для сохранения до следующего запуска
+func (cr *Crawl) cookies() []db.Cookie {
+	all := cr.fetcher.Jar.All()
+	out := make([]db.Cookie, len(all))
+	for i, c := range all {
+		out[i] = db.Cookie(c)
+	}
+	return out
+}
+
+// Wait блокируется до завершения обхода
+func (cr *Crawl) Wait() error {
+	<-cr.done
+	return cr.err
+}
+
+// Done закрывается, когда обход завершен
+func (cr *Crawl) Done() <-chan struct{} {
+	return cr.done
+}
+
+// Stop прерывает обход; уже скачанные страницы будут записаны
+func (cr *Crawl) Stop() {
+	cr.mu.Lock()
+	if cr.state != crawlFinished {
+		cr.state = crawlStopping
+	}
+	cr.mu.Unlock()
+	cr.cancel()
+}
+
+// Pause останавливает выдачу новых URL воркерам; текущие загрузки доделываются
+func (cr *Crawl) Pause() {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if cr.state == crawlRunning {
+		cr.state = crawlPaused
+		cr.resume = make(chan struct{})
+	}
+}
+
+func (cr *Crawl) Resume() {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if cr.state == crawlPaused {
+		cr.state = crawlRunning
+		close(cr.resume)
+		cr.resume = nil
+	}
+}
+
+// waitResumed блокирует воркер, пока обход на паузе
+func (cr *Crawl) waitResumed(ctx context.Context) error {
+	cr.mu.Lock()
+	resume := cr.resume
+	cr.mu.Unlock()
+	if resume == nil {
+		return nil
+	}
+
+	select {
+	case <-resume:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Submit добавляет URL в frontier и возвращает число новых URL
+func (cr *Crawl) Submit(urls []string) (int, error) {
+	accepted := 0
+	for _, u := range urls {
+		ok, err := cr.enqueue(u, 0)
+		if err != nil {
+			return accepted, err
+		}
+		if ok {
+			accepted++
+		}
+	}
+	return accepted, nil
+}
+
+// enqueue ставит URL в очередь, если его еще нет в frontier
+func (cr *Crawl) enqueue(u string, depth int) (bool, error) {
+	host, err := downloader.GetHost(u)
+	if err != nil {
+		return false, err
+	}
+	if cr.ctx.Err() != nil {
+		return false, cr.ctx.Err()
+	}
+	if !cr.pool.TryAdd(u) {
+		return false, nil
+	}
+
+	// Отправка не блокируется: воркер, ждущий места в полной очереди, которую разбирают
+	// только воркеры, остановил бы обход
+	select {
+	case cr.queue <- crawlTask{url: u, host: host, depth: depth}:
+	default:
+		// URL можно будет добавить снова, когда очередь освободится
+		cr.pool.Remove(u)
+		return false, errQueueFull
+	}
+	metrics.QueueDepth.Inc()
+	cr.hostProgress(host, func(p *HostProgress) { p.Queued++ })
+	return true, nil
+}
+
+func (cr *Crawl) hostProgress(host string, fn func(p *HostProgress)) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	p, ok := cr.hosts[host]
+	if !ok {
+		p = &HostProgress{}
+		cr.hosts[host] = p
+	}
+	fn(p)
+}
+
+func (cr *Crawl) counterSnapshot() db.RunCounters {
+	return db.RunCounters{
+		Fetched:     atomic.LoadInt64(&cr.counters.Fetched),
+		Saved:       atomic.LoadInt64(&cr.counters.Saved),
+		Duplicates:  atomic.LoadInt64(&cr.counters.Duplicates),
+		FetchErrors: atomic.LoadInt64(&cr.counters.FetchErrors),
+		SaveErrors:  atomic.LoadInt64(&cr.counters.SaveErrors),
+	}
+}
+
+// Progress возвращает снимок состояния обхода
+func (cr *Crawl) Progress() Progress {
+	c := cr.counterSnapshot()
+	p := Progress{
+		RunID:     cr.run.ID,
+		StartedAt: cr.run.StartedAt,
+		Queued:    len(cr.queue),
+		InFlight:  atomic.LoadInt64(&cr.inFlight),
+		Done:      c.Fetched,
+		Failed:    c.FetchErrors,
+		Saved:     c.Saved,
+	}
+
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	p.State = cr.state
+	p.Hosts = make(map[string]HostProgress, len(cr.hosts))
+	for host, hp := range cr.hosts {
+		p.Hosts[host] = *hp
+	}
+	return p
+}
+
+type Worker struct {
+	id      int
+	crawl   *Crawl
+	fetcher *downloader.Fetcher
+	timeout time.Duration
+}
+
+func (w *Worker) Start(ctx context.Context) {
+	defer w.crawl.wg.Done()
+	ctx = logging.With(ctx, logging.KeyWorker, w.id)
+
+	for {
+		if err := w.crawl.waitResumed(ctx); err != nil {
+			return
+		}
+
+		select {
+		case task := <-w.crawl.queue:
+			metrics.QueueDepth.Dec()
+			// Воркер мог ждать задачу, когда обход поставили на паузу
+			if err := w.crawl.waitResumed(ctx); err != nil {
+				return
+			}
+			w.process(ctx, task)
+		case <-ctx.Done():
+			return
+		case <-time.After(w.timeout * time.Second):
+			logging.FromContext(ctx).Debug("Worker idle, stopping")
+			return
+		}
+	}
+}
+
+// mergeRuntimeLinks добавляет к ссылкам из итогового HTML ссылки, найденные браузером во время
+// загрузки, и возвращает происхождение тех из них, которых нет среди <a href>
+func mergeRuntimeLinks(anchors []string, found []downloader.Link) ([]string, map[string]string) {
+	if len(found) == 0 {
+		return anchors, nil
+	}
+	inDOM := make(map[string]bool, len(anchors))
+	for _, link := range anchors {
+		inDOM[link] = true
+	}
+
+	var extra []string
+	origins := make(map[string]string)
+	for _, link := range found {
+		extra = append(extra, link.URL)
+		if !inDOM[link.URL] && link.Origin != downloader.OriginAnchor {
+			origins[link.URL] = link.Origin
+		}
+	}
+	return downloader.MergeLinks(anchors, extra), origins
+}
+
+// pageMetadata сведения о загрузке, которые хранятся в metadata страницы
+func pageMetadata(page *downloader.Page) map[string]string {
+	meta := make(map[string]string)
+	if page.IP != "" {
+		meta["server_ip"] = page.IP
+	}
+	if page.Proxy != "" {
+		meta["proxy"] = page.Proxy
+	}
+	if page.Charset != "" {
+		meta["charset"] = page.Charset
+	}
+	// Метка document отличает скачанные файлы от страниц в статистике
+	if doc := page.Document; doc != nil {
+		for k, v := range doc.Meta {
+			meta[k] = v
+		}
+		meta["document"] = "true"
+	}
+	if len(meta) == 0 {
+		return nil
+	}
+	return meta
+}
+
+func (w *Worker) process(ctx context.Context, task crawlTask) {
+	cr := w.crawl
+	atomic.AddInt64(&cr.inFlight, 1)
+	defer atomic.AddInt64(&cr.inFlight, -1)
+	cr.hostProgress(task.host, func(p *HostProgress) { p.Queued-- })
+
+	url := task.url
+	ctx = logging.With(ctx, logging.KeyURL, url, logging.KeyHost, task.host, logging.KeyAttempt, 1)
+	// Каждый URL - отдельная трасса: загрузка, разбор и постановка в очередь записи
+	ctx, span := tracer.Start(ctx, "crawl.page", trace.WithNewRoot(), trace.WithAttributes(
+		attribute.String("url.full", url),
+		attribute.String("server.address", task.host),
+		attribute.Int("crawl.depth", task.depth),
+		attribute.Int64("crawl.run_id", cr.run.ID),
+		attribute.Int("crawl.worker", w.id),
+	))
+	defer span.End()
+
+	page, err := w.fetcher.Fetch(ctx, url)
+
+	if err != nil {
+		atomic.AddInt64(&cr.counters.FetchErrors, 1)
+		cr.hostProgress(task.host, func(p *HostProgress) { p.Failed++ })
+		if limit := downloader.LimitOf(err); limit != "" {
+			cr.mu.Lock()
+			cr.limitHits[limit]++
+			cr.mu.Unlock()
+			logging.FromContext(ctx).Warn("Page fetch aborted by limit", "limit", limit, logging.Err(err))
+		} else {
+			logging.FromContext(ctx).Warn("Failed to fetch page", logging.Err(err))
+		}
+		span.SetStatus(codes.Error, err.Error())
+		return
+	}
+	atomic.AddInt64(&cr.counters.Fetched, 1)
+	cr.hostProgress(task.host, func(p *HostProgress) { p.Done++ })
+	host := task.host
+
+	htmlPage := page.HTML
+	_, extractSpan := tracer.Start(ctx, "extract")
+	content := &db.CrawledContent{
+		DOMAIN:        host,
+		URL:           url,
+		Status:        page.Status,
+		Metadata:      pageMetadata(page),
+		CrawledAt:     time.Now(),
+		RunID:         cr.run.ID,
+		Depth:         task.depth,
+		FetchDuration: page.Duration,
+		ContentType:   page.ContentType,
+		Span:          span.SpanContext(),
+	}
+
+	var links []string
+	if doc := page.Document; doc != nil {
+		// Ссылки из документов не обходятся
+		content.Title, content.TextContent = doc.Title, doc.Text
+		content.ContentHash = doc.Checksum
+		content.ContentLength = doc.Size
+	} else {
+		content.Title, content.TextContent = downloader.ExtractText(htmlPage)
+		content.ContentHash = hashMD5(htmlPage[int(float64(len(htmlPage))*0.8):])
+		content.ContentLength = len(htmlPage)
+		if inDomain(host, cr.spec.Domain) {
+			links = downloader.ExtractLinks(htmlPage, "http://"+host)
+			links, content.LinkOrigins = mergeRuntimeLinks(links, page.Links)
+			content.Links = links
+		}
+	}
+	content.Language = language.Detect(content.TextContent, page.HTMLLang, page.ContentLanguage)
+	extractSpan.SetAttributes(attribute.Int("crawl.links", len(links)), attribute.String("crawl.language", content.Language))
+	extractSpan.End()
+
+	if page.Screenshot != nil || page.PDF != nil {
+		cr.saveCaptures(ctx, page, content)
+	}
+
+	// Запись уходит в фоновый batch writer, при медленной базе Add блокируется.
+	// Скачанная страница пишется и после Stop, поэтому контекст обхода здесь не используется
+	_, queueSpan := tracer.Start(ctx, "db.queue")
+	if err := cr.writer.Add(context.Background(), content); err != nil {
+		logging.FromContext(ctx).Error("Failed to queue content", logging.Err(err))
+	}
+	queueSpan.End()
+
+	if !cr.spec.follows(content.Language) {
+		// Ссылки остаются в графе, но страница на невыбранном языке не ведет обход дальше
+		logging.FromContext(ctx).Debug("Links not followed for page language", "language", content.Language)
+		return
+	}
+	for _, link := range links {
+		// Запросы к чужим API и счетчикам остаются в графе ссылок, но не обходятся
+		if _, runtime := content.LinkOrigins[link]; runtime && !linkInDomain(link, cr.spec.Domain) {
+			continue
+		}
+		if _, err := cr.enqueue(link, task.depth+1); err != nil && ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+// saveCaptures сохраняет снимок и PDF страницы в хранилище файлов и записывает ссылки на них.
+// Страница сохраняется и без них, поэтому ошибки только пишутся в лог
+func (cr *Crawl) saveCaptures(ctx context.Context, page *downloader.Page, content *db.CrawledContent) {
+	ctx, span := tracer.Start(ctx, "capture.store")
+	defer span.End()
+	// Загрузка в хранилище доводится до конца и после Stop, как и запись страницы
+	ctx = context.WithoutCancel(ctx)
+	logger := logging.FromContext(ctx)
+
+	key := fmt.Sprintf("%d/%s", cr.run.ID, hashSHA256(content.URL)[:16])
+	content.VisualHash = page.VisualHash
+	if page.Screenshot != nil {
+		ext := strings.TrimPrefix(page.ScreenshotType, "image/")
+		ref, err := cr.blobs.Put(ctx, key+"."+ext, page.Screenshot, page.ScreenshotType)
+		if err != nil {
+			logger.Warn("Failed to store screenshot", logging.Err(err))
+		} else {
+			content.ScreenshotRef = ref
+		}
+	}
+	if page.PDF != nil {
+		ref, err := cr.blobs.Put(ctx, key+".pdf", page.PDF, "application/pdf")
+		if err != nil {
+			logger.Warn("Failed to store PDF", logging.Err(err))
+		} else {
+			content.PDFRef = ref
+		}
+	}
+}
+
+func linkInDomain(link, domain string) bool {
+	host, err := downloader.GetHost(link)
+	return err == nil && inDomain(host, domain)
+}
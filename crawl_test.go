@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"web_crawler/internal/db"
+	"web_crawler/internal/downloader"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestCrawl обход без воркеров и базы с очередью на size URL
+func newTestCrawl(spec CrawlSpec, size int) *Crawl {
+	ctx, cancel := context.WithCancel(context.Background())
+	var m sync.RWMutex
+	return &Crawl{
+		spec:      spec,
+		run:       &db.CrawlRun{ID: 1, StartedAt: time.Now()},
+		ctx:       ctx,
+		cancel:    cancel,
+		log:       slog.Default(),
+		pool:      downloader.CreatePool(&m),
+		queue:     make(chan crawlTask, size),
+		done:      make(chan struct{}),
+		state:     crawlRunning,
+		hosts:     make(map[string]*HostProgress),
+		limitHits: make(map[string]int64),
+	}
+}
+
+func TestCrawlControl(t *testing.T) {
+	t.Run("pause, resume and stop", func(t *testing.T) {
+		cr := newTestCrawl(CrawlSpec{}, 1)
+		assert.NoError(t, cr.waitResumed(cr.ctx))
+
+		cr.Pause()
+		assert.Equal(t, crawlPaused, cr.Progress().State)
+		resumed := make(chan error)
+		go func() { resumed <- cr.waitResumed(cr.ctx) }()
+		select {
+		case <-resumed:
+			t.Fatal("worker must wait while the crawl is paused")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		cr.Resume()
+		assert.Equal(t, crawlRunning, cr.Progress().State)
+		assert.NoError(t, <-resumed)
+		cr.Resume()
+		assert.Equal(t, crawlRunning, cr.Progress().State, "resume of a running crawl does nothing")
+
+		cr.Pause()
+		go func() { resumed <- cr.waitResumed(cr.ctx) }()
+		cr.Stop()
+		assert.ErrorIs(t, <-resumed, context.Canceled, "stop releases paused workers")
+		assert.Equal(t, crawlStopping, cr.Progress().State)
+		cr.Pause()
+		assert.Equal(t, crawlStopping, cr.Progress().State, "stopped crawl cannot be paused")
+
+		_, err := cr.Submit([]string{"https://site.test/late"})
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("submit to a full queue", func(t *testing.T) {
+		cr := newTestCrawl(CrawlSpec{}, 2)
+		defer cr.Stop()
+
+		accepted, err := cr.Submit([]string{"https://site.test/1", "https://site.test/1", "https://site.test/2", "https://site.test/3"})
+		assert.ErrorIs(t, err, errQueueFull)
+		assert.Equal(t, 2, accepted, "duplicates are not queued twice")
+		assert.Equal(t, HostProgress{Queued: 2}, cr.Progress().Hosts["site.test"])
+
+		// Отклоненный URL принимается, когда в очереди появляется место
+		<-cr.queue
+		accepted, err = cr.Submit([]string{"https://site.test/3"})
+		require.NoError(t, err)
+		assert.Equal(t, 1, accepted)
+	})
+
+	t.Run("concurrent submits never block", func(t *testing.T) {
+		cr := newTestCrawl(CrawlSpec{}, 10)
+		defer cr.Stop()
+
+		var wg sync.WaitGroup
+		var accepted int64
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 5; j++ {
+					if ok, _ := cr.enqueue(fmt.Sprintf("https://site.test/%d/%d", i, j), 1); ok {
+						atomic.AddInt64(&accepted, 1)
+					}
+				}
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int64(10), accepted)
+		assert.Len(t, cr.queue, 10)
+	})
+
+	t.Run("worker holds a task received after pause", func(t *testing.T) {
+		cr := newTestCrawl(CrawlSpec{}, 1)
+		cr.wg.Add(1)
+		w := &Worker{id: 1, crawl: cr, timeout: 10}
+		go w.Start(cr.ctx)
+		// Воркер уже ждет задачу, когда обход ставится на паузу
+		time.Sleep(50 * time.Millisecond)
+
+		cr.Pause()
+		cr.queue <- crawlTask{url: "https://site.test/", host: "site.test"}
+		assert.Eventually(t, func() bool { return len(cr.queue) == 0 }, time.Second, 10*time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		assert.Zero(t, atomic.LoadInt64(&cr.inFlight), "task must not be processed during pause")
+
+		// Без загрузчика process завершился бы паникой
+		cr.Stop()
+		cr.wg.Wait()
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"web_crawler/internal/db"
+	"web_crawler/internal/export"
+)
+
+// Export выгружает страницы в файл или stdout
+func (c *Crawler) Export(args []string) error {
+	var filter db.ExportFilter
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", export.FormatJSONL, "output format: jsonl, csv, parquet or sqlite")
+	out := fs.String("out", "", "output file (stdout by default, required for sqlite)")
+	fs.StringVar(&filter.Domain, "domain", "", "export only this domain and its subdomains")
+	statuses := fs.String("status", "", "comma-separated status codes, e.g. 200,301")
+	fs.Int64Var(&filter.RunID, "run", 0, "crawl run id")
+	from := fs.String("from", "", "start of time range (RFC3339 or YYYY-MM-DD)")
+	to := fs.String("to", "", "end of time range (RFC3339 or YYYY-MM-DD)")
+	fs.BoolVar(&filter.WithLinks, "links", false, "include outgoing links of every page")
+	fs.BoolVar(&filter.WithMetadata, "metadata", false, "include page metadata")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var err error
+	if filter.From, err = db.ParseTime(*from); err != nil {
+		return err
+	}
+	if filter.To, err = db.ParseTime(*to); err != nil {
+		return err
+	}
+	if filter.Statuses, err = db.ParseStatuses(*statuses); err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "" && *format != export.FormatSQLite {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	writer, err := export.NewWriter(*format, w, *out)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	err = c.storage.Export(context.Background(), filter, func(row *db.ExportRow) error {
+		count++
+		return writer.Write(row)
+	})
+	if cerr := writer.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("export failed after %d pages: %v", count, err)
+	}
+
+	slog.Info("Export finished", "pages", count, "format", *format)
+	return nil
+}
@@ -0,0 +1,265 @@
+package api
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"web_crawler/internal/db"
+	"web_crawler/internal/logging"
+	"web_crawler/internal/report"
+)
+
+// MaxPageLimit наибольший размер страницы выборки; больший limit уменьшается до него
+const MaxPageLimit = 1000
+
+// Data запросы к собранным данным: запуски, страницы, ссылки, статистика и поиск
+type Data struct {
+	Storage *db.PostgresStorage
+	// MainHost домен статистики, если он не указан в запросе
+	MainHost string
+}
+
+// Register добавляет маршруты Data в mux
+func (d *Data) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/runs", d.listRuns)
+	mux.HandleFunc("GET /api/runs/{id}", d.getRun)
+	mux.HandleFunc("GET /api/pages", d.listPages)
+	mux.HandleFunc("GET /api/links", d.listLinks)
+	mux.HandleFunc("GET /api/stats", d.stats)
+	mux.HandleFunc("GET /api/search", d.search)
+}
+
+// Authorize пропускает только запросы с заголовком Authorization: Bearer token
+func Authorize(token string, next http.Handler) http.Handler {
+	want := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), want) != 1 {
+			WriteError(w, http.StatusUnauthorized, errors.New("missing or invalid API token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Loopback слушает ли адрес только локальный интерфейс
+func Loopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (d *Data) listRuns(w http.ResponseWriter, r *http.Request) {
+	runs, err := d.Storage.ListRuns(r.Context())
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	WriteResponse(w, http.StatusOK, runs)
+}
+
+func (d *Data) getRun(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid run id %q", r.PathValue("id")))
+		return
+	}
+
+	run, err := d.Storage.GetRun(r.Context(), id)
+	switch {
+	case errors.Is(err, db.ErrRunNotFound):
+		WriteError(w, http.StatusNotFound, err)
+	case err != nil:
+		WriteError(w, http.StatusInternalServerError, err)
+	default:
+		WriteResponse(w, http.StatusOK, run)
+	}
+}
+
+func (d *Data) listPages(w http.ResponseWriter, r *http.Request) {
+	p := queryParams{values: r.URL.Query()}
+	f := db.ExportFilter{
+		Domain:       p.values.Get("domain"),
+		RunID:        p.int64("run"),
+		WithLinks:    p.bool("links"),
+		WithMetadata: p.bool("metadata"),
+	}
+	f.From = p.time("from")
+	f.To = p.time("to")
+	if p.err == nil {
+		f.Statuses, p.err = db.ParseStatuses(p.values.Get("status"))
+	}
+	limit, offset := p.page()
+	if p.err != nil {
+		WriteError(w, http.StatusBadRequest, p.err)
+		return
+	}
+
+	pages, err := d.Storage.ListPages(r.Context(), f, limit, offset)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	WriteResponse(w, http.StatusOK, pages)
+}
+
+func (d *Data) listLinks(w http.ResponseWriter, r *http.Request) {
+	p := queryParams{values: r.URL.Query()}
+	f := db.LinkFilter{
+		RunID:  p.int64("run"),
+		Source: p.values.Get("source"),
+		Host:   p.values.Get("host"),
+		Origin: p.values.Get("origin"),
+	}
+	limit, offset := p.page()
+	if p.err != nil {
+		WriteError(w, http.StatusBadRequest, p.err)
+		return
+	}
+
+	links, err := d.Storage.ListLinks(r.Context(), f, limit, offset)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	WriteResponse(w, http.StatusOK, links)
+}
+
+func (d *Data) stats(w http.ResponseWriter, r *http.Request) {
+	p := queryParams{values: r.URL.Query()}
+	f := db.StatFilter{
+		Domain:     p.values.Get("domain"),
+		RunID:      p.int64("run"),
+		RateBucket: p.values.Get("bucket"),
+	}
+	if f.Domain == "" {
+		f.Domain = d.MainHost
+	}
+	f.From = p.time("from")
+	f.To = p.time("to")
+	if p.err == nil {
+		p.err = f.Validate()
+	}
+	if p.err != nil {
+		WriteError(w, http.StatusBadRequest, p.err)
+		return
+	}
+	if f.RunID == 0 && !p.bool("all") {
+		var err error
+		if f.RunID, err = d.Storage.LatestRunID(r.Context()); err != nil {
+			WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	st, err := d.Storage.Stats(r.Context(), f)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	WriteResponse(w, http.StatusOK, report.New(f, st))
+}
+
+func (d *Data) search(w http.ResponseWriter, r *http.Request) {
+	p := queryParams{values: r.URL.Query()}
+	sq := db.SearchQuery{
+		Text:   p.values.Get("q"),
+		Lang:   p.values.Get("lang"),
+		Domain: p.values.Get("domain"),
+		RunID:  p.int64("run"),
+	}
+	sq.Limit, sq.Offset = p.page()
+	if p.err == nil {
+		p.err = sq.Validate()
+	}
+	if p.err != nil {
+		WriteError(w, http.StatusBadRequest, p.err)
+		return
+	}
+	if sq.RunID == 0 && !p.bool("all") {
+		var err error
+		if sq.RunID, err = d.Storage.LatestRunID(r.Context()); err != nil {
+			WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
+	res, err := d.Storage.Search(r.Context(), sq)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	WriteResponse(w, http.StatusOK, res)
+}
+
+// queryParams разбирает параметры запроса, запоминая первую ошибку
+type queryParams struct {
+	values url.Values
+	err    error
+}
+
+func (p *queryParams) int64(name string) int64 {
+	v := p.values.Get(name)
+	if v == "" || p.err != nil {
+		return 0
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		p.err = fmt.Errorf("invalid %s %q", name, v)
+	}
+	return n
+}
+
+func (p *queryParams) int(name string) int {
+	return int(p.int64(name))
+}
+
+// page limit и offset выборки: отрицательные значения - ошибка, limit больше MaxPageLimit уменьшается
+func (p *queryParams) page() (limit, offset int) {
+	limit, offset = p.int("limit"), p.int("offset")
+	if p.err == nil && (limit < 0 || offset < 0) {
+		p.err = errors.New("limit and offset must not be negative")
+	}
+	return min(limit, MaxPageLimit), offset
+}
+
+func (p *queryParams) bool(name string) bool {
+	v := p.values.Get(name)
+	return v == "1" || v == "true"
+}
+
+func (p *queryParams) time(name string) time.Time {
+	if p.err != nil {
+		return time.Time{}
+	}
+	t, err := db.ParseTime(p.values.Get(name))
+	p.err = err
+	return t
+}
+
+// WriteResponse отдает v в JSON
+func WriteResponse(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("Failed to write response", logging.Err(err))
+	}
+}
+
+// WriteError отдает ошибку в виде {"error": "..."}
+func WriteError(w http.ResponseWriter, status int, err error) {
+	WriteResponse(w, status, map[string]string{"error": err.Error()})
+}
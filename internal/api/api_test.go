@@ -0,0 +1,116 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"web_crawler/internal/db"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testHandler(t *testing.T) (http.Handler, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	mux := http.NewServeMux()
+	(&Data{Storage: db.NewStorage(conn), MainHost: "example.com"}).Register(mux)
+	return mux, mock
+}
+
+func get(h http.Handler, target string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+	return rec
+}
+
+func TestData(t *testing.T) {
+	t.Run("invalid requests", func(t *testing.T) {
+		h, mock := testHandler(t)
+		for _, target := range []string{
+			"/api/runs/abc",
+			"/api/pages?offset=-1",
+			"/api/pages?limit=ten",
+			"/api/pages?status=ok",
+			"/api/links?limit=-5",
+			"/api/stats?from=yesterday",
+			"/api/stats?bucket=week",
+			"/api/stats?from=2025-02-01&to=2025-01-01",
+			"/api/search?q=books&lang=xx",
+			"/api/search?run=1",
+			"/api/search?q=books&offset=-10",
+		} {
+			rec := get(h, target)
+			assert.Equal(t, http.StatusBadRequest, rec.Code, target)
+			assert.Contains(t, rec.Body.String(), `"error"`, target)
+		}
+		assert.NoError(t, mock.ExpectationsWereMet(), "invalid requests must not reach the database")
+	})
+
+	t.Run("run not found", func(t *testing.T) {
+		h, mock := testHandler(t)
+		mock.ExpectQuery("FROM crawl_runs WHERE id = \\$1").WithArgs(int64(7)).WillReturnError(sql.ErrNoRows)
+
+		assert.Equal(t, http.StatusNotFound, get(h, "/api/runs/7").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("database failures", func(t *testing.T) {
+		h, mock := testHandler(t)
+		down := errors.New("connection refused")
+
+		mock.ExpectQuery("SELECT MAX\\(id\\) FROM crawl_runs").WillReturnError(down)
+		assert.Equal(t, http.StatusInternalServerError, get(h, "/api/stats").Code)
+
+		mock.ExpectQuery("SELECT MAX\\(id\\) FROM crawl_runs").WillReturnError(down)
+		assert.Equal(t, http.StatusInternalServerError, get(h, "/api/search?q=books").Code)
+
+		mock.ExpectQuery("FROM crawl_runs ORDER BY id DESC").WillReturnError(down)
+		assert.Equal(t, http.StatusInternalServerError, get(h, "/api/runs").Code)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("page size is clamped", func(t *testing.T) {
+		h, mock := testHandler(t)
+		mock.ExpectQuery("LIMIT \\$1 OFFSET \\$2").WithArgs(MaxPageLimit, 20).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+		rec := get(h, "/api/links?limit=1000000&offset=20")
+		assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestAuthorize(t *testing.T) {
+	h := Authorize("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	for header, status := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"secret":        http.StatusUnauthorized,
+		"Bearer secret": http.StatusNoContent,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/runs", nil)
+		req.Header.Set("Authorization", header)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, status, rec.Code, header)
+	}
+}
+
+func TestLoopback(t *testing.T) {
+	assert.True(t, Loopback("127.0.0.1:8080"))
+	assert.True(t, Loopback("[::1]:8080"))
+	assert.True(t, Loopback("localhost:8080"))
+	assert.False(t, Loopback(":8080"))
+	assert.False(t, Loopback("0.0.0.0:8080"))
+	assert.False(t, Loopback("10.0.0.5:8080"))
+}
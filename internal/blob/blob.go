@@ -0,0 +1,198 @@
+package blob
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// redacted заменяет секреты в снимке настроек
+const redacted = "xxxxx"
+
+// Config где хранить файлы: локальный каталог dir или S3-совместимое хранилище
+type Config struct {
+	Dir string    `json:"dir"`
+	S3  *S3Config `json:"s3"`
+}
+
+// S3Config бакет S3-совместимого хранилища (AWS S3, MinIO, Yandex Object Storage и т.д.)
+type S3Config struct {
+	// Endpoint адрес хранилища, например https://storage.yandexcloud.net; по умолчанию AWS S3 региона
+	Endpoint  string `json:"endpoint"`
+	Region    string `json:"region"`
+	Bucket    string `json:"bucket"`
+	AccessKey string `json:"access_key"`
+	SecretKey string `json:"secret_key"`
+	// Prefix добавляется к ключам объектов
+	Prefix string `json:"prefix"`
+	// PathStyle адреса вида endpoint/bucket/key вместо bucket.endpoint/key, нужны MinIO
+	PathStyle bool `json:"path_style"`
+}
+
+// Redacted копия настроек без секретного ключа, для сохранения вместе с запуском
+func (c Config) Redacted() Config {
+	if c.S3 != nil {
+		s3 := *c.S3
+		if s3.SecretKey != "" {
+			s3.SecretKey = redacted
+		}
+		c.S3 = &s3
+	}
+	return c
+}
+
+// Store хранилище файлов
+type Store interface {
+	// Put сохраняет data под ключом key и возвращает ссылку на файл: file://... или s3://bucket/key
+	Put(ctx context.Context, key string, data []byte, contentType string) (string, error)
+	// Delete удаляет файл по ссылке, которую вернул Put; отсутствующий файл не ошибка
+	Delete(ctx context.Context, ref string) error
+}
+
+// New создает хранилище по настройкам; без настроек возвращает nil
+func New(cfg Config) (Store, error) {
+	switch {
+	case cfg.S3 != nil && cfg.Dir != "":
+		return nil, fmt.Errorf("only one of dir and s3 can be set")
+	case cfg.S3 != nil:
+		return NewS3Store(*cfg.S3)
+	case cfg.Dir != "":
+		return NewLocalStore(cfg.Dir)
+	}
+	return nil, nil
+}
+
+// LocalStore файлы в локальном каталоге; ключ - относительный путь
+type LocalStore struct {
+	dir string
+}
+
+func NewLocalStore(dir string) (*LocalStore, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, fmt.Errorf("invalid blob dir: %v", err)
+	}
+	if err := os.MkdirAll(abs, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create blob dir: %v", err)
+	}
+	return &LocalStore{dir: abs}, nil
+}
+
+func (s *LocalStore) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(key))
+	if !strings.HasPrefix(path, s.dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid key %q", key)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	// Файл появляется целиком: сначала пишется во временный, затем переименовывается
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	return "file://" + filepath.ToSlash(path), nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, ref string) error {
+	path, ok := strings.CutPrefix(ref, "file://")
+	path = filepath.Clean(filepath.FromSlash(path))
+	if !ok || !strings.HasPrefix(path, s.dir+string(filepath.Separator)) {
+		return fmt.Errorf("ref %q is not in %s", ref, s.dir)
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// S3Store объекты в бакете S3; запросы подписываются AWS Signature Version 4
+type S3Store struct {
+	cfg    S3Config
+	client *http.Client
+	// now время подписи, подменяется в тестах
+	now func() time.Time
+}
+
+func NewS3Store(cfg S3Config) (*S3Store, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("s3: bucket is required")
+	}
+	if cfg.AccessKey == "" || cfg.SecretKey == "" {
+		return nil, fmt.Errorf("s3: access_key and secret_key are required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	if !strings.HasPrefix(cfg.Endpoint, "http://") && !strings.HasPrefix(cfg.Endpoint, "https://") {
+		return nil, fmt.Errorf("s3: endpoint must start with http:// or https://")
+	}
+	return &S3Store{cfg: cfg, client: &http.Client{Timeout: time.Minute}, now: time.Now}, nil
+}
+
+// objectURL адрес объекта в стиле path или virtual-hosted
+func (s *S3Store) objectURL(key string) string {
+	path := "/" + uriEncode(key, false)
+	if s.cfg.PathStyle {
+		return s.cfg.Endpoint + "/" + s.cfg.Bucket + path
+	}
+	scheme, host, _ := strings.Cut(s.cfg.Endpoint, "://")
+	return scheme + "://" + s.cfg.Bucket + "." + host + path
+}
+
+func (s *S3Store) Put(ctx context.Context, key string, data []byte, contentType string) (string, error) {
+	key = s.cfg.Prefix + key
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	sign(req, s.cfg, hashHex(data), s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("s3 put %s: %v", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("s3 put %s: status %d", key, resp.StatusCode)
+	}
+	return "s3://" + s.cfg.Bucket + "/" + key, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, ref string) error {
+	key, ok := strings.CutPrefix(ref, "s3://"+s.cfg.Bucket+"/")
+	if !ok {
+		return fmt.Errorf("ref %q is not in bucket %s", ref, s.cfg.Bucket)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	sign(req, s.cfg, hashHex(nil), s.now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("s3 delete %s: %v", key, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("s3 delete %s: status %d", key, resp.StatusCode)
+	}
+	return nil
+}
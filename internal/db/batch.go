@@ -0,0 +1,321 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"web_crawler/internal/logging"
+	"web_crawler/internal/metrics"
+	"web_crawler/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var ErrWriterClosed = errors.New("batch writer is closed")
+
+// maxParams предел PostgreSQL на число параметров одного запроса
+const maxParams = 65535
+
+// BatchConfig настройки пакетной записи
+type BatchConfig struct {
+	Size            int `json:"size"`
+	FlushIntervalMs int `json:"flush_interval_ms"`
+	QueueSize       int `json:"queue_size"`
+}
+
+func (c BatchConfig) withDefaults() BatchConfig {
+	if c.Size <= 0 {
+		c.Size = 100
+	}
+	if c.FlushIntervalMs <= 0 {
+		c.FlushIntervalMs = 1000
+	}
+	if c.QueueSize <= 0 {
+		c.QueueSize = c.Size * 10
+	}
+	return c
+}
+
+// RowResult результат записи одной строки.
+// Saved == false и Err == nil означает, что строка с таким URL уже есть в запуске
+type RowResult struct {
+	Content *CrawledContent
+	Saved   bool
+	Err     error
+}
+
+// BatchWriter буферизует страницы и пишет их в PostgreSQL пачками
+// в отдельной горутине. Когда база не успевает, очередь заполняется
+// и Add блокируется.
+type BatchWriter struct {
+	db       *sql.DB
+	cfg      BatchConfig
+	in       chan *CrawledContent
+	onResult func(RowResult)
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+	// err ошибка последней неудачной записи пачки; читается после закрытия done
+	err error
+}
+
+func (s *PostgresStorage) NewBatchWriter(cfg BatchConfig, onResult func(RowResult)) *BatchWriter {
+	cfg = cfg.withDefaults()
+	if onResult == nil {
+		onResult = func(RowResult) {}
+	}
+	w := &BatchWriter{
+		db:       s.db,
+		cfg:      cfg,
+		in:       make(chan *CrawledContent, cfg.QueueSize),
+		onResult: onResult,
+		done:     make(chan struct{}),
+	}
+	go w.loop()
+	return w
+}
+
+// Add ставит страницу в очередь на запись
+func (w *BatchWriter) Add(ctx context.Context, content *CrawledContent) error {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		return ErrWriterClosed
+	}
+
+	select {
+	case w.in <- content:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close записывает остаток очереди и останавливает writer. Возвращает ошибку последней пачки,
+// строки которой не удалось записать, в том числе при записи остатка
+func (w *BatchWriter) Close() error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.in)
+	}
+	w.mu.Unlock()
+
+	<-w.done
+	return w.err
+}
+
+func (w *BatchWriter) loop() {
+	defer close(w.done)
+
+	ticker := time.NewTicker(time.Duration(w.cfg.FlushIntervalMs) * time.Millisecond)
+	defer ticker.Stop()
+
+	batch := make([]*CrawledContent, 0, w.cfg.Size)
+	for {
+		select {
+		case content, ok := <-w.in:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			batch = append(batch, content)
+			if len(batch) >= w.cfg.Size {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			w.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush записывает пачку и запоминает ошибку, если часть строк не записана
+func (w *BatchWriter) flush(batch []*CrawledContent) {
+	if len(batch) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	saved, err := insertBatch(ctx, w.db, batch)
+	if err == nil {
+		for _, content := range batch {
+			w.onResult(RowResult{Content: content, Saved: saved[content.URL]})
+		}
+		return
+	}
+
+	// Пачка не прошла целиком - пишем построчно, чтобы найти виноватую строку
+	metrics.Retries.WithLabelValues("batch_row").Add(float64(len(batch)))
+	slog.Warn("Batch insert failed, retrying rows one by one",
+		"rows", len(batch), logging.KeyAttempt, 2, logging.Err(err))
+	failed := 0
+	for _, content := range batch {
+		saved, rowErr := insertBatch(ctx, w.db, []*CrawledContent{content})
+		w.onResult(RowResult{Content: content, Saved: saved[content.URL], Err: rowErr})
+		if rowErr != nil {
+			failed++
+			err = rowErr
+		}
+	}
+	if failed > 0 {
+		w.err = fmt.Errorf("failed to save %d of %d rows: %v", failed, len(batch), err)
+	}
+}
+
+// insertBatch вставляет строки multi-row INSERT в одной транзакции вместе с их ссылками
+// и возвращает множество URL, которые действительно были записаны. Большая пачка делится
+// на запросы, чтобы не превысить предел параметров
+func insertBatch(ctx context.Context, db *sql.DB, batch []*CrawledContent) (saved map[string]bool, err error) {
+	defer metrics.ObserveDB("insert_batch")()
+
+	// Пачка пишется отдельно от загрузки, поэтому связывается со спанами страниц ссылками
+	links := make([]trace.Link, 0, len(batch))
+	for _, content := range batch {
+		if content.Span.IsValid() {
+			links = append(links, trace.Link{SpanContext: content.Span})
+		}
+	}
+	ctx, span := tracer.Start(ctx, "db.insert_batch",
+		trace.WithLinks(links...), trace.WithAttributes(attribute.Int("db.rows", len(batch))))
+	defer func() {
+		span.SetAttributes(attribute.Int("db.rows_saved", len(saved)))
+		tracing.End(span, err)
+	}()
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	maxRows := maxParams / contentColumnCount
+	saved = make(map[string]bool, len(batch))
+	for start := 0; start < len(batch); start += maxRows {
+		urls, err := insertContent(ctx, tx, batch[start:min(start+maxRows, len(batch))])
+		if err != nil {
+			return nil, err
+		}
+		for u := range urls {
+			saved[u] = true
+		}
+	}
+
+	if err := insertLinks(ctx, tx, batch, saved); err != nil {
+		return nil, err
+	}
+
+	return saved, tx.Commit()
+}
+
+// insertContent вставляет строки одним multi-row INSERT и возвращает записанные URL
+func insertContent(ctx context.Context, tx *sql.Tx, rows []*CrawledContent) (map[string]bool, error) {
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO crawled_content (" + contentColumns + ") VALUES ")
+
+	args := make([]interface{}, 0, len(rows)*contentColumnCount)
+	for i, content := range rows {
+		values, err := content.values()
+		if err != nil {
+			return nil, err
+		}
+
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		writePlaceholders(&sb, len(args), len(values))
+		args = append(args, values...)
+	}
+	sb.WriteString(" ON CONFLICT (run_id, url) DO NOTHING RETURNING url")
+
+	return scanURLs(tx.QueryContext(ctx, sb.String(), args...))
+}
+
+func insertLinks(ctx context.Context, tx *sql.Tx, batch []*CrawledContent, saved map[string]bool) error {
+	const columns = 6
+	const maxRows = maxParams / columns
+
+	var args []interface{}
+	flush := func() error {
+		if len(args) == 0 {
+			return nil
+		}
+
+		var sb strings.Builder
+		sb.WriteString("INSERT INTO links (run_id, source_url, target_url, target_host, crawled_at, origin) VALUES ")
+		for i := 0; i < len(args)/columns; i++ {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			writePlaceholders(&sb, i*columns, columns)
+		}
+
+		_, err := tx.ExecContext(ctx, sb.String(), args...)
+		args = args[:0]
+		return err
+	}
+
+	for _, content := range batch {
+		if !saved[content.URL] {
+			continue
+		}
+
+		for _, link := range content.Links {
+			u, err := url.Parse(link)
+			if err != nil {
+				continue
+			}
+
+			origin := content.LinkOrigins[link]
+			if origin == "" {
+				origin = LinkOriginAnchor
+			}
+			args = append(args, nullID(content.RunID), content.URL, link, u.Hostname(), content.CrawledAt, origin)
+			if len(args) >= maxRows*columns {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return flush()
+}
+
+// writePlaceholders пишет "($offset+1, ..., $offset+n)"
+func writePlaceholders(sb *strings.Builder, offset, n int) {
+	sb.WriteString("(")
+	for j := 1; j <= n; j++ {
+		if j > 1 {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(sb, "$%d", offset+j)
+	}
+	sb.WriteString(")")
+}
+
+func scanURLs(rows *sql.Rows, err error) (map[string]bool, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := make(map[string]bool)
+	for rows.Next() {
+		var rowURL string
+		if err := rows.Scan(&rowURL); err != nil {
+			return nil, err
+		}
+		urls[rowURL] = true
+	}
+	return urls, rows.Err()
+}
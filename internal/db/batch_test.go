@@ -0,0 +1,254 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"web_crawler/internal/metrics"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testContent(url string) *CrawledContent {
+	return &CrawledContent{
+		DOMAIN:      "example.com",
+		URL:         url,
+		TextContent: "text",
+		Title:       "title",
+		Status:      200,
+		ContentHash: "hash-" + url,
+		CrawledAt:   time.Now(),
+	}
+}
+
+type resultCollector struct {
+	mu      sync.Mutex
+	results []RowResult
+}
+
+func (c *resultCollector) add(res RowResult) {
+	c.mu.Lock()
+	c.results = append(c.results, res)
+	c.mu.Unlock()
+}
+
+func TestBatchWriter(t *testing.T) {
+	t.Run("flush on size", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO crawled_content").
+			WillReturnRows(sqlmock.NewRows([]string{"url"}).AddRow("https://example.com/a"))
+		mock.ExpectCommit()
+
+		collector := &resultCollector{}
+		storage := &PostgresStorage{db: db}
+		writer := storage.NewBatchWriter(BatchConfig{Size: 2, FlushIntervalMs: 60000}, collector.add)
+
+		ctx := context.Background()
+		require.NoError(t, writer.Add(ctx, testContent("https://example.com/a")))
+		require.NoError(t, writer.Add(ctx, testContent("https://example.com/b")))
+		require.NoError(t, writer.Close())
+
+		require.Len(t, collector.results, 2)
+		assert.True(t, collector.results[0].Saved)
+		assert.False(t, collector.results[1].Saved, "conflicting URL must be reported as not saved")
+		assert.NoError(t, collector.results[1].Err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("flush on close", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO crawled_content").
+			WillReturnRows(sqlmock.NewRows([]string{"url"}).AddRow("https://example.com/a"))
+		mock.ExpectCommit()
+
+		collector := &resultCollector{}
+		storage := &PostgresStorage{db: db}
+		writer := storage.NewBatchWriter(BatchConfig{Size: 100, FlushIntervalMs: 60000}, collector.add)
+
+		require.NoError(t, writer.Add(context.Background(), testContent("https://example.com/a")))
+		require.NoError(t, writer.Close())
+
+		require.Len(t, collector.results, 1)
+		assert.True(t, collector.results[0].Saved)
+		assert.ErrorIs(t, writer.Add(context.Background(), testContent("x")), ErrWriterClosed)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("per-row failures", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO crawled_content").WillReturnError(errors.New("batch failed"))
+		mock.ExpectRollback()
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO crawled_content").
+			WillReturnRows(sqlmock.NewRows([]string{"url"}).AddRow("https://example.com/a"))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO crawled_content").WillReturnError(errors.New("bad row"))
+		mock.ExpectRollback()
+
+		collector := &resultCollector{}
+		storage := &PostgresStorage{db: db}
+		writer := storage.NewBatchWriter(BatchConfig{Size: 2, FlushIntervalMs: 60000}, collector.add)
+		retries := testutil.ToFloat64(metrics.Retries.WithLabelValues("batch_row"))
+
+		ctx := context.Background()
+		require.NoError(t, writer.Add(ctx, testContent("https://example.com/a")))
+		require.NoError(t, writer.Add(ctx, testContent("https://example.com/b")))
+		assert.EqualError(t, writer.Close(), "failed to save 1 of 2 rows: bad row")
+		assert.Error(t, writer.Close(), "repeated Close reports the same error")
+
+		assert.Equal(t, retries+2, testutil.ToFloat64(metrics.Retries.WithLabelValues("batch_row")))
+		require.Len(t, collector.results, 2)
+		assert.True(t, collector.results[0].Saved)
+		assert.NoError(t, collector.results[0].Err)
+		assert.False(t, collector.results[1].Saved)
+		assert.EqualError(t, collector.results[1].Err, "bad row")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("links of saved pages", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		saved := testContent("https://example.com/a")
+		saved.Links = []string{"https://example.com/b", "https://other.org/c"}
+		saved.LinkOrigins = map[string]string{"https://other.org/c": "xhr"}
+		skipped := testContent("https://example.com/old")
+		skipped.Links = []string{"https://example.com/d"}
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO crawled_content").
+			WillReturnRows(sqlmock.NewRows([]string{"url"}).AddRow(saved.URL))
+		mock.ExpectExec("INSERT INTO links").
+			WithArgs(
+				nullID(0), saved.URL, "https://example.com/b", "example.com", saved.CrawledAt, "anchor",
+				nullID(0), saved.URL, "https://other.org/c", "other.org", saved.CrawledAt, "xhr",
+			).
+			WillReturnResult(sqlmock.NewResult(0, 2))
+		mock.ExpectCommit()
+
+		storage := &PostgresStorage{db: db}
+		writer := storage.NewBatchWriter(BatchConfig{Size: 2, FlushIntervalMs: 60000}, nil)
+		require.NoError(t, writer.Add(context.Background(), saved))
+		require.NoError(t, writer.Add(context.Background(), skipped))
+		require.NoError(t, writer.Close())
+
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("backpressure", func(t *testing.T) {
+		db, mock, err := sqlmock.New()
+		require.NoError(t, err)
+		defer db.Close()
+
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO crawled_content").
+			WillDelayFor(200 * time.Millisecond).
+			WillReturnRows(sqlmock.NewRows([]string{"url"}))
+		mock.ExpectCommit()
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO crawled_content").
+			WillReturnRows(sqlmock.NewRows([]string{"url"}))
+		mock.ExpectCommit()
+
+		storage := &PostgresStorage{db: db}
+		writer := storage.NewBatchWriter(BatchConfig{Size: 1, FlushIntervalMs: 60000, QueueSize: 1}, nil)
+
+		require.NoError(t, writer.Add(context.Background(), testContent("https://example.com/a")))
+		require.NoError(t, writer.Add(context.Background(), testContent("https://example.com/b")))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, writer.Add(ctx, testContent("https://example.com/c")), context.DeadlineExceeded)
+
+		require.NoError(t, writer.Close())
+	})
+}
+
+func TestInsertBatchPlaceholders(t *testing.T) {
+	var query string
+	db, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(sqlmock.QueryMatcherFunc(func(_, actual string) error {
+		if strings.HasPrefix(actual, "INSERT INTO crawled_content") {
+			query = actual
+		}
+		return nil
+	})))
+	require.NoError(t, err)
+	defer db.Close()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO crawled_content").WillReturnRows(sqlmock.NewRows([]string{"url"}))
+	mock.ExpectCommit()
+
+	batch := []*CrawledContent{
+		testContent("https://example.com/a"),
+		testContent("https://example.com/b"),
+		testContent("https://example.com/c"),
+	}
+	values, err := batch[0].values()
+	require.NoError(t, err)
+	require.Len(t, values, contentColumnCount, "values must match contentColumns")
+
+	_, err = insertBatch(context.Background(), db, batch)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	rows := regexp.MustCompile(`\(\$[^)]*\)`).FindAllString(query, -1)
+	require.Len(t, rows, len(batch))
+	n := 0
+	for _, row := range rows {
+		params := regexp.MustCompile(`\$(\d+)`).FindAllStringSubmatch(row, -1)
+		require.Len(t, params, contentColumnCount, row)
+		for _, p := range params {
+			n++
+			assert.Equal(t, strconv.Itoa(n), p[1], "placeholders must be consecutive")
+		}
+	}
+}
+
+func TestInsertBatchChunks(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	maxRows := maxParams / contentColumnCount
+	batch := make([]*CrawledContent, maxRows+1)
+	for i := range batch {
+		batch[i] = testContent(fmt.Sprintf("https://example.com/%d", i))
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO crawled_content").
+		WillReturnRows(sqlmock.NewRows([]string{"url"}).AddRow(batch[0].URL))
+	mock.ExpectQuery("INSERT INTO crawled_content").
+		WillReturnRows(sqlmock.NewRows([]string{"url"}).AddRow(batch[maxRows].URL))
+	mock.ExpectCommit()
+
+	saved, err := insertBatch(context.Background(), db, batch)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{batch[0].URL: true, batch[maxRows].URL: true}, saved)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
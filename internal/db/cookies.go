@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// Cookie cookie краулера, сохраненная между запусками
+type Cookie struct {
+	Domain string
+	Path   string
+	Name   string
+	Value  string
+	// Expires нулевое значение у сессионной cookie
+	Expires  time.Time
+	Secure   bool
+	HttpOnly bool
+	// HostOnly cookie отправляется только самому Domain, без поддоменов
+	HostOnly bool
+}
+
+// LoadCookies возвращает сохраненные cookie, срок которых не истек
+func (s *PostgresStorage) LoadCookies(ctx context.Context) ([]Cookie, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT domain, path, name, value, expires, secure, http_only, host_only
+		FROM cookies WHERE expires IS NULL OR expires > NOW()`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cookies []Cookie
+	for rows.Next() {
+		var c Cookie
+		var expires sql.NullTime
+		if err := rows.Scan(&c.Domain, &c.Path, &c.Name, &c.Value, &expires, &c.Secure, &c.HttpOnly, &c.HostOnly); err != nil {
+			return nil, err
+		}
+		if expires.Valid {
+			c.Expires = expires.Time
+		}
+		cookies = append(cookies, c)
+	}
+	return cookies, rows.Err()
+}
+
+// SaveCookies заменяет сохраненные cookie содержимым jar
+func (s *PostgresStorage) SaveCookies(ctx context.Context, cookies []Cookie) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM cookies`); err != nil {
+		return err
+	}
+	for _, c := range cookies {
+		var expires sql.NullTime
+		if !c.Expires.IsZero() {
+			expires = sql.NullTime{Time: c.Expires, Valid: true}
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO cookies (domain, path, name, value, expires, secure, http_only, host_only)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+			c.Domain, c.Path, c.Name, c.Value, expires, c.Secure, c.HttpOnly, c.HostOnly)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,56 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresStorage_Cookies(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storage := &PostgresStorage{db: db}
+	ctx := context.Background()
+	expires := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("load", func(t *testing.T) {
+		mock.ExpectQuery("SELECT domain, path, name, value, expires, secure, http_only, host_only FROM cookies").
+			WillReturnRows(sqlmock.NewRows([]string{"domain", "path", "name", "value", "expires", "secure", "http_only", "host_only"}).
+				AddRow("example.com", "/", "sid", "abc", expires, true, true, false).
+				AddRow("shop.example.com", "/cart", "cart", "1", nil, false, false, true))
+
+		cookies, err := storage.LoadCookies(ctx)
+		require.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+		assert.Equal(t, []Cookie{
+			{Domain: "example.com", Path: "/", Name: "sid", Value: "abc", Expires: expires, Secure: true, HttpOnly: true},
+			{Domain: "shop.example.com", Path: "/cart", Name: "cart", Value: "1", HostOnly: true},
+		}, cookies)
+	})
+
+	t.Run("save replaces stored cookies", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM cookies").WillReturnResult(sqlmock.NewResult(0, 3))
+		mock.ExpectExec("INSERT INTO cookies").
+			WithArgs("example.com", "/", "sid", "abc", sql.NullTime{Time: expires, Valid: true}, true, true, false).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectExec("INSERT INTO cookies").
+			WithArgs("shop.example.com", "/cart", "cart", "1", sql.NullTime{}, false, false, true).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		err := storage.SaveCookies(ctx, []Cookie{
+			{Domain: "example.com", Path: "/", Name: "sid", Value: "abc", Expires: expires, Secure: true, HttpOnly: true},
+			{Domain: "shop.example.com", Path: "/cart", Name: "cart", Value: "1", HostOnly: true},
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
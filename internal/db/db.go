@@ -5,12 +5,18 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
+	"strings"
 	"time"
 
+	"web_crawler/internal/tracing"
+
 	_ "github.com/lib/pq"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = tracing.Tracer("db")
+
 // Структура для хранения контента
 type CrawledContent struct {
 	DOMAIN string
@@ -22,6 +28,70 @@ type CrawledContent struct {
 	Metadata    map[string]string
 	ContentHash string
 	CrawledAt   time.Time
+
+	RunID         int64
+	Depth         int
+	FetchDuration time.Duration
+	ContentType   string
+	ContentLength int
+	// Links исходящие ссылки страницы, пишутся в таблицу links
+	Links []string
+	// LinkOrigins происхождение ссылок, найденных не в <a href> (xhr, history, onclick и т.д.)
+	LinkOrigins map[string]string
+	// ScreenshotRef и PDFRef ссылки на снимок и PDF страницы в хранилище файлов
+	ScreenshotRef string
+	PDFRef        string
+	// VisualHash перцептивный хеш снимка
+	VisualHash string
+	// Language код языка текста, например "ru" или "en"; пустой, если язык не определен
+	Language string
+	// Span спан загрузки страницы; спан записи пачки ссылается на него
+	Span trace.SpanContext
+}
+
+const contentColumns = `domain, url, text_content, title, status, metadata, content_hash, crawled_at,
+		run_id, depth, fetch_ms, content_type, content_length, screenshot_ref, pdf_ref, visual_hash, language`
+
+// contentColumnCount число колонок в contentColumns и значений в values
+var contentColumnCount = strings.Count(contentColumns, ",") + 1
+
+// nullID превращает нулевой идентификатор в NULL
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
+
+// nullString превращает пустую строку в NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+// values возвращает значения в порядке contentColumns
+func (c *CrawledContent) values() ([]interface{}, error) {
+	metadataJSON, err := json.Marshal(c.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metadata: %v", err)
+	}
+
+	return []interface{}{
+		c.DOMAIN,
+		c.URL,
+		//c.HTML,
+		c.TextContent,
+		c.Title,
+		c.Status,
+		metadataJSON,
+		c.ContentHash,
+		c.CrawledAt,
+		nullID(c.RunID),
+		c.Depth,
+		c.FetchDuration.Milliseconds(),
+		c.ContentType,
+		c.ContentLength,
+		nullString(c.ScreenshotRef),
+		nullString(c.PDFRef),
+		nullString(c.VisualHash),
+		nullString(c.Language),
+	}, nil
 }
 
 // DatabaseConfig настройки подключения
@@ -62,7 +132,12 @@ func NewPostgresStorage(cfg DatabaseConfig) (*PostgresStorage, error) {
 		return nil, fmt.Errorf("database ping failed: %v", err)
 	}
 
-	return &PostgresStorage{db: db}, nil
+	return NewStorage(db), nil
+}
+
+// NewStorage хранилище поверх уже открытого подключения
+func NewStorage(conn *sql.DB) *PostgresStorage {
+	return &PostgresStorage{db: conn}
 }
 
 // Init создает таблицы (вызывается при старте)
@@ -75,42 +150,86 @@ func (s *PostgresStorage) Init() error {
 		title TEXT,
 		status INT,
 		metadata JSONB,
-		content_hash TEXT NOT NULL UNIQUE,
+		content_hash TEXT NOT NULL,
 		crawled_at TIMESTAMP WITH TIME ZONE NOT NULL,
 		created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 	);
 	
+	-- дедупликация идет по URL внутри запуска, одинаковый контент на разных страницах допустим
+	ALTER TABLE crawled_content DROP CONSTRAINT IF EXISTS crawled_content_content_hash_key;
+
+	ALTER TABLE crawled_content
+		ADD COLUMN IF NOT EXISTS run_id BIGINT,
+		ADD COLUMN IF NOT EXISTS depth INT NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS fetch_ms BIGINT,
+		ADD COLUMN IF NOT EXISTS content_type TEXT,
+		ADD COLUMN IF NOT EXISTS content_length INT;
+
+	CREATE TABLE IF NOT EXISTS crawl_runs (
+		id BIGSERIAL PRIMARY KEY,
+		seeds TEXT[] NOT NULL,
+		settings JSONB,
+		status TEXT NOT NULL,
+		started_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+		finished_at TIMESTAMP WITH TIME ZONE,
+		pages_fetched INT NOT NULL DEFAULT 0,
+		pages_saved INT NOT NULL DEFAULT 0,
+		pages_duplicate INT NOT NULL DEFAULT 0,
+		fetch_errors INT NOT NULL DEFAULT 0,
+		save_errors INT NOT NULL DEFAULT 0
+	);
+
+	ALTER TABLE crawl_runs ADD COLUMN IF NOT EXISTS dns_stats JSONB;
+	ALTER TABLE crawl_runs ADD COLUMN IF NOT EXISTS limit_hits JSONB;
+
+	CREATE TABLE IF NOT EXISTS links (
+		id BIGSERIAL PRIMARY KEY,
+		run_id BIGINT,
+		source_url TEXT NOT NULL,
+		target_url TEXT NOT NULL,
+		target_host TEXT NOT NULL,
+		crawled_at TIMESTAMP WITH TIME ZONE NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS cookies (
+		domain TEXT NOT NULL,
+		path TEXT NOT NULL,
+		name TEXT NOT NULL,
+		value TEXT NOT NULL,
+		expires TIMESTAMP WITH TIME ZONE,
+		secure BOOLEAN NOT NULL DEFAULT FALSE,
+		http_only BOOLEAN NOT NULL DEFAULT FALSE,
+		host_only BOOLEAN NOT NULL DEFAULT FALSE,
+		PRIMARY KEY (domain, path, name)
+	);
+
+	ALTER TABLE links ADD COLUMN IF NOT EXISTS origin TEXT NOT NULL DEFAULT 'anchor';
+
+	ALTER TABLE crawled_content
+		ADD COLUMN IF NOT EXISTS screenshot_ref TEXT,
+		ADD COLUMN IF NOT EXISTS pdf_ref TEXT,
+		ADD COLUMN IF NOT EXISTS visual_hash TEXT;
+
+	ALTER TABLE crawled_content ADD COLUMN IF NOT EXISTS language TEXT;
+
 	CREATE INDEX IF NOT EXISTS idx_content_hash ON crawled_content(content_hash);
+	CREATE UNIQUE INDEX IF NOT EXISTS idx_run_url ON crawled_content(run_id, url);
+	DROP INDEX IF EXISTS idx_url_unique;
 	CREATE INDEX IF NOT EXISTS idx_url ON crawled_content(url);
-	CREATE INDEX IF NOT EXISTS idx_crawled_at ON crawled_content(crawled_at);`
+	CREATE INDEX IF NOT EXISTS idx_crawled_at ON crawled_content(crawled_at);
+	CREATE INDEX IF NOT EXISTS idx_links_run ON links(run_id);
+	CREATE INDEX IF NOT EXISTS idx_links_source ON links(source_url);
+	CREATE INDEX IF NOT EXISTS idx_links_target_host ON links(target_host);`
 
-	_, err := s.db.Exec(query)
+	_, err := s.db.Exec(query + searchSchema())
 	return err
 }
 
-func (s *PostgresStorage) Save(ctx context.Context, content *CrawledContent) error {
-	metadataJSON, err := json.Marshal(content.Metadata)
-	if err != nil {
-		return fmt.Errorf("failed to marshal metadata: %v", err)
-	}
-
-	query := `INSERT INTO crawled_content (
-		domain, url, text_content, title, status, metadata, content_hash, crawled_at
-	) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
-	ON CONFLICT (content_hash) DO NOTHING`
-
-	_, err = s.db.ExecContext(ctx, query,
-		content.DOMAIN,
-		content.URL,
-		//content.HTML,
-		content.TextContent,
-		content.Title,
-		content.Status,
-		metadataJSON,
-		content.ContentHash,
-		content.CrawledAt,
-	)
+func (s *PostgresStorage) Save(ctx context.Context, content *CrawledContent) (err error) {
+	ctx, span := tracer.Start(ctx, "db.save", trace.WithAttributes(attribute.String("url.full", content.URL)))
+	defer func() { tracing.End(span, err) }()
 
+	_, err = insertBatch(ctx, s.db, []*CrawledContent{content})
 	return err
 }
 
@@ -128,36 +247,6 @@ func (s *PostgresStorage) Exists(ctx context.Context, contentHash string) (bool,
 	return exists, err
 }
 
-type StatContent struct {
-	Domain string `postgres:"domain"`
-	Url    string
-	Status int
-}
-
-func (s *PostgresStorage) GetAll(table *[]StatContent) error {
-	rows, err := s.db.Query("SELECT domain, url, status FROM crawled_content")
-	if err != nil {
-		log.Fatal(err)
-		return err
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		line := &StatContent{}
-		if err := rows.Scan(&line.Domain, &line.Url, &line.Status); err != nil {
-			log.Fatal(err)
-			return err
-		}
-		*table = append(*table, *line)
-	}
-
-	if err = rows.Err(); err != nil {
-		log.Fatal(err)
-		return err
-	}
-	return nil
-}
-
 func (s *PostgresStorage) Close() error {
 	return s.db.Close()
 }
@@ -62,6 +62,7 @@ func TestPostgresStorage_Save(t *testing.T) {
 		URL:         "https://example.com",
 		TextContent: "Some text content",
 		Title:       "Example Title",
+		Language:    "en",
 		Status:      200,
 		Metadata:    map[string]string{},
 		ContentHash: "abc123",
@@ -71,7 +72,8 @@ func TestPostgresStorage_Save(t *testing.T) {
 	t.Run("successful save", func(t *testing.T) {
 		metadataJSON, _ := json.Marshal(content.Metadata)
 
-		mock.ExpectExec("INSERT INTO crawled_content").
+		mock.ExpectBegin()
+		mock.ExpectQuery("INSERT INTO crawled_content").
 			WithArgs(
 				content.DOMAIN,
 				content.URL,
@@ -81,8 +83,18 @@ func TestPostgresStorage_Save(t *testing.T) {
 				metadataJSON,
 				content.ContentHash,
 				content.CrawledAt,
+				nullID(0),
+				content.Depth,
+				int64(0),
+				content.ContentType,
+				content.ContentLength,
+				nullString(""),
+				nullString(""),
+				nullString(""),
+				nullString("en"),
 			).
-			WillReturnResult(sqlmock.NewResult(1, 1))
+			WillReturnRows(sqlmock.NewRows([]string{"url"}).AddRow(content.URL))
+		mock.ExpectCommit()
 
 		err := storage.Save(ctx, content)
 		assert.NoError(t, err)
@@ -0,0 +1,183 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// exportFetchSize сколько строк забирается из курсора за один FETCH
+const exportFetchSize = 1000
+
+// ParseStatuses коды ответа через запятую: "200,301"
+func ParseStatuses(value string) ([]int, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	var out []int
+	for _, part := range strings.Split(value, ",") {
+		status, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid status %q", part)
+		}
+		out = append(out, status)
+	}
+	return out, nil
+}
+
+// ParseTime граница периода в формате RFC 3339 или YYYY-MM-DD (местное время); пустая строка - без границы
+func ParseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: %v", value, err)
+	}
+	return t, nil
+}
+
+// ExportFilter условия выгрузки. Нулевые значения означают "без ограничения"
+type ExportFilter struct {
+	// Domain хост страницы: сам домен и его поддомены
+	Domain   string
+	Statuses []int
+	RunID    int64
+	From     time.Time
+	To       time.Time
+
+	WithLinks    bool
+	WithMetadata bool
+}
+
+// ExportRow страница в выгрузке
+type ExportRow struct {
+	RunID         int64             `json:"run_id,omitempty"`
+	Domain        string            `json:"domain"`
+	URL           string            `json:"url"`
+	Title         string            `json:"title"`
+	TextContent   string            `json:"text_content"`
+	Status        int               `json:"status"`
+	ContentType   string            `json:"content_type"`
+	ContentLength int               `json:"content_length"`
+	Depth         int               `json:"depth"`
+	FetchMs       int64             `json:"fetch_ms"`
+	ContentHash   string            `json:"content_hash"`
+	CrawledAt     time.Time         `json:"crawled_at"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+	Links         []string          `json:"links,omitempty"`
+}
+
+// exportSelect строит SELECT страниц по фильтру; порядок колонок соответствует scanExportRow
+func exportSelect(q *statQuery, f ExportFilter) string {
+	conds := []string{q.where(StatFilter{RunID: f.RunID, From: f.From, To: f.To})}
+	if f.Domain != "" {
+		conds = append(conds, q.internal("c.domain", f.Domain))
+	}
+	if len(f.Statuses) > 0 {
+		conds = append(conds, "c.status = ANY("+q.arg(pq.Array(f.Statuses))+")")
+	}
+
+	metadata := "NULL::jsonb"
+	if f.WithMetadata {
+		metadata = "c.metadata"
+	}
+	links := "NULL::text[]"
+	if f.WithLinks {
+		links = `ARRAY(SELECT l.target_url FROM links l
+			WHERE l.source_url = c.url AND l.run_id IS NOT DISTINCT FROM c.run_id ORDER BY l.id)`
+	}
+
+	return fmt.Sprintf(`SELECT c.run_id, c.domain, c.url, COALESCE(c.title, ''), COALESCE(c.text_content, ''),
+			COALESCE(c.status, 0), COALESCE(c.content_type, ''), COALESCE(c.content_length, 0),
+			c.depth, COALESCE(c.fetch_ms, 0), c.content_hash, c.crawled_at, %s, %s
+		FROM crawled_content c
+		WHERE %s
+		ORDER BY c.id`, metadata, links, strings.Join(conds, " AND "))
+}
+
+// Export передает в fn страницы по одной, читая их серверным курсором,
+// поэтому память не зависит от объема выгрузки
+func (s *PostgresStorage) Export(ctx context.Context, f ExportFilter, fn func(*ExportRow) error) error {
+	q := &statQuery{}
+	query := "DECLARE export_cursor NO SCROLL CURSOR FOR " + exportSelect(q, f)
+
+	// Курсор живет только внутри транзакции
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, query, q.args...); err != nil {
+		return fmt.Errorf("failed to open export cursor: %v", err)
+	}
+
+	fetch := fmt.Sprintf("FETCH FORWARD %d FROM export_cursor", exportFetchSize)
+	for {
+		n, err := exportChunk(ctx, tx, fetch, fn)
+		if err != nil {
+			return err
+		}
+		if n < exportFetchSize {
+			break
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "CLOSE export_cursor"); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func exportChunk(ctx context.Context, tx *sql.Tx, fetch string, fn func(*ExportRow) error) (int, error) {
+	rows, err := tx.QueryContext(ctx, fetch)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		row, err := scanExportRow(rows)
+		if err != nil {
+			return n, err
+		}
+		if err := fn(row); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, rows.Err()
+}
+
+func scanExportRow(rows *sql.Rows) (*ExportRow, error) {
+	var (
+		row      ExportRow
+		runID    sql.NullInt64
+		metadata []byte
+	)
+	err := rows.Scan(&runID, &row.Domain, &row.URL, &row.Title, &row.TextContent,
+		&row.Status, &row.ContentType, &row.ContentLength,
+		&row.Depth, &row.FetchMs, &row.ContentHash, &row.CrawledAt, &metadata, pq.Array(&row.Links))
+	if err != nil {
+		return nil, err
+	}
+	row.RunID = runID.Int64
+	if len(metadata) > 0 {
+		if err := json.Unmarshal(metadata, &row.Metadata); err != nil {
+			return nil, fmt.Errorf("invalid metadata of %s: %v", row.URL, err)
+		}
+	}
+	return &row, nil
+}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresStorage_Export(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storage := &PostgresStorage{db: db}
+	crawled := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	columns := []string{"run_id", "domain", "url", "title", "text", "status", "type", "length",
+		"depth", "fetch_ms", "hash", "crawled_at", "metadata", "links"}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DECLARE export_cursor").
+		WithArgs(int64(3), "example.com", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("FETCH FORWARD 1000 FROM export_cursor").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(3, "example.com", "https://example.com", "Example", "text", 200, "text/html", 100,
+				0, 50, "abc", crawled, []byte(`{"charset":"utf-8"}`), "{https://example.com/a}"))
+	mock.ExpectExec("CLOSE export_cursor").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	var rows []ExportRow
+	err = storage.Export(context.Background(), ExportFilter{
+		Domain:       "example.com",
+		Statuses:     []int{200},
+		RunID:        3,
+		WithLinks:    true,
+		WithMetadata: true,
+	}, func(row *ExportRow) error {
+		rows = append(rows, *row)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	require.Len(t, rows, 1)
+	assert.Equal(t, int64(3), rows[0].RunID)
+	assert.Equal(t, map[string]string{"charset": "utf-8"}, rows[0].Metadata)
+	assert.Equal(t, []string{"https://example.com/a"}, rows[0].Links)
+	assert.Equal(t, int64(50), rows[0].FetchMs)
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"web_crawler/internal/metrics"
+)
+
+// ListPages возвращает страницу выборки из crawled_content по тем же условиям, что и Export
+func (s *PostgresStorage) ListPages(ctx context.Context, f ExportFilter, limit, offset int) ([]ExportRow, error) {
+	defer metrics.ObserveDB("list_pages")()
+
+	if limit <= 0 {
+		limit = 50
+	}
+
+	q := &statQuery{}
+	query := exportSelect(q, f)
+	query += fmt.Sprintf(" LIMIT %s OFFSET %s", q.arg(limit), q.arg(offset))
+
+	rows, err := s.db.QueryContext(ctx, query, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	pages := []ExportRow{}
+	for rows.Next() {
+		row, err := scanExportRow(rows)
+		if err != nil {
+			return nil, err
+		}
+		pages = append(pages, *row)
+	}
+	return pages, rows.Err()
+}
+
+// LinkFilter условия выборки ссылок
+type LinkFilter struct {
+	RunID  int64
+	Source string
+	// Host хост цели ссылки: сам домен и его поддомены
+	Host string
+	// Origin происхождение ссылки: anchor, xhr, fetch, history, window_open или onclick
+	Origin string
+}
+
+// LinkOriginAnchor происхождение обычной ссылки <a href>
+const LinkOriginAnchor = "anchor"
+
+// Link ссылка со страницы source на target
+type Link struct {
+	RunID      int64     `json:"run_id,omitempty"`
+	SourceURL  string    `json:"source_url"`
+	TargetURL  string    `json:"target_url"`
+	TargetHost string    `json:"target_host"`
+	Origin     string    `json:"origin"`
+	CrawledAt  time.Time `json:"crawled_at"`
+}
+
+// ListLinks возвращает ссылки из таблицы links в порядке добавления
+func (s *PostgresStorage) ListLinks(ctx context.Context, f LinkFilter, limit, offset int) ([]Link, error) {
+	if limit <= 0 {
+		limit = 50
+	}
+
+	q := &statQuery{}
+	conds := []string{"TRUE"}
+	if f.RunID != 0 {
+		conds = append(conds, "run_id = "+q.arg(f.RunID))
+	}
+	if f.Source != "" {
+		conds = append(conds, "source_url = "+q.arg(f.Source))
+	}
+	if f.Host != "" {
+		conds = append(conds, q.internal("target_host", f.Host))
+	}
+	if f.Origin != "" {
+		conds = append(conds, "origin = "+q.arg(f.Origin))
+	}
+
+	query := fmt.Sprintf(`SELECT run_id, source_url, target_url, COALESCE(target_host, ''), origin, crawled_at
+		FROM links WHERE %s ORDER BY id LIMIT %s OFFSET %s`,
+		strings.Join(conds, " AND "), q.arg(limit), q.arg(offset))
+
+	rows, err := s.db.QueryContext(ctx, query, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []Link{}
+	for rows.Next() {
+		var (
+			l     Link
+			runID sql.NullInt64
+		)
+		if err := rows.Scan(&runID, &l.SourceURL, &l.TargetURL, &l.TargetHost, &l.Origin, &l.CrawledAt); err != nil {
+			return nil, err
+		}
+		l.RunID = runID.Int64
+		links = append(links, l)
+	}
+	return links, rows.Err()
+}
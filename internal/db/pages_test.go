@@ -0,0 +1,86 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostgresStorage_ListPages(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storage := &PostgresStorage{db: db}
+	crawled := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	columns := []string{"run_id", "domain", "url", "title", "text", "status", "type", "length",
+		"depth", "fetch_ms", "hash", "crawled_at", "metadata", "links"}
+
+	t.Run("filtered page", func(t *testing.T) {
+		mock.ExpectQuery(`FROM crawled_content c\s+WHERE .+ LIMIT \$4 OFFSET \$5`).
+			WithArgs(int64(2), "example.com", sqlmock.AnyArg(), 20, 40).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(2, "example.com", "https://example.com", "Example", "text", 200, "text/html", 100,
+					1, 30, "abc", crawled, nil, nil))
+
+		pages, err := storage.ListPages(context.Background(), ExportFilter{
+			RunID:    2,
+			Domain:   "example.com",
+			Statuses: []int{200},
+		}, 20, 40)
+		require.NoError(t, err)
+		require.Len(t, pages, 1)
+		assert.Equal(t, "https://example.com", pages[0].URL)
+		assert.Equal(t, 1, pages[0].Depth)
+		assert.Nil(t, pages[0].Metadata)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("default limit", func(t *testing.T) {
+		mock.ExpectQuery(`LIMIT \$1 OFFSET \$2`).
+			WithArgs(50, 0).
+			WillReturnRows(sqlmock.NewRows(columns))
+
+		pages, err := storage.ListPages(context.Background(), ExportFilter{}, 0, 0)
+		require.NoError(t, err)
+		assert.Empty(t, pages)
+		assert.NotNil(t, pages)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresStorage_ListLinks(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storage := &PostgresStorage{db: db}
+	crawled := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`FROM links WHERE TRUE AND run_id = \$1 AND source_url = \$2 AND \(target_host = \$3.* AND origin = \$4`).
+		WithArgs(int64(2), "https://example.com", "other.com", "xhr", 10, 0).
+		WillReturnRows(sqlmock.NewRows([]string{"run_id", "source", "target", "host", "origin", "crawled_at"}).
+			AddRow(2, "https://example.com", "https://other.com/a", "other.com", "xhr", crawled))
+
+	links, err := storage.ListLinks(context.Background(), LinkFilter{
+		RunID:  2,
+		Source: "https://example.com",
+		Host:   "other.com",
+		Origin: "xhr",
+	}, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, links, 1)
+	assert.Equal(t, Link{
+		RunID:      2,
+		SourceURL:  "https://example.com",
+		TargetURL:  "https://other.com/a",
+		TargetHost: "other.com",
+		Origin:     "xhr",
+		CrawledAt:  crawled,
+	}, links[0])
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,390 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"web_crawler/internal/phash"
+
+	"github.com/lib/pq"
+)
+
+var ErrRunNotFound = errors.New("crawl run not found")
+
+// ErrRunActive запуск еще не завершен
+var ErrRunActive = errors.New("crawl run is still in progress")
+
+// Статусы запуска
+const (
+	RunRunning  = "running"
+	RunFinished = "finished"
+	RunStopped  = "stopped"
+	RunFailed   = "failed"
+)
+
+// RunCounters счетчики запуска. Поля меняются через sync/atomic во время обхода
+type RunCounters struct {
+	Fetched     int64 `json:"pages_fetched"`
+	Saved       int64 `json:"pages_saved"`
+	Duplicates  int64 `json:"pages_duplicate"`
+	FetchErrors int64 `json:"fetch_errors"`
+	SaveErrors  int64 `json:"save_errors"`
+}
+
+// CrawlRun один запуск краулера
+type CrawlRun struct {
+	ID         int64           `json:"id"`
+	Seeds      []string        `json:"seeds"`
+	Settings   json.RawMessage `json:"settings,omitempty"`
+	Status     string          `json:"status"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt *time.Time      `json:"finished_at,omitempty"`
+	Counters   RunCounters     `json:"counters"`
+}
+
+const runColumns = `id, seeds, settings, status, started_at, finished_at,
+	pages_fetched, pages_saved, pages_duplicate, fetch_errors, save_errors`
+
+// StartRun создает запись о новом запуске со снимком настроек
+func (s *PostgresStorage) StartRun(ctx context.Context, seeds []string, settings interface{}) (*CrawlRun, error) {
+	snapshot, err := json.Marshal(settings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal settings: %v", err)
+	}
+
+	query := `INSERT INTO crawl_runs (seeds, settings, status) VALUES ($1, $2, $3) RETURNING ` + runColumns
+	return scanRun(s.db.QueryRowContext(ctx, query, pq.Array(seeds), snapshot, RunRunning))
+}
+
+// FinishRun сохраняет итоговый статус и счетчики запуска
+func (s *PostgresStorage) FinishRun(ctx context.Context, id int64, status string, c RunCounters) error {
+	query := `UPDATE crawl_runs SET status = $2, finished_at = NOW(),
+		pages_fetched = $3, pages_saved = $4, pages_duplicate = $5, fetch_errors = $6, save_errors = $7
+		WHERE id = $1`
+	res, err := s.db.ExecContext(ctx, query, id, status, c.Fetched, c.Saved, c.Duplicates, c.FetchErrors, c.SaveErrors)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// DNSUpstream статистика DNS-сервера за запуск
+type DNSUpstream struct {
+	Upstream  string  `json:"upstream"`
+	Queries   int64   `json:"queries"`
+	Failures  int64   `json:"failures"`
+	LatencyMs float64 `json:"latency_ms"`
+	State     string  `json:"state"`
+}
+
+// SetRunDNSStats сохраняет статистику DNS-серверов запуска
+func (s *PostgresStorage) SetRunDNSStats(ctx context.Context, id int64, stats []DNSUpstream) error {
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dns stats: %v", err)
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE crawl_runs SET dns_stats = $2 WHERE id = $1`, id, data)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// runDNSStats читает статистику DNS запуска; у старых запусков ее нет
+func (s *PostgresStorage) runDNSStats(ctx context.Context, id int64) ([]DNSUpstream, error) {
+	var data []byte
+	err := s.db.QueryRowContext(ctx, `SELECT dns_stats FROM crawl_runs WHERE id = $1`, id).Scan(&data)
+	if err == sql.ErrNoRows || (err == nil && data == nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []DNSUpstream
+	if err := json.Unmarshal(data, &stats); err != nil {
+		return nil, fmt.Errorf("failed to decode dns stats: %v", err)
+	}
+	return stats, nil
+}
+
+// SetRunLimitHits сохраняет, сколько загрузок запуска прервано каждым ограничением
+func (s *PostgresStorage) SetRunLimitHits(ctx context.Context, id int64, hits map[string]int64) error {
+	data, err := json.Marshal(hits)
+	if err != nil {
+		return fmt.Errorf("failed to marshal limit hits: %v", err)
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE crawl_runs SET limit_hits = $2 WHERE id = $1`, id, data)
+	if err != nil {
+		return err
+	}
+	return expectRow(res)
+}
+
+// runLimitHits читает срабатывания ограничений запуска по убыванию
+func (s *PostgresStorage) runLimitHits(ctx context.Context, id int64) ([]CountRow, error) {
+	return s.countRows(ctx, `SELECT key, value::int FROM crawl_runs, jsonb_each_text(limit_hits)
+		WHERE id = $1 ORDER BY 2 DESC, 1`, []interface{}{id})
+}
+
+func (s *PostgresStorage) GetRun(ctx context.Context, id int64) (*CrawlRun, error) {
+	run, err := scanRun(s.db.QueryRowContext(ctx, `SELECT `+runColumns+` FROM crawl_runs WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrRunNotFound
+	}
+	return run, err
+}
+
+// runExists возвращает ErrRunNotFound, если запуска id нет
+func (s *PostgresStorage) runExists(ctx context.Context, id int64) error {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM crawl_runs WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("run %d: %w", id, ErrRunNotFound)
+	}
+	return nil
+}
+
+// LatestRunID возвращает последний запуск или 0, если запусков еще не было
+func (s *PostgresStorage) LatestRunID(ctx context.Context) (int64, error) {
+	var id sql.NullInt64
+	err := s.db.QueryRowContext(ctx, `SELECT MAX(id) FROM crawl_runs`).Scan(&id)
+	return id.Int64, err
+}
+
+// ListRuns возвращает запуски от новых к старым
+func (s *PostgresStorage) ListRuns(ctx context.Context) ([]CrawlRun, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+runColumns+` FROM crawl_runs ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var runs []CrawlRun
+	for rows.Next() {
+		run, err := scanRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, *run)
+	}
+	return runs, rows.Err()
+}
+
+// DeleteRun удаляет запуск вместе со страницами и ссылками и возвращает ссылки на снимки и PDF
+// его страниц, чтобы вызывающий удалил их из хранилища файлов. Незавершенный запуск
+// удаляется только с force: его страницы, возможно, еще записываются
+func (s *PostgresStorage) DeleteRun(ctx context.Context, id int64, force bool) ([]string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var finished bool
+	err = tx.QueryRowContext(ctx, `SELECT finished_at IS NOT NULL FROM crawl_runs WHERE id = $1 FOR UPDATE`, id).Scan(&finished)
+	if err == sql.ErrNoRows {
+		return nil, ErrRunNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !finished && !force {
+		return nil, ErrRunActive
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM links WHERE run_id = $1`, id); err != nil {
+		return nil, err
+	}
+	refs, err := deleteContent(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM crawl_runs WHERE id = $1`, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := expectRow(res); err != nil {
+		return nil, err
+	}
+	return refs, tx.Commit()
+}
+
+// deleteContent удаляет страницы запуска и возвращает ссылки на их файлы
+func deleteContent(ctx context.Context, tx *sql.Tx, id int64) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `DELETE FROM crawled_content WHERE run_id = $1
+		RETURNING screenshot_ref, pdf_ref`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var refs []string
+	for rows.Next() {
+		var screenshot, pdf sql.NullString
+		if err := rows.Scan(&screenshot, &pdf); err != nil {
+			return nil, err
+		}
+		for _, ref := range []sql.NullString{screenshot, pdf} {
+			if ref.String != "" {
+				refs = append(refs, ref.String)
+			}
+		}
+	}
+	return refs, rows.Err()
+}
+
+// PageChange страница, которая есть в обоих запусках, но отличается
+type PageChange struct {
+	URL       string `json:"url"`
+	OldStatus int    `json:"old_status"`
+	NewStatus int    `json:"new_status"`
+	OldHash   string `json:"old_hash"`
+	NewHash   string `json:"new_hash"`
+}
+
+// RunDiff результат сравнения двух запусков
+type RunDiff struct {
+	Base    int64    `json:"base"`
+	Target  int64    `json:"target"`
+	New     []string `json:"new"`
+	Removed []string `json:"removed"`
+	// Changed страницы с другим содержимым
+	Changed []PageChange `json:"changed"`
+	// Regressions страницы, которые отвечали успешно, а стали отвечать ошибкой
+	Regressions []PageChange `json:"regressions"`
+	// VisualChanges страницы, снимки которых заметно отличаются
+	VisualChanges []VisualChange `json:"visual_changes"`
+}
+
+// VisualChangeThreshold с какого расстояния между перцептивными хешами снимков страница
+// считается визуально измененной; меньшие отличия дают сжатие и мелкие правки
+const VisualChangeThreshold = 10
+
+// VisualChange страница, снимок которой изменился между запусками
+type VisualChange struct {
+	URL     string `json:"url"`
+	OldHash string `json:"old_hash"`
+	NewHash string `json:"new_hash"`
+	// Distance число отличающихся бит хешей из 64
+	Distance int `json:"distance"`
+}
+
+// CompareRuns сравнивает запуск target с запуском base
+func (s *PostgresStorage) CompareRuns(ctx context.Context, base, target int64) (*RunDiff, error) {
+	// Без проверки несуществующий запуск выглядел бы как пустой
+	for _, id := range []int64{base, target} {
+		if err := s.runExists(ctx, id); err != nil {
+			return nil, err
+		}
+	}
+
+	query := `SELECT COALESCE(a.url, b.url), a.status, b.status, a.content_hash, b.content_hash,
+			a.visual_hash, b.visual_hash
+		FROM (SELECT url, status, content_hash, visual_hash FROM crawled_content WHERE run_id = $1) a
+		FULL OUTER JOIN (SELECT url, status, content_hash, visual_hash FROM crawled_content WHERE run_id = $2) b
+			ON a.url = b.url
+		WHERE a.url IS NULL OR b.url IS NULL
+			OR a.content_hash <> b.content_hash OR a.status IS DISTINCT FROM b.status
+			OR a.visual_hash <> b.visual_hash
+		ORDER BY 1`
+
+	rows, err := s.db.QueryContext(ctx, query, base, target)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	diff := &RunDiff{Base: base, Target: target}
+	for rows.Next() {
+		var (
+			url                  string
+			oldStatus, newStatus sql.NullInt64
+			oldHash, newHash     sql.NullString
+			oldVisual, newVisual sql.NullString
+		)
+		if err := rows.Scan(&url, &oldStatus, &newStatus, &oldHash, &newHash, &oldVisual, &newVisual); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case !oldHash.Valid:
+			diff.New = append(diff.New, url)
+		case !newHash.Valid:
+			diff.Removed = append(diff.Removed, url)
+		default:
+			change := PageChange{
+				URL:       url,
+				OldStatus: int(oldStatus.Int64),
+				NewStatus: int(newStatus.Int64),
+				OldHash:   oldHash.String,
+				NewHash:   newHash.String,
+			}
+			if change.OldHash != change.NewHash {
+				diff.Changed = append(diff.Changed, change)
+			}
+			if isSuccess(change.OldStatus) && !isSuccess(change.NewStatus) {
+				diff.Regressions = append(diff.Regressions, change)
+			}
+			if !oldVisual.Valid || !newVisual.Valid {
+				continue
+			}
+			// Хеш, записанный не этим краулером, просто не участвует в сравнении
+			if d, err := phash.Distance(oldVisual.String, newVisual.String); err == nil && d >= VisualChangeThreshold {
+				diff.VisualChanges = append(diff.VisualChanges, VisualChange{
+					URL:      url,
+					OldHash:  oldVisual.String,
+					NewHash:  newVisual.String,
+					Distance: d,
+				})
+			}
+		}
+	}
+	return diff, rows.Err()
+}
+
+func isSuccess(status int) bool {
+	return status >= 200 && status < 400
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanRun(row rowScanner) (*CrawlRun, error) {
+	var (
+		run      CrawlRun
+		settings []byte
+		finished sql.NullTime
+	)
+	err := row.Scan(&run.ID, pq.Array(&run.Seeds), &settings, &run.Status, &run.StartedAt, &finished,
+		&run.Counters.Fetched, &run.Counters.Saved, &run.Counters.Duplicates,
+		&run.Counters.FetchErrors, &run.Counters.SaveErrors)
+	if err != nil {
+		return nil, err
+	}
+	if len(settings) > 0 {
+		run.Settings = settings
+	}
+	if finished.Valid {
+		run.FinishedAt = &finished.Time
+	}
+	return &run, nil
+}
+
+func expectRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRunNotFound
+	}
+	return nil
+}
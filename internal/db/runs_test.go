@@ -0,0 +1,206 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var runRowColumns = []string{"id", "seeds", "settings", "status", "started_at", "finished_at",
+	"pages_fetched", "pages_saved", "pages_duplicate", "fetch_errors", "save_errors"}
+
+func TestPostgresStorage_StartRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storage := &PostgresStorage{db: db}
+	started := time.Now()
+
+	mock.ExpectQuery("INSERT INTO crawl_runs").
+		WithArgs(sqlmock.AnyArg(), []byte(`{"mode":"spider"}`), RunRunning).
+		WillReturnRows(sqlmock.NewRows(runRowColumns).
+			AddRow(5, "{https://example.com}", []byte(`{"mode":"spider"}`), RunRunning, started, nil, 0, 0, 0, 0, 0))
+
+	run, err := storage.StartRun(context.Background(), []string{"https://example.com"}, map[string]string{"mode": "spider"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), run.ID)
+	assert.Equal(t, []string{"https://example.com"}, run.Seeds)
+	assert.Equal(t, RunRunning, run.Status)
+	assert.Nil(t, run.FinishedAt)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStorage_FinishRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storage := &PostgresStorage{db: db}
+	counters := RunCounters{Fetched: 10, Saved: 8, Duplicates: 1, FetchErrors: 2, SaveErrors: 1}
+
+	t.Run("updated", func(t *testing.T) {
+		mock.ExpectExec("UPDATE crawl_runs").
+			WithArgs(int64(5), RunFinished, int64(10), int64(8), int64(1), int64(2), int64(1)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		assert.NoError(t, storage.FinishRun(context.Background(), 5, RunFinished, counters))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		mock.ExpectExec("UPDATE crawl_runs").WillReturnResult(sqlmock.NewResult(0, 0))
+
+		assert.ErrorIs(t, storage.FinishRun(context.Background(), 6, RunFinished, counters), ErrRunNotFound)
+	})
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStorage_SetRunDNSStats(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storage := &PostgresStorage{db: db}
+	stats := []DNSUpstream{{Upstream: "udp://1.1.1.1:53", Queries: 3, LatencyMs: 2.5, State: "closed"}}
+
+	mock.ExpectExec("UPDATE crawl_runs SET dns_stats").
+		WithArgs(int64(5), []byte(`[{"upstream":"udp://1.1.1.1:53","queries":3,"failures":0,"latency_ms":2.5,"state":"closed"}]`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, storage.SetRunDNSStats(context.Background(), 5, stats))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStorage_SetRunLimitHits(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storage := &PostgresStorage{db: db}
+	mock.ExpectExec("UPDATE crawl_runs SET limit_hits").
+		WithArgs(int64(5), []byte(`{"body_size":2,"redirects":1}`)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, storage.SetRunLimitHits(context.Background(), 5, map[string]int64{"redirects": 1, "body_size": 2}))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresStorage_DeleteRun(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storage := &PostgresStorage{db: db}
+	ctx := context.Background()
+	finished := func(ok bool) *sqlmock.Rows { return sqlmock.NewRows([]string{"finished"}).AddRow(ok) }
+
+	t.Run("finished run", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT finished_at IS NOT NULL FROM crawl_runs").WithArgs(int64(5)).WillReturnRows(finished(true))
+		mock.ExpectExec("DELETE FROM links").WithArgs(int64(5)).WillReturnResult(sqlmock.NewResult(0, 20))
+		mock.ExpectQuery("DELETE FROM crawled_content").WithArgs(int64(5)).
+			WillReturnRows(sqlmock.NewRows([]string{"screenshot_ref", "pdf_ref"}).
+				AddRow("s3://pages/5/a.png", "s3://pages/5/a.pdf").
+				AddRow(nil, nil).
+				AddRow("s3://pages/5/b.png", nil))
+		mock.ExpectExec("DELETE FROM crawl_runs").WithArgs(int64(5)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		refs, err := storage.DeleteRun(ctx, 5, false)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"s3://pages/5/a.png", "s3://pages/5/a.pdf", "s3://pages/5/b.png"}, refs)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("run in progress", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT finished_at IS NOT NULL FROM crawl_runs").WithArgs(int64(6)).WillReturnRows(finished(false))
+		mock.ExpectRollback()
+
+		_, err := storage.DeleteRun(ctx, 6, false)
+		assert.ErrorIs(t, err, ErrRunActive)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("forced", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT finished_at IS NOT NULL FROM crawl_runs").WithArgs(int64(6)).WillReturnRows(finished(false))
+		mock.ExpectExec("DELETE FROM links").WithArgs(int64(6)).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("DELETE FROM crawled_content").WithArgs(int64(6)).
+			WillReturnRows(sqlmock.NewRows([]string{"screenshot_ref", "pdf_ref"}))
+		mock.ExpectExec("DELETE FROM crawl_runs").WithArgs(int64(6)).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		refs, err := storage.DeleteRun(ctx, 6, true)
+		require.NoError(t, err)
+		assert.Empty(t, refs)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	t.Run("missing run", func(t *testing.T) {
+		mock.ExpectBegin()
+		mock.ExpectQuery("SELECT finished_at IS NOT NULL FROM crawl_runs").WithArgs(int64(7)).WillReturnError(sql.ErrNoRows)
+		mock.ExpectRollback()
+
+		_, err := storage.DeleteRun(ctx, 7, true)
+		assert.ErrorIs(t, err, ErrRunNotFound)
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+}
+
+func TestPostgresStorage_CompareRuns(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storage := &PostgresStorage{db: db}
+
+	t.Run("missing run", func(t *testing.T) {
+		mock.ExpectQuery("SELECT EXISTS").WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+		mock.ExpectQuery("SELECT EXISTS").WithArgs(int64(9)).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+
+		_, err := storage.CompareRuns(context.Background(), 1, 9)
+		assert.ErrorIs(t, err, ErrRunNotFound)
+		assert.ErrorContains(t, err, "run 9")
+		assert.NoError(t, mock.ExpectationsWereMet())
+	})
+
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(int64(1)).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT EXISTS").WithArgs(int64(2)).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("FULL OUTER JOIN").
+		WithArgs(int64(1), int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"url", "old_status", "new_status", "old_hash", "new_hash", "old_visual", "new_visual"}).
+			AddRow("https://example.com/broken", 200, 500, "aaa", "aaa", nil, nil).
+			AddRow("https://example.com/changed", 200, 200, "bbb", "ccc", "00000000000000ff", "00000000000000fe").
+			AddRow("https://example.com/gone", 200, nil, "ddd", nil, "0000000000000000", nil).
+			AddRow("https://example.com/new", nil, 200, nil, "eee", nil, "0000000000000000").
+			AddRow("https://example.com/redesign", 200, 200, "fff", "fff", "0000000000000000", "0000ffff00000000"))
+
+	diff, err := storage.CompareRuns(context.Background(), 1, 2)
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, []string{"https://example.com/new"}, diff.New)
+	assert.Equal(t, []string{"https://example.com/gone"}, diff.Removed)
+	require.Len(t, diff.Changed, 1)
+	assert.Equal(t, "https://example.com/changed", diff.Changed[0].URL)
+	require.Len(t, diff.Regressions, 1)
+	assert.Equal(t, PageChange{
+		URL:       "https://example.com/broken",
+		OldStatus: 200,
+		NewStatus: 500,
+		OldHash:   "aaa",
+		NewHash:   "aaa",
+	}, diff.Regressions[0])
+	assert.Equal(t, []VisualChange{{
+		URL:      "https://example.com/redesign",
+		OldHash:  "0000000000000000",
+		NewHash:  "0000ffff00000000",
+		Distance: 16,
+	}}, diff.VisualChanges)
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"web_crawler/internal/metrics"
+)
+
+// SearchConfigs языки полнотекстового поиска: код языка -> конфигурация
+// PostgreSQL и tsvector-колонка crawled_content
+var SearchConfigs = map[string]struct {
+	Config string
+	Column string
+}{
+	"ru": {"russian", "search_ru"},
+	"en": {"english", "search_en"},
+}
+
+// searchSchema добавляет tsvector-колонки и GIN-индексы; заголовок весит больше текста
+func searchSchema() string {
+	var sb strings.Builder
+	for _, lang := range searchLangs("") {
+		cfg := SearchConfigs[lang]
+		fmt.Fprintf(&sb, `
+	ALTER TABLE crawled_content ADD COLUMN IF NOT EXISTS %[1]s tsvector GENERATED ALWAYS AS (
+		setweight(to_tsvector('%[2]s', COALESCE(title, '')), 'A') ||
+		setweight(to_tsvector('%[2]s', COALESCE(text_content, '')), 'B')
+	) STORED;
+	CREATE INDEX IF NOT EXISTS idx_%[1]s ON crawled_content USING GIN (%[1]s);`, cfg.Column, cfg.Config)
+	}
+	return sb.String()
+}
+
+// SearchQuery параметры поиска
+type SearchQuery struct {
+	// Text запрос в синтаксисе websearch_to_tsquery: слова, "фразы", -исключения, or
+	Text string
+	// Lang код языка из SearchConfigs; пустой - искать во всех
+	Lang   string
+	RunID  int64
+	Domain string
+
+	Limit  int
+	Offset int
+	// StartSel и StopSel обрамляют найденные слова в сниппете
+	StartSel string
+	StopSel  string
+}
+
+// SearchHit найденная страница
+type SearchHit struct {
+	URL     string  `json:"url"`
+	Title   string  `json:"title"`
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// SearchResult страница результатов; Total - общее число совпадений
+type SearchResult struct {
+	Total int         `json:"total"`
+	Hits  []SearchHit `json:"hits"`
+}
+
+func searchLangs(lang string) []string {
+	if lang != "" {
+		return []string{lang}
+	}
+	langs := make([]string, 0, len(SearchConfigs))
+	for l := range SearchConfigs {
+		langs = append(langs, l)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Validate проверяет параметры запроса, которые задает пользователь
+func (sq SearchQuery) Validate() error {
+	if strings.TrimSpace(sq.Text) == "" {
+		return fmt.Errorf("empty search query")
+	}
+	if _, ok := SearchConfigs[sq.Lang]; sq.Lang != "" && !ok {
+		return fmt.Errorf("unsupported search language %q", sq.Lang)
+	}
+	if sq.Limit < 0 || sq.Offset < 0 {
+		return fmt.Errorf("search limit and offset must not be negative")
+	}
+	return nil
+}
+
+// Search ищет страницы по заголовку и тексту и возвращает их по убыванию релевантности
+func (s *PostgresStorage) Search(ctx context.Context, sq SearchQuery) (*SearchResult, error) {
+	defer metrics.ObserveDB("search")()
+
+	if err := sq.Validate(); err != nil {
+		return nil, err
+	}
+	if sq.Limit <= 0 {
+		sq.Limit = 10
+	}
+	if sq.StartSel == "" {
+		sq.StartSel, sq.StopSel = "<b>", "</b>"
+	}
+
+	q := &statQuery{}
+	text := q.arg(sq.Text)
+	options := q.arg(fmt.Sprintf("StartSel=%q, StopSel=%q, MaxWords=30, MinWords=10, MaxFragments=2",
+		sq.StartSel, sq.StopSel))
+
+	var queries, matches, ranks, headlines, columns []string
+	for i, lang := range searchLangs(sq.Lang) {
+		cfg := SearchConfigs[lang]
+		tsq := fmt.Sprintf("q%d", i)
+		queries = append(queries, fmt.Sprintf("websearch_to_tsquery('%s', %s) AS %s", cfg.Config, text, tsq))
+		matches = append(matches, fmt.Sprintf("c.%s @@ q.%s", cfg.Column, tsq))
+		ranks = append(ranks, fmt.Sprintf("ts_rank_cd(c.%s, q.%s)", cfg.Column, tsq))
+		headlines = append(headlines, fmt.Sprintf("WHEN r.%s @@ q.%s THEN ts_headline('%s', COALESCE(r.text_content, ''), q.%s, %s)",
+			cfg.Column, tsq, cfg.Config, tsq, options))
+		columns = append(columns, "c."+cfg.Column)
+	}
+
+	conds := []string{"(" + strings.Join(matches, " OR ") + ")", q.where(StatFilter{RunID: sq.RunID})}
+	if sq.Domain != "" {
+		conds = append(conds, q.internal("c.domain", sq.Domain))
+	}
+
+	// Сниппеты считаются только для страницы результатов, а не для всех совпадений
+	query := fmt.Sprintf(`WITH q AS (SELECT %s)
+		SELECT r.url, COALESCE(r.title, ''), r.rank, CASE %s END, r.total
+		FROM (
+			SELECT c.id, c.url, c.title, c.text_content, %s,
+				GREATEST(%s) AS rank, COUNT(*) OVER () AS total
+			FROM crawled_content c, q
+			WHERE %s
+			ORDER BY rank DESC, c.id
+			LIMIT %s OFFSET %s
+		) r, q
+		ORDER BY r.rank DESC, r.id`,
+		strings.Join(queries, ", "),
+		strings.Join(headlines, " "),
+		strings.Join(columns, ", "),
+		strings.Join(ranks, ", "),
+		strings.Join(conds, " AND "),
+		q.arg(sq.Limit), q.arg(sq.Offset))
+
+	rows, err := s.db.QueryContext(ctx, query, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	res := &SearchResult{Hits: []SearchHit{}}
+	for rows.Next() {
+		var hit SearchHit
+		if err := rows.Scan(&hit.URL, &hit.Title, &hit.Rank, &hit.Snippet, &res.Total); err != nil {
+			return nil, err
+		}
+		res.Hits = append(res.Hits, hit)
+	}
+	return res, rows.Err()
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchSchema(t *testing.T) {
+	schema := searchSchema()
+	assert.Contains(t, schema, "ADD COLUMN IF NOT EXISTS search_ru tsvector")
+	assert.Contains(t, schema, "to_tsvector('russian', COALESCE(title, ''))")
+	assert.Contains(t, schema, "CREATE INDEX IF NOT EXISTS idx_search_en ON crawled_content USING GIN (search_en)")
+}
+
+func TestPostgresStorage_Search(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storage := &PostgresStorage{db: db}
+	ctx := context.Background()
+
+	t.Run("all languages", func(t *testing.T) {
+		mock.ExpectQuery(`websearch_to_tsquery\('english', \$1\) AS q0, websearch_to_tsquery\('russian', \$1\) AS q1`).
+			WithArgs("книги", `StartSel="<b>", StopSel="</b>", MaxWords=30, MinWords=10, MaxFragments=2`, int64(4), 10, 20).
+			WillReturnRows(sqlmock.NewRows([]string{"url", "title", "rank", "snippet", "total"}).
+				AddRow("https://example.com/books", "Книги", 0.5, "<b>Книги</b> и журналы", 21))
+
+		res, err := storage.Search(ctx, SearchQuery{Text: "книги", RunID: 4, Offset: 20})
+		require.NoError(t, err)
+		assert.Equal(t, 21, res.Total)
+		assert.Equal(t, []SearchHit{{
+			URL:     "https://example.com/books",
+			Title:   "Книги",
+			Rank:    0.5,
+			Snippet: "<b>Книги</b> и журналы",
+		}}, res.Hits)
+	})
+
+	t.Run("single language", func(t *testing.T) {
+		mock.ExpectQuery(`WITH q AS \(SELECT websearch_to_tsquery\('russian', \$1\) AS q0\)`).
+			WithArgs("книги", sqlmock.AnyArg(), "example.com", 5, 0).
+			WillReturnRows(sqlmock.NewRows([]string{"url", "title", "rank", "snippet", "total"}))
+
+		res, err := storage.Search(ctx, SearchQuery{Text: "книги", Lang: "ru", Domain: "example.com", Limit: 5})
+		require.NoError(t, err)
+		assert.Equal(t, 0, res.Total)
+		assert.Empty(t, res.Hits)
+	})
+
+	t.Run("invalid query", func(t *testing.T) {
+		_, err := storage.Search(ctx, SearchQuery{Text: "  "})
+		assert.Error(t, err)
+		_, err = storage.Search(ctx, SearchQuery{Text: "books", Lang: "de"})
+		assert.Error(t, err)
+		_, err = storage.Search(ctx, SearchQuery{Text: "books", Offset: -10})
+		assert.Error(t, err)
+	})
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
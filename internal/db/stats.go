@@ -0,0 +1,336 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"web_crawler/internal/metrics"
+
+	"github.com/lib/pq"
+)
+
+// DefaultFileExtensions расширения, которые считаются ссылками на файлы
+var DefaultFileExtensions = []string{"pdf", "doc", "docx", "xls", "xlsx", "ppt", "pptx", "odt", "ods", "rtf", "csv", "zip"}
+
+// StatFilter ограничивает выборку для статистики.
+// Нулевые значения полей означают "без ограничения"
+type StatFilter struct {
+	// Domain главный домен: сам домен и его поддомены считаются внутренними
+	Domain string
+	RunID  int64
+	From   time.Time
+	To     time.Time
+
+	// Limit размер топов (хосты, внешние домены, крупные страницы)
+	Limit int
+	// RateBucket шаг графика скорости обхода для date_trunc: minute, hour, day
+	RateBucket     string
+	FileExtensions []string
+}
+
+// CountRow строка гистограммы
+type CountRow struct {
+	Key   string
+	Count int
+}
+
+// RatePoint количество страниц, скачанных за интервал
+type RatePoint struct {
+	Bucket time.Time
+	Count  int
+}
+
+// PageSize размер страницы
+type PageSize struct {
+	URL   string
+	Bytes int
+}
+
+// Stats агрегированная статистика обхода
+type Stats struct {
+	Total      int
+	Internal   int
+	Subdomains int
+	Broken     int
+	Duplicates int
+	AvgFetch   time.Duration
+
+	StatusCodes   []CountRow
+	PagesPerHost  []CountRow
+	PagesPerDepth []CountRow
+	ContentTypes  []CountRow
+	CrawlRate     []RatePoint
+	LargestPages  []PageSize
+
+	ExternalLinks   int
+	ExternalDomains []CountRow
+	UniqueExternal  int
+	FileLinks       []CountRow
+	// Languages страницы по языку текста
+	Languages []CountRow
+	// Documents скачанные файлы PDF и Office по типу, определенному по содержимому
+	Documents []CountRow
+
+	// DNS статистика DNS-серверов; есть только для одного запуска
+	DNS []DNSUpstream
+	// LimitHits загрузки, прерванные ограничениями, по имени ограничения; есть только для одного запуска
+	LimitHits []CountRow
+}
+
+// statQuery собирает текст запроса и его аргументы
+type statQuery struct {
+	args []interface{}
+}
+
+func (q *statQuery) arg(v interface{}) string {
+	q.args = append(q.args, v)
+	return fmt.Sprintf("$%d", len(q.args))
+}
+
+// where возвращает условие фильтра по run_id и crawled_at
+func (q *statQuery) where(f StatFilter) string {
+	conds := []string{"TRUE"}
+	if f.RunID != 0 {
+		conds = append(conds, "run_id = "+q.arg(f.RunID))
+	}
+	if !f.From.IsZero() {
+		conds = append(conds, "crawled_at >= "+q.arg(f.From))
+	}
+	if !f.To.IsZero() {
+		conds = append(conds, "crawled_at < "+q.arg(f.To))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// internal возвращает условие "хост совпадает с доменом или является его поддоменом"
+func (q *statQuery) internal(column, domain string) string {
+	p := q.arg(domain)
+	return fmt.Sprintf("(%s = %s OR %s LIKE '%%.' || %s)", column, p, column, p)
+}
+
+// Validate проверяет параметры фильтра, которые задает пользователь
+func (f StatFilter) Validate() error {
+	switch f.RateBucket {
+	case "", "minute", "hour", "day":
+	default:
+		return fmt.Errorf("invalid crawl rate bucket %q: want minute, hour or day", f.RateBucket)
+	}
+	if !f.From.IsZero() && !f.To.IsZero() && !f.From.Before(f.To) {
+		return fmt.Errorf("invalid time range: from %s is not before to %s",
+			f.From.Format(time.RFC3339), f.To.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (f StatFilter) withDefaults() StatFilter {
+	if f.Limit <= 0 {
+		f.Limit = 10
+	}
+	if f.RateBucket == "" {
+		f.RateBucket = "minute"
+	}
+	if len(f.FileExtensions) == 0 {
+		f.FileExtensions = DefaultFileExtensions
+	}
+	return f
+}
+
+// Stats считает статистику обхода средствами SQL
+func (s *PostgresStorage) Stats(ctx context.Context, f StatFilter) (*Stats, error) {
+	defer metrics.ObserveDB("stats")()
+
+	if err := f.Validate(); err != nil {
+		return nil, err
+	}
+	f = f.withDefaults()
+	st := &Stats{}
+
+	if err := s.summary(ctx, f, st); err != nil {
+		return nil, fmt.Errorf("summary stats: %v", err)
+	}
+
+	var err error
+	q := &statQuery{}
+	st.StatusCodes, err = s.countRows(ctx, fmt.Sprintf(`SELECT status::text, COUNT(*) FROM crawled_content
+		WHERE %s GROUP BY status ORDER BY status`, q.where(f)), q.args)
+	if err != nil {
+		return nil, fmt.Errorf("status stats: %v", err)
+	}
+
+	q = &statQuery{}
+	st.PagesPerHost, err = s.countRows(ctx, fmt.Sprintf(`SELECT domain, COUNT(*) FROM crawled_content
+		WHERE %s GROUP BY domain ORDER BY 2 DESC, 1 LIMIT %s`, q.where(f), q.arg(f.Limit)), q.args)
+	if err != nil {
+		return nil, fmt.Errorf("host stats: %v", err)
+	}
+
+	q = &statQuery{}
+	st.PagesPerDepth, err = s.countRows(ctx, fmt.Sprintf(`SELECT depth::text, COUNT(*) FROM crawled_content
+		WHERE %s GROUP BY depth ORDER BY depth`, q.where(f)), q.args)
+	if err != nil {
+		return nil, fmt.Errorf("depth stats: %v", err)
+	}
+
+	q = &statQuery{}
+	st.ContentTypes, err = s.countRows(ctx, fmt.Sprintf(`SELECT COALESCE(NULLIF(content_type, ''), 'unknown'), COUNT(*)
+		FROM crawled_content WHERE %s GROUP BY 1 ORDER BY 2 DESC, 1`, q.where(f)), q.args)
+	if err != nil {
+		return nil, fmt.Errorf("content type stats: %v", err)
+	}
+
+	q = &statQuery{}
+	st.Languages, err = s.countRows(ctx, fmt.Sprintf(`SELECT COALESCE(language, 'unknown'), COUNT(*)
+		FROM crawled_content WHERE %s GROUP BY 1 ORDER BY 2 DESC, 1`, q.where(f)), q.args)
+	if err != nil {
+		return nil, fmt.Errorf("language stats: %v", err)
+	}
+
+	if st.CrawlRate, err = s.crawlRate(ctx, f); err != nil {
+		return nil, fmt.Errorf("crawl rate stats: %v", err)
+	}
+	if st.LargestPages, err = s.largestPages(ctx, f); err != nil {
+		return nil, fmt.Errorf("page size stats: %v", err)
+	}
+	if err := s.externalLinks(ctx, f, st); err != nil {
+		return nil, fmt.Errorf("external link stats: %v", err)
+	}
+
+	q = &statQuery{}
+	where := q.where(f)
+	st.FileLinks, err = s.countRows(ctx, fmt.Sprintf(`WITH urls AS (
+			SELECT url FROM crawled_content WHERE %s
+			UNION
+			SELECT target_url FROM links WHERE %s
+		), ext AS (
+			SELECT substring(lower(url) from '^[a-z][a-z0-9+.-]*://[^/?#]+/[^?#]*\.([a-z0-9]+)(?:[?#].*)?$') AS ext FROM urls
+		)
+		SELECT ext, COUNT(*) FROM ext WHERE ext = ANY(%s) GROUP BY ext ORDER BY 2 DESC, 1`,
+		where, where, q.arg(pq.Array(f.FileExtensions))), q.args)
+	if err != nil {
+		return nil, fmt.Errorf("file link stats: %v", err)
+	}
+
+	q = &statQuery{}
+	st.Documents, err = s.countRows(ctx, fmt.Sprintf(`SELECT content_type, COUNT(*) FROM crawled_content
+		WHERE metadata ? 'document' AND %s GROUP BY content_type ORDER BY 2 DESC, 1`, q.where(f)), q.args)
+	if err != nil {
+		return nil, fmt.Errorf("document stats: %v", err)
+	}
+
+	if f.RunID != 0 {
+		if st.DNS, err = s.runDNSStats(ctx, f.RunID); err != nil {
+			return nil, fmt.Errorf("dns stats: %v", err)
+		}
+		if st.LimitHits, err = s.runLimitHits(ctx, f.RunID); err != nil {
+			return nil, fmt.Errorf("limit stats: %v", err)
+		}
+	}
+
+	return st, nil
+}
+
+func (s *PostgresStorage) summary(ctx context.Context, f StatFilter, st *Stats) error {
+	q := &statQuery{}
+	internal := q.internal("domain", f.Domain)
+	query := fmt.Sprintf(`SELECT
+			COUNT(*),
+			COUNT(*) FILTER (WHERE %s),
+			COUNT(*) FILTER (WHERE domain LIKE '%%.' || $1),
+			COUNT(*) FILTER (WHERE status <> 200),
+			COUNT(*) - COUNT(DISTINCT content_hash),
+			COALESCE(AVG(fetch_ms), 0)
+		FROM crawled_content WHERE %s`, internal, q.where(f))
+
+	var avgMs float64
+	err := s.db.QueryRowContext(ctx, query, q.args...).Scan(
+		&st.Total, &st.Internal, &st.Subdomains, &st.Broken, &st.Duplicates, &avgMs)
+	st.AvgFetch = time.Duration(avgMs * float64(time.Millisecond))
+	return err
+}
+
+func (s *PostgresStorage) crawlRate(ctx context.Context, f StatFilter) ([]RatePoint, error) {
+	q := &statQuery{}
+	bucket := q.arg(f.RateBucket)
+	query := fmt.Sprintf(`SELECT date_trunc(%s, crawled_at) AS bucket, COUNT(*) FROM crawled_content
+		WHERE %s GROUP BY bucket ORDER BY bucket`, bucket, q.where(f))
+
+	rows, err := s.db.QueryContext(ctx, query, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []RatePoint
+	for rows.Next() {
+		var p RatePoint
+		if err := rows.Scan(&p.Bucket, &p.Count); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (s *PostgresStorage) largestPages(ctx context.Context, f StatFilter) ([]PageSize, error) {
+	q := &statQuery{}
+	query := fmt.Sprintf(`SELECT url, content_length FROM crawled_content
+		WHERE content_length IS NOT NULL AND %s ORDER BY content_length DESC, url LIMIT %s`, q.where(f), q.arg(f.Limit))
+
+	rows, err := s.db.QueryContext(ctx, query, q.args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []PageSize
+	for rows.Next() {
+		var p PageSize
+		if err := rows.Scan(&p.URL, &p.Bytes); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (s *PostgresStorage) externalLinks(ctx context.Context, f StatFilter, st *Stats) error {
+	q := &statQuery{}
+	query := fmt.Sprintf(`SELECT COUNT(*), COUNT(DISTINCT target_host) FROM links
+		WHERE NOT %s AND %s`, q.internal("target_host", f.Domain), q.where(f))
+	if err := s.db.QueryRowContext(ctx, query, q.args...).Scan(&st.ExternalLinks, &st.UniqueExternal); err != nil {
+		return err
+	}
+
+	q = &statQuery{}
+	query = fmt.Sprintf(`SELECT target_host, COUNT(*) FROM links
+		WHERE NOT %s AND %s GROUP BY target_host ORDER BY 2 DESC, 1 LIMIT %s`,
+		q.internal("target_host", f.Domain), q.where(f), q.arg(f.Limit))
+
+	var err error
+	st.ExternalDomains, err = s.countRows(ctx, query, q.args)
+	return err
+}
+
+func (s *PostgresStorage) countRows(ctx context.Context, query string, args []interface{}) ([]CountRow, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []CountRow
+	for rows.Next() {
+		var (
+			key   sql.NullString
+			count int
+		)
+		if err := rows.Scan(&key, &count); err != nil {
+			return nil, err
+		}
+		out = append(out, CountRow{Key: key.String, Count: count})
+	}
+	return out, rows.Err()
+}
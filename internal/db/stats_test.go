@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatQueryWhere(t *testing.T) {
+	t.Run("no filter", func(t *testing.T) {
+		q := &statQuery{}
+		assert.Equal(t, "TRUE", q.where(StatFilter{}))
+		assert.Empty(t, q.args)
+	})
+
+	t.Run("run and time range", func(t *testing.T) {
+		from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		to := from.Add(24 * time.Hour)
+
+		q := &statQuery{}
+		q.arg("example.com")
+		where := q.where(StatFilter{RunID: 7, From: from, To: to})
+
+		assert.Equal(t, "TRUE AND run_id = $2 AND crawled_at >= $3 AND crawled_at < $4", where)
+		assert.Equal(t, []interface{}{"example.com", int64(7), from, to}, q.args)
+	})
+
+	t.Run("internal hosts", func(t *testing.T) {
+		q := &statQuery{}
+		assert.Equal(t, "(domain = $1 OR domain LIKE '%.' || $1)", q.internal("domain", "example.com"))
+	})
+}
+
+func TestStatFilterValidate(t *testing.T) {
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+
+	assert.NoError(t, StatFilter{}.Validate())
+	assert.NoError(t, StatFilter{RateBucket: "hour", From: from, To: to}.Validate())
+	assert.NoError(t, StatFilter{From: from}.Validate())
+	assert.Error(t, StatFilter{RateBucket: "week"}.Validate())
+	assert.Error(t, StatFilter{From: to, To: from}.Validate())
+	assert.Error(t, StatFilter{From: from, To: from}.Validate())
+
+	_, err := (&PostgresStorage{}).Stats(context.Background(), StatFilter{RateBucket: "century"})
+	assert.Error(t, err, "invalid filter must not reach the database")
+}
+
+func TestPostgresStorage_Stats(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storage := &PostgresStorage{db: db}
+	bucket := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	mock.ExpectQuery(`SELECT\s+COUNT\(\*\),`).
+		WithArgs("example.com", int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"total", "internal", "sub", "broken", "dup", "avg"}).
+			AddRow(10, 8, 2, 1, 3, 1500.0))
+	mock.ExpectQuery("SELECT status::text").
+		WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "count"}).AddRow("200", 9).AddRow("404", 1))
+	mock.ExpectQuery("SELECT domain, COUNT").
+		WithArgs(int64(3), 10).
+		WillReturnRows(sqlmock.NewRows([]string{"domain", "count"}).AddRow("example.com", 6))
+	mock.ExpectQuery("SELECT depth::text").
+		WillReturnRows(sqlmock.NewRows([]string{"depth", "count"}).AddRow("0", 1).AddRow("1", 9))
+	mock.ExpectQuery("SELECT COALESCE\\(NULLIF\\(content_type").
+		WillReturnRows(sqlmock.NewRows([]string{"type", "count"}).AddRow("text/html", 10))
+	mock.ExpectQuery("SELECT COALESCE\\(language, 'unknown'\\)").
+		WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"language", "count"}).AddRow("ru", 7).AddRow("en", 2).AddRow("unknown", 1))
+	mock.ExpectQuery("SELECT date_trunc").
+		WithArgs("minute", int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"bucket", "count"}).AddRow(bucket, 10))
+	mock.ExpectQuery("SELECT url, content_length").
+		WillReturnRows(sqlmock.NewRows([]string{"url", "len"}).AddRow("https://example.com/big", 4096))
+	mock.ExpectQuery("SELECT COUNT\\(\\*\\), COUNT\\(DISTINCT target_host\\)").
+		WillReturnRows(sqlmock.NewRows([]string{"links", "hosts"}).AddRow(5, 2))
+	mock.ExpectQuery("SELECT target_host, COUNT").
+		WillReturnRows(sqlmock.NewRows([]string{"host", "count"}).AddRow("other.org", 4).AddRow("cdn.net", 1))
+	mock.ExpectQuery("WITH urls AS").
+		WillReturnRows(sqlmock.NewRows([]string{"ext", "count"}).AddRow("pdf", 2).AddRow("docx", 1))
+	mock.ExpectQuery("SELECT content_type, COUNT\\(\\*\\) FROM crawled_content\\s+WHERE metadata \\? 'document'").
+		WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"type", "count"}).AddRow("application/pdf", 1))
+	mock.ExpectQuery("SELECT dns_stats FROM crawl_runs").
+		WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"dns_stats"}).
+			AddRow([]byte(`[{"upstream":"udp://1.1.1.1:53","queries":12,"failures":1,"latency_ms":4.5,"state":"closed"}]`)))
+	mock.ExpectQuery("SELECT key, value::int FROM crawl_runs, jsonb_each_text\\(limit_hits\\)").
+		WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"key", "value"}).AddRow("body_size", 2).AddRow("ttfb_timeout", 1))
+
+	st, err := storage.Stats(context.Background(), StatFilter{Domain: "example.com", RunID: 3})
+	require.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	assert.Equal(t, 10, st.Total)
+	assert.Equal(t, 8, st.Internal)
+	assert.Equal(t, 2, st.Subdomains)
+	assert.Equal(t, 1, st.Broken)
+	assert.Equal(t, 3, st.Duplicates)
+	assert.Equal(t, 1500*time.Millisecond, st.AvgFetch)
+	assert.Equal(t, []CountRow{{"200", 9}, {"404", 1}}, st.StatusCodes)
+	assert.Equal(t, []RatePoint{{bucket, 10}}, st.CrawlRate)
+	assert.Equal(t, []PageSize{{"https://example.com/big", 4096}}, st.LargestPages)
+	assert.Equal(t, 5, st.ExternalLinks)
+	assert.Equal(t, 2, st.UniqueExternal)
+	assert.Equal(t, []CountRow{{"other.org", 4}, {"cdn.net", 1}}, st.ExternalDomains)
+	assert.Equal(t, []CountRow{{"pdf", 2}, {"docx", 1}}, st.FileLinks)
+	assert.Equal(t, []CountRow{{"ru", 7}, {"en", 2}, {"unknown", 1}}, st.Languages)
+	assert.Equal(t, []CountRow{{"application/pdf", 1}}, st.Documents)
+	assert.Equal(t, []CountRow{{"body_size", 2}, {"ttfb_timeout", 1}}, st.LimitHits)
+	assert.Equal(t, []DNSUpstream{{Upstream: "udp://1.1.1.1:53", Queries: 12, Failures: 1, LatencyMs: 4.5, State: "closed"}}, st.DNS)
+}
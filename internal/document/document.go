@@ -0,0 +1,152 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// Типы документов, из которых извлекается текст
+const (
+	MIMEPDF  = "application/pdf"
+	MIMEDOCX = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+	MIMEXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	// MIMEDOC и MIMEXLS старые двоичные форматы Office: сохраняются без текста
+	MIMEDOC = "application/msword"
+	MIMEXLS = "application/vnd.ms-excel"
+	// MIMEOctetStream сервер не знает тип файла; он определяется по содержимому
+	MIMEOctetStream = "application/octet-stream"
+)
+
+// Types типы содержимого, которые загружаются как документы, а не открываются в Chrome
+var Types = []string{MIMEPDF, MIMEDOCX, MIMEXLSX, MIMEDOC, MIMEXLS}
+
+// ErrUnsupported формат документа известен, но текст из него не извлекается
+var ErrUnsupported = errors.New("text extraction is not supported for this format")
+
+// maxEntryBytes предел распакованного размера одного файла внутри DOCX и XLSX, защита от zip-бомб
+const maxEntryBytes = 64 << 20
+
+// Document текст и свойства документа
+type Document struct {
+	// MIME тип, определенный по содержимому файла
+	MIME  string
+	Title string
+	Text  string
+	// Meta свойства документа: author, created, modified, pages, sheets, producer
+	Meta map[string]string
+	// Size размер файла в байтах
+	Size int
+	// Checksum MD5 содержимого файла
+	Checksum string
+}
+
+// mediaType тип из заголовка Content-Type без параметров
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mt
+}
+
+// IsDocument нужно ли загружать ответ с таким Content-Type как документ. application/octet-stream
+// тоже загружается: так многие серверы отдают PDF и файлы Office
+func IsDocument(contentType string) bool {
+	mt := mediaType(contentType)
+	if mt == MIMEOctetStream {
+		return true
+	}
+	for _, t := range Types {
+		if mt == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Detect определяет тип файла по содержимому; declared - Content-Type из ответа сервера.
+// Заявленный тип используется, только если содержимое не дает ответа
+func Detect(declared string, data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("%PDF-")):
+		return MIMEPDF
+	case bytes.HasPrefix(data, []byte("PK\x03\x04")):
+		if mt := detectOOXML(data); mt != "" {
+			return mt
+		}
+		return "application/zip"
+	case bytes.HasPrefix(data, []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1")):
+		// Контейнер OLE2 общий у DOC, XLS и PPT, различить их можно только по заявленному типу
+		if mt := mediaType(declared); mt == MIMEDOC || mt == MIMEXLS {
+			return mt
+		}
+		return "application/x-ole-storage"
+	}
+
+	sniffed := mediaType(http.DetectContentType(data))
+	if sniffed == MIMEOctetStream && declared != "" {
+		return mediaType(declared)
+	}
+	return sniffed
+}
+
+// detectOOXML отличает DOCX и XLSX от прочих zip-архивов по их главной части
+func detectOOXML(data []byte) string {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return ""
+	}
+	for _, f := range zr.File {
+		switch f.Name {
+		case "word/document.xml":
+			return MIMEDOCX
+		case "xl/workbook.xml":
+			return MIMEXLSX
+		}
+	}
+	return ""
+}
+
+// Parse определяет тип файла и извлекает из него текст и свойства. Для форматов без
+// извлечения текста возвращает документ без текста вместе с ErrUnsupported, для поврежденных
+// файлов - документ без текста и ошибку разбора
+func Parse(declared string, data []byte) (*Document, error) {
+	sum := md5.Sum(data)
+	doc := &Document{
+		MIME:     Detect(declared, data),
+		Meta:     make(map[string]string),
+		Size:     len(data),
+		Checksum: hex.EncodeToString(sum[:]),
+	}
+
+	var err error
+	switch doc.MIME {
+	case MIMEPDF:
+		err = parsePDF(doc, data)
+	case MIMEDOCX:
+		err = parseDOCX(doc, data)
+	case MIMEXLSX:
+		err = parseXLSX(doc, data)
+	default:
+		return doc, ErrUnsupported
+	}
+	doc.Text = clean(doc.Text)
+	doc.Title = clean(doc.Title)
+	for k, v := range doc.Meta {
+		doc.Meta[k] = clean(v)
+	}
+	return doc, err
+}
+
+// clean готовит извлеченный текст к записи в базу: PostgreSQL не принимает NUL и некорректный
+// UTF-8, которые встречаются в строках PDF и Office. Пробелы схлопываются
+func clean(s string) string {
+	s = strings.ReplaceAll(strings.ToValidUTF8(s, "�"), "\x00", "")
+	return strings.Join(strings.Fields(s), " ")
+}
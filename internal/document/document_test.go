@@ -0,0 +1,172 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// zipFile собирает архив из частей name -> содержимое
+func zipFile(t *testing.T, parts map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, body := range parts {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(body))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// pdfFile минимальный PDF из одной страницы с текстом и словарем Info
+func pdfFile(text string) []byte {
+	stream := fmt.Sprintf("BT /F1 12 Tf 72 720 Td (%s) Tj ET", text)
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Contents 4 0 R /Resources << /Font << /F1 5 0 R >> >> >>",
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(stream), stream),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+		"<< /Title (Annual  report) /Author (Finance team) >>",
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, off := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R /Info 6 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+const coreXML = `<?xml version="1.0" encoding="UTF-8"?>
+<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties"
+	xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/">
+	<dc:title>Прайс-лист</dc:title><dc:creator>Иван</dc:creator>
+	<dcterms:created>2025-01-01T00:00:00Z</dcterms:created>
+</cp:coreProperties>`
+
+func TestIsDocument(t *testing.T) {
+	assert.True(t, IsDocument("application/pdf"))
+	assert.True(t, IsDocument("Application/PDF; name=report.pdf"))
+	assert.True(t, IsDocument("application/octet-stream"))
+	assert.True(t, IsDocument(MIMEXLSX))
+	assert.False(t, IsDocument("text/html; charset=utf-8"))
+	assert.False(t, IsDocument(""))
+}
+
+func TestDetect(t *testing.T) {
+	docx := zipFile(t, map[string]string{"word/document.xml": "<w:document/>"})
+	xlsx := zipFile(t, map[string]string{"xl/workbook.xml": "<workbook/>"})
+	other := zipFile(t, map[string]string{"readme.txt": "hi"})
+	ole := []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1rest of file")
+
+	assert.Equal(t, MIMEPDF, Detect("application/octet-stream", []byte("%PDF-1.7\n...")))
+	assert.Equal(t, MIMEDOCX, Detect("application/octet-stream", docx))
+	assert.Equal(t, MIMEXLSX, Detect("", xlsx))
+	assert.Equal(t, "application/zip", Detect(MIMEDOCX, other))
+	assert.Equal(t, MIMEDOC, Detect(MIMEDOC, ole))
+	assert.Equal(t, "application/x-ole-storage", Detect("application/octet-stream", ole))
+	assert.Equal(t, "text/html", Detect("application/pdf", []byte("<!DOCTYPE html><html></html>")))
+}
+
+func TestParse(t *testing.T) {
+	t.Run("pdf", func(t *testing.T) {
+		doc, err := Parse("application/pdf", pdfFile("Revenue grew"))
+		require.NoError(t, err)
+		assert.Equal(t, MIMEPDF, doc.MIME)
+		assert.Equal(t, "Annual report", doc.Title)
+		assert.Contains(t, doc.Text, "Revenue grew")
+		assert.Equal(t, "Finance team", doc.Meta["author"])
+		assert.Equal(t, "1", doc.Meta["pages"])
+		assert.Len(t, doc.Checksum, 32)
+	})
+
+	t.Run("docx", func(t *testing.T) {
+		data := zipFile(t, map[string]string{
+			"word/document.xml": `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+				<w:p><w:r><w:t>Первый</w:t></w:r><w:r><w:t xml:space="preserve"> абзац</w:t></w:r></w:p>
+				<w:p><w:r><w:t>Второй</w:t><w:tab/><w:t>абзац</w:t></w:r></w:p>
+			</w:body></w:document>`,
+			"docProps/core.xml": coreXML,
+			"docProps/app.xml":  `<Properties><Application>Microsoft Office Word</Application><Pages>2</Pages></Properties>`,
+		})
+		doc, err := Parse("application/octet-stream", data)
+		require.NoError(t, err)
+		assert.Equal(t, MIMEDOCX, doc.MIME)
+		assert.Equal(t, "Первый абзац Второй абзац", doc.Text)
+		assert.Equal(t, "Прайс-лист", doc.Title)
+		assert.Equal(t, map[string]string{
+			"author":   "Иван",
+			"created":  "2025-01-01T00:00:00Z",
+			"producer": "Microsoft Office Word",
+			"pages":    "2",
+		}, doc.Meta)
+	})
+
+	t.Run("xlsx", func(t *testing.T) {
+		data := zipFile(t, map[string]string{
+			"xl/workbook.xml":      `<workbook/>`,
+			"xl/sharedStrings.xml": `<sst><si><t>Товар</t></si><si><r><t>Це</t></r><r><t>на</t></r></si></sst>`,
+			"xl/worksheets/sheet1.xml": `<worksheet><sheetData>
+				<row><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c></row>
+				<row><c r="A2" t="inlineStr"><is><t>Чай</t></is></c><c r="B2"><v>120</v></c></row>
+			</sheetData></worksheet>`,
+			"xl/worksheets/sheet10.xml": `<worksheet><sheetData><row><c><v>last</v></c></row></sheetData></worksheet>`,
+			"xl/worksheets/sheet2.xml":  `<worksheet><sheetData><row><c><v>second</v></c></row></sheetData></worksheet>`,
+		})
+		doc, err := Parse(MIMEXLSX, data)
+		require.NoError(t, err)
+		assert.Equal(t, MIMEXLSX, doc.MIME)
+		assert.Equal(t, "Товар Цена Чай 120 second last", doc.Text)
+		assert.Equal(t, "3", doc.Meta["sheets"])
+	})
+
+	t.Run("text unfit for postgres", func(t *testing.T) {
+		doc, err := Parse("application/pdf", pdfFile("Rev\x00enue\xff grew"))
+		require.NoError(t, err)
+		assert.NotContains(t, doc.Text, "\x00")
+		assert.True(t, utf8.ValidString(doc.Text), doc.Text)
+		assert.Contains(t, doc.Text, "grew")
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		doc, err := Parse(MIMEXLS, []byte("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1rest of file"))
+		assert.ErrorIs(t, err, ErrUnsupported)
+		assert.Equal(t, MIMEXLS, doc.MIME)
+		assert.Empty(t, doc.Text)
+	})
+
+	t.Run("corrupted", func(t *testing.T) {
+		doc, err := Parse(MIMEPDF, []byte("%PDF-1.4\nthis is not a pdf"))
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrUnsupported)
+		assert.Equal(t, MIMEPDF, doc.MIME)
+
+		_, err = Parse(MIMEXLSX, zipFile(t, map[string]string{
+			"xl/workbook.xml":          `<workbook/>`,
+			"xl/worksheets/sheet1.xml": `<worksheet><sheetData><row><c t="s"><v>5</v></c></row></sheetData></worksheet>`,
+		}))
+		assert.Error(t, err)
+	})
+}
+
+func TestClean(t *testing.T) {
+	assert.Equal(t, "Revenue � grew", clean("Rev\x00enue \xff\n\tgrew "))
+	assert.Equal(t, "", clean("\x00\x00"))
+}
@@ -0,0 +1,300 @@
+package document
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// openZip открывает DOCX или XLSX и возвращает его части по именам
+func openZip(data []byte) (map[string]*zip.File, error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, fmt.Errorf("invalid office document: %v", err)
+	}
+	parts := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		parts[f.Name] = f
+	}
+	return parts, nil
+}
+
+// readPart читает часть архива не больше maxEntryBytes; отсутствующая часть - пустая
+func readPart(parts map[string]*zip.File, name string) ([]byte, error) {
+	f, ok := parts[name]
+	if !ok {
+		return nil, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rc, maxEntryBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxEntryBytes {
+		return nil, fmt.Errorf("%s is larger than %d bytes", name, maxEntryBytes)
+	}
+	return data, nil
+}
+
+// coreProps свойства из docProps/core.xml; теги сравниваются без пространств имен
+type coreProps struct {
+	Title          string `xml:"title"`
+	Creator        string `xml:"creator"`
+	LastModifiedBy string `xml:"lastModifiedBy"`
+	Created        string `xml:"created"`
+	Modified       string `xml:"modified"`
+}
+
+// appProps свойства из docProps/app.xml
+type appProps struct {
+	Application string `xml:"Application"`
+	Pages       string `xml:"Pages"`
+}
+
+// parseProps заполняет заголовок и свойства общими для DOCX и XLSX частями
+func parseProps(doc *Document, parts map[string]*zip.File) error {
+	data, err := readPart(parts, "docProps/core.xml")
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		var core coreProps
+		if err := xml.Unmarshal(data, &core); err != nil {
+			return fmt.Errorf("invalid docProps/core.xml: %v", err)
+		}
+		doc.Title = core.Title
+		setMeta(doc, "author", core.Creator)
+		setMeta(doc, "modified_by", core.LastModifiedBy)
+		setMeta(doc, "created", core.Created)
+		setMeta(doc, "modified", core.Modified)
+	}
+
+	data, err = readPart(parts, "docProps/app.xml")
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		var app appProps
+		if err := xml.Unmarshal(data, &app); err != nil {
+			return fmt.Errorf("invalid docProps/app.xml: %v", err)
+		}
+		setMeta(doc, "producer", app.Application)
+		setMeta(doc, "pages", app.Pages)
+	}
+	return nil
+}
+
+func setMeta(doc *Document, key, value string) {
+	if value = strings.TrimSpace(value); value != "" {
+		doc.Meta[key] = value
+	}
+}
+
+// parseDOCX собирает текст из word/document.xml: абзацы и переносы разделяются переводом строки
+func parseDOCX(doc *Document, data []byte) error {
+	parts, err := openZip(data)
+	if err != nil {
+		return err
+	}
+	if err := parseProps(doc, parts); err != nil {
+		return err
+	}
+	body, err := readPart(parts, "word/document.xml")
+	if err != nil {
+		return err
+	}
+
+	var sb strings.Builder
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	inText := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid word/document.xml: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				inText = true
+			case "tab":
+				sb.WriteString("\t")
+			case "br", "cr":
+				sb.WriteString("\n")
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "t":
+				inText = false
+			case "p":
+				sb.WriteString("\n")
+			}
+		case xml.CharData:
+			if inText {
+				sb.Write(t)
+			}
+		}
+	}
+	doc.Text = sb.String()
+	return nil
+}
+
+// sheetNumber номер листа в имени части xl/worksheets/sheetN.xml
+var sheetNumber = regexp.MustCompile(`^xl/worksheets/sheet(\d+)\.xml$`)
+
+// parseXLSX собирает значения ячеек всех листов: ячейки через табуляцию, строки через перевод строки
+func parseXLSX(doc *Document, data []byte) error {
+	parts, err := openZip(data)
+	if err != nil {
+		return err
+	}
+	if err := parseProps(doc, parts); err != nil {
+		return err
+	}
+
+	shared, err := sharedStrings(parts)
+	if err != nil {
+		return err
+	}
+
+	type sheet struct {
+		n    int
+		name string
+	}
+	var sheets []sheet
+	for name := range parts {
+		if m := sheetNumber.FindStringSubmatch(name); m != nil {
+			n, _ := strconv.Atoi(m[1])
+			sheets = append(sheets, sheet{n, name})
+		}
+	}
+	sort.Slice(sheets, func(i, j int) bool { return sheets[i].n < sheets[j].n })
+	doc.Meta["sheets"] = strconv.Itoa(len(sheets))
+
+	var sb strings.Builder
+	for _, s := range sheets {
+		body, err := readPart(parts, s.name)
+		if err != nil {
+			return err
+		}
+		if err := sheetText(&sb, body, shared); err != nil {
+			return fmt.Errorf("invalid %s: %v", s.name, err)
+		}
+	}
+	doc.Text = sb.String()
+	return nil
+}
+
+// sharedStrings таблица общих строк xl/sharedStrings.xml, на которую ссылаются ячейки с t="s"
+func sharedStrings(parts map[string]*zip.File) ([]string, error) {
+	data, err := readPart(parts, "xl/sharedStrings.xml")
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	var out []string
+	var sb strings.Builder
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	inText := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid xl/sharedStrings.xml: %v", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				sb.Reset()
+			case "t":
+				inText = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				out = append(out, sb.String())
+			case "t":
+				inText = false
+			}
+		case xml.CharData:
+			if inText {
+				sb.Write(t)
+			}
+		}
+	}
+}
+
+// sheetText пишет значения ячеек листа; общие строки подставляются по индексу
+func sheetText(sb *strings.Builder, data []byte, shared []string) error {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var (
+		cellType string
+		value    strings.Builder
+		inValue  bool
+	)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "c":
+				cellType = ""
+				for _, a := range t.Attr {
+					if a.Name.Local == "t" {
+						cellType = a.Value
+					}
+				}
+				value.Reset()
+			case "v", "t":
+				inValue = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "c":
+				text := value.String()
+				if cellType == "s" {
+					i, err := strconv.Atoi(strings.TrimSpace(text))
+					if err != nil || i < 0 || i >= len(shared) {
+						return fmt.Errorf("invalid shared string index %q", text)
+					}
+					text = shared[i]
+				}
+				if text != "" {
+					sb.WriteString(text)
+					sb.WriteString("\t")
+				}
+			case "row":
+				sb.WriteString("\n")
+			}
+		case xml.CharData:
+			if inValue {
+				value.Write(t)
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package document
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// parsePDF извлекает текст и словарь Info. Библиотека разбора сообщает о части
+// повреждений паникой, поэтому она перехватывается и превращается в ошибку
+func parsePDF(doc *Document, data []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("invalid pdf: %v", r)
+		}
+	}()
+
+	r, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return fmt.Errorf("invalid pdf: %v", err)
+	}
+
+	info := r.Trailer().Key("Info")
+	doc.Title = info.Key("Title").Text()
+	for key, name := range map[string]string{
+		"author":   "Author",
+		"creator":  "Creator",
+		"producer": "Producer",
+		"created":  "CreationDate",
+		"modified": "ModDate",
+	} {
+		if v := info.Key(name).Text(); v != "" {
+			doc.Meta[key] = v
+		}
+	}
+	doc.Meta["pages"] = strconv.Itoa(r.NumPage())
+
+	text, err := r.GetPlainText()
+	if err != nil {
+		return fmt.Errorf("failed to extract pdf text: %v", err)
+	}
+	b, err := io.ReadAll(text)
+	if err != nil {
+		return err
+	}
+	doc.Text = string(b)
+	return nil
+}
@@ -0,0 +1,256 @@
+package downloader
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"web_crawler/internal/logging"
+
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// Действия сценария страницы
+const (
+	// ActionScroll прокручивает страницу до конца times раз, пока она растет
+	ActionScroll = "scroll"
+	// ActionClickUntilGone нажимает selector, пока он есть на странице, но не больше times раз
+	ActionClickUntilGone = "click_until_gone"
+	// ActionDismissConsent закрывает баннер cookie кнопкой selector или одной из известных кнопок
+	ActionDismissConsent = "dismiss_consent"
+	// ActionWait ждет появления selector
+	ActionWait = "wait"
+	// ActionScript выполняет js; промис дожидается завершения
+	ActionScript = "script"
+	// ActionSleep пауза wait_ms
+	ActionSleep = "sleep"
+)
+
+// consentSelectors кнопки согласия распространенных баннеров cookie
+var consentSelectors = []string{
+	"#onetrust-accept-btn-handler",
+	"#didomi-notice-agree-button",
+	"#CybotCookiebotDialogBodyLevelButtonLevelOptinAllowAll",
+	"[data-testid='uc-accept-all-button']",
+	".fc-cta-consent",
+	".cc-allow",
+	"button[aria-label='Accept all']",
+}
+
+// collectLinksJS абсолютные адреса всех ссылок текущего состояния страницы
+const collectLinksJS = `Array.from(document.querySelectorAll('a[href]'), a => a.href)`
+
+// PageAction действие над страницей перед сохранением HTML
+type PageAction struct {
+	// Action scroll, click_until_gone, dismiss_consent, wait, script или sleep
+	Action   string `json:"action"`
+	Selector string `json:"selector"`
+	// Times число повторов для scroll (по умолчанию 1) и click_until_gone (по умолчанию 20)
+	Times int `json:"times"`
+	// WaitMs пауза после каждого повтора, для wait - время ожидания, для sleep - длительность
+	WaitMs int    `json:"wait_ms"`
+	JS     string `json:"js"`
+}
+
+func (a *PageAction) times(def int) int {
+	if a.Times <= 0 {
+		return def
+	}
+	return a.Times
+}
+
+func (a *PageAction) wait(def time.Duration) time.Duration {
+	if a.WaitMs <= 0 {
+		return def
+	}
+	return time.Duration(a.WaitMs) * time.Millisecond
+}
+
+func (a *PageAction) validate() error {
+	switch a.Action {
+	case ActionClickUntilGone, ActionWait:
+		if a.Selector == "" {
+			return fmt.Errorf("%s: selector is required", a.Action)
+		}
+	case ActionScript:
+		if a.JS == "" {
+			return fmt.Errorf("script: js is required")
+		}
+	case ActionScroll, ActionDismissConsent, ActionSleep:
+	default:
+		return fmt.Errorf("unknown action %q", a.Action)
+	}
+	return nil
+}
+
+// PageScript действия для страниц, URL которых подходит под pattern
+type PageScript struct {
+	// Pattern регулярное выражение для URL
+	Pattern string       `json:"pattern"`
+	Actions []PageAction `json:"actions"`
+	// TimeoutSec сколько времени сверх обычной загрузки дается на действия, по умолчанию 30
+	TimeoutSec int `json:"timeout_sec"`
+}
+
+type compiledScript struct {
+	re *regexp.Regexp
+	PageScript
+}
+
+// Scripts сценарии страниц; применяется первый подходящий. Методы допускают nil
+type Scripts struct {
+	scripts []compiledScript
+}
+
+// NewScripts компилирует шаблоны и проверяет действия; без сценариев возвращает nil
+func NewScripts(scripts []PageScript) (*Scripts, error) {
+	if len(scripts) == 0 {
+		return nil, nil
+	}
+	s := &Scripts{}
+	for i, script := range scripts {
+		re, err := regexp.Compile(script.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("script %d: invalid pattern: %v", i+1, err)
+		}
+		for j := range script.Actions {
+			if err := script.Actions[j].validate(); err != nil {
+				return nil, fmt.Errorf("script %d, action %d: %v", i+1, j+1, err)
+			}
+		}
+		s.scripts = append(s.scripts, compiledScript{re: re, PageScript: script})
+	}
+	return s, nil
+}
+
+// Match сценарий для URL или nil
+func (s *Scripts) Match(url string) *PageScript {
+	if s == nil {
+		return nil
+	}
+	for i := range s.scripts {
+		if s.scripts[i].re.MatchString(url) {
+			return &s.scripts[i].PageScript
+		}
+	}
+	return nil
+}
+
+func (p *PageScript) timeout() time.Duration {
+	if p.TimeoutSec <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(p.TimeoutSec) * time.Second
+}
+
+// run выполняет действия сценария; ссылки собираются до первого действия и после каждого шага
+func (p *PageScript) run(links *linkSet) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		collect := func() error {
+			return collectLinks(links, true).Do(ctx)
+		}
+
+		if err := collect(); err != nil {
+			return err
+		}
+		for i := range p.Actions {
+			if err := p.Actions[i].run(ctx, collect); err != nil {
+				return fmt.Errorf("action %d (%s): %v", i+1, p.Actions[i].Action, err)
+			}
+			if err := collect(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (a *PageAction) run(ctx context.Context, collect func() error) error {
+	switch a.Action {
+	case ActionScroll:
+		var height int64
+		for i := 0; i < a.times(1); i++ {
+			var next int64
+			err := chromedp.Evaluate(`window.scrollTo(0, document.body.scrollHeight); document.body.scrollHeight`, &next).Do(ctx)
+			if err != nil {
+				return err
+			}
+			// Страница перестала расти: дальше прокручивать нечего
+			if next == height {
+				break
+			}
+			height = next
+			if err := chromedp.Sleep(a.wait(time.Second)).Do(ctx); err != nil {
+				return err
+			}
+			if err := collect(); err != nil {
+				return err
+			}
+		}
+
+	case ActionClickUntilGone:
+		for i := 0; i < a.times(20); i++ {
+			var clicked bool
+			if err := chromedp.Evaluate(clickJS([]string{a.Selector}), &clicked).Do(ctx); err != nil {
+				return err
+			}
+			if !clicked {
+				break
+			}
+			if err := chromedp.Sleep(a.wait(time.Second)).Do(ctx); err != nil {
+				return err
+			}
+			if err := collect(); err != nil {
+				return err
+			}
+		}
+
+	case ActionDismissConsent:
+		selectors := consentSelectors
+		if a.Selector != "" {
+			selectors = []string{a.Selector}
+		}
+		var clicked bool
+		if err := chromedp.Evaluate(clickJS(selectors), &clicked).Do(ctx); err != nil {
+			return err
+		}
+		if clicked {
+			return chromedp.Sleep(a.wait(500 * time.Millisecond)).Do(ctx)
+		}
+
+	case ActionWait:
+		waitCtx, cancel := context.WithTimeout(ctx, a.wait(10*time.Second))
+		defer cancel()
+		// Элемент может так и не появиться, например у пустой выдачи: страница все равно сохраняется
+		if err := chromedp.WaitVisible(a.Selector, chromedp.ByQuery).Do(waitCtx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			logging.FromContext(ctx).Warn("Selector did not appear", "selector", a.Selector)
+		}
+
+	case ActionScript:
+		return chromedp.Evaluate(a.JS, nil, func(p *runtime.EvaluateParams) *runtime.EvaluateParams {
+			return p.WithAwaitPromise(true)
+		}).Do(ctx)
+
+	case ActionSleep:
+		return chromedp.Sleep(a.wait(time.Second)).Do(ctx)
+	}
+	return nil
+}
+
+// clickJS выражение JS, которое нажимает первый найденный элемент из selectors и возвращает, был ли он
+func clickJS(selectors []string) string {
+	quoted, _ := json.Marshal(selectors)
+	return fmt.Sprintf(`(() => {
+		for (const s of %s) {
+			const el = document.querySelector(s);
+			if (el) { el.click(); return true; }
+		}
+		return false;
+	})()`, quoted)
+}
@@ -0,0 +1,64 @@
+package downloader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScripts(t *testing.T) {
+	scripts, err := NewScripts([]PageScript{
+		{Pattern: `^https://shop\.test/catalog`, Actions: []PageAction{
+			{Action: ActionDismissConsent},
+			{Action: ActionClickUntilGone, Selector: "button.load-more"},
+		}},
+		{Pattern: `^https://shop\.test/`, Actions: []PageAction{
+			{Action: ActionScroll, Times: 5, WaitMs: 500},
+			{Action: ActionWait, Selector: ".item"},
+			{Action: ActionScript, JS: "document.querySelectorAll('.tab').forEach(t => t.click())"},
+			{Action: ActionSleep, WaitMs: 200},
+		}},
+	})
+	require.NoError(t, err)
+
+	t.Run("first matching script wins", func(t *testing.T) {
+		script := scripts.Match("https://shop.test/catalog?page=2")
+		require.NotNil(t, script)
+		assert.Equal(t, ActionDismissConsent, script.Actions[0].Action)
+
+		script = scripts.Match("https://shop.test/about")
+		require.NotNil(t, script)
+		assert.Equal(t, ActionScroll, script.Actions[0].Action)
+
+		assert.Nil(t, scripts.Match("https://blog.test/"))
+	})
+
+	t.Run("nil scripts", func(t *testing.T) {
+		var none *Scripts
+		assert.Nil(t, none.Match("https://shop.test/"))
+	})
+
+	t.Run("invalid scripts", func(t *testing.T) {
+		for name, script := range map[string]PageScript{
+			"bad pattern":      {Pattern: "(", Actions: []PageAction{{Action: ActionScroll}}},
+			"unknown action":   {Pattern: ".", Actions: []PageAction{{Action: "hover"}}},
+			"missing selector": {Pattern: ".", Actions: []PageAction{{Action: ActionClickUntilGone}}},
+			"missing js":       {Pattern: ".", Actions: []PageAction{{Action: ActionScript}}},
+		} {
+			_, err := NewScripts([]PageScript{script})
+			assert.Error(t, err, name)
+		}
+	})
+}
+
+func TestMergeLinks(t *testing.T) {
+	assert.Equal(t, []string{"a", "b", "c"}, MergeLinks([]string{"a", "b", "a"}, []string{"c", "b"}))
+	assert.Nil(t, MergeLinks())
+}
+
+func TestClickJS(t *testing.T) {
+	js := clickJS([]string{`button[aria-label="Accept"]`})
+	assert.Contains(t, js, `["button[aria-label=\"Accept\"]"]`)
+	assert.Equal(t, `document.querySelector("form#login") !== null`, selectorExists("form#login"))
+}
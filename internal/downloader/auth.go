@@ -0,0 +1,274 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"web_crawler/internal/logging"
+	"web_crawler/internal/metrics"
+
+	"github.com/chromedp/chromedp"
+)
+
+// Действия шагов сценария входа
+const (
+	StepNavigate = "navigate"
+	StepFill     = "fill"
+	StepClick    = "click"
+	StepWait     = "wait"
+	StepSleep    = "sleep"
+)
+
+// redacted заменяет секреты в снимке настроек
+const redacted = "xxxxx"
+
+// LoginStep шаг сценария входа
+type LoginStep struct {
+	// Action navigate, fill, click, wait или sleep
+	Action string `json:"action"`
+	// URL адрес для navigate
+	URL string `json:"url"`
+	// Selector CSS-селектор для fill, click и wait
+	Selector string `json:"selector"`
+	// Value текст для fill
+	Value string `json:"value"`
+	// Env переменная окружения с текстом для fill, чтобы не хранить пароль в settings.json
+	Env string `json:"env"`
+	// Ms пауза для sleep
+	Ms int `json:"ms"`
+}
+
+// LoginRecipe сценарий входа на сайт через Chrome
+type LoginRecipe struct {
+	Steps []LoginStep `json:"steps"`
+	// LoggedInSelector элемент, который есть на странице только после входа
+	LoggedInSelector string `json:"logged_in_selector"`
+	// LoggedOutSelector элемент, по которому видно, что сессия закончилась, например форма входа
+	LoggedOutSelector string `json:"logged_out_selector"`
+	// TimeoutSec время на сценарий, по умолчанию 30
+	TimeoutSec int `json:"timeout_sec"`
+}
+
+func (r *LoginRecipe) timeout() time.Duration {
+	if r.TimeoutSec <= 0 {
+		return 30 * time.Second
+	}
+	return time.Duration(r.TimeoutSec) * time.Second
+}
+
+// BasicAuth логин и пароль HTTP Basic
+type BasicAuth struct {
+	User     string `json:"user"`
+	Password string `json:"password"`
+}
+
+// SiteAuth авторизация на домене и его поддоменах
+type SiteAuth struct {
+	// Login сценарий входа для загрузки через Chrome
+	Login *LoginRecipe `json:"login"`
+	// Basic и Bearer добавляются к запросам net/http
+	Basic  *BasicAuth `json:"basic"`
+	Bearer string     `json:"bearer"`
+}
+
+// AuthConfig авторизация по доменам
+type AuthConfig map[string]SiteAuth
+
+// Redacted копия настроек без паролей и токенов, для сохранения вместе с запуском
+func (c AuthConfig) Redacted() AuthConfig {
+	if c == nil {
+		return nil
+	}
+	out := make(AuthConfig, len(c))
+	for domain, auth := range c {
+		if auth.Login != nil {
+			login := *auth.Login
+			login.Steps = make([]LoginStep, len(auth.Login.Steps))
+			for i, step := range auth.Login.Steps {
+				if step.Value != "" {
+					step.Value = redacted
+				}
+				login.Steps[i] = step
+			}
+			auth.Login = &login
+		}
+		if auth.Basic != nil {
+			auth.Basic = &BasicAuth{User: auth.Basic.User, Password: redacted}
+		}
+		if auth.Bearer != "" {
+			auth.Bearer = redacted
+		}
+		out[domain] = auth
+	}
+	return out
+}
+
+// actions переводит шаги сценария в действия chromedp
+func (r *LoginRecipe) actions() ([]chromedp.Action, error) {
+	var actions []chromedp.Action
+	for i, step := range r.Steps {
+		needSelector := step.Action == StepFill || step.Action == StepClick || step.Action == StepWait
+		if needSelector && step.Selector == "" {
+			return nil, fmt.Errorf("step %d (%s): selector is required", i+1, step.Action)
+		}
+
+		switch step.Action {
+		case StepNavigate:
+			if step.URL == "" {
+				return nil, fmt.Errorf("step %d (navigate): url is required", i+1)
+			}
+			actions = append(actions, chromedp.Navigate(step.URL))
+		case StepFill:
+			value := step.Value
+			if step.Env != "" {
+				value = os.Getenv(step.Env)
+			}
+			actions = append(actions, chromedp.SendKeys(step.Selector, value, chromedp.ByQuery))
+		case StepClick:
+			actions = append(actions, chromedp.Click(step.Selector, chromedp.ByQuery))
+		case StepWait:
+			actions = append(actions, chromedp.WaitVisible(step.Selector, chromedp.ByQuery))
+		case StepSleep:
+			actions = append(actions, chromedp.Sleep(time.Duration(step.Ms)*time.Millisecond))
+		default:
+			return nil, fmt.Errorf("step %d: unknown action %q", i+1, step.Action)
+		}
+	}
+	if r.LoggedInSelector == "" {
+		return nil, fmt.Errorf("logged_in_selector is required")
+	}
+	actions = append(actions, chromedp.WaitVisible(r.LoggedInSelector, chromedp.ByQuery))
+	return actions, nil
+}
+
+// session состояние входа на один домен
+type session struct {
+	mu sync.Mutex
+	// gen номер успешного входа; по нему повторный вход выполняется один раз на всех воркеров
+	gen   int
+	valid bool
+}
+
+// Sessions входы на сайты с авторизацией, общие для всех воркеров: сценарий выполняется
+// один раз, а cookie сессии попадают в общий jar. Методы допускают nil: авторизации нет
+type Sessions struct {
+	sites AuthConfig
+
+	mu       sync.Mutex
+	sessions map[string]*session
+}
+
+// NewSessions проверяет сценарии входа; без настроек возвращает nil
+func NewSessions(cfg AuthConfig) (*Sessions, error) {
+	if len(cfg) == 0 {
+		return nil, nil
+	}
+	sites := make(AuthConfig, len(cfg))
+	for domain, auth := range cfg {
+		if auth.Login != nil {
+			if _, err := auth.Login.actions(); err != nil {
+				return nil, fmt.Errorf("invalid login recipe for %s: %v", domain, err)
+			}
+		}
+		sites[strings.ToLower(strings.TrimSuffix(domain, "."))] = auth
+	}
+	return &Sessions{sites: sites, sessions: make(map[string]*session)}, nil
+}
+
+// lookup находит авторизацию для хоста или одного из его родительских доменов
+func (s *Sessions) lookup(host string) (string, SiteAuth, bool) {
+	if s == nil {
+		return "", SiteAuth{}, false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for domain := host; domain != ""; {
+		if auth, ok := s.sites[domain]; ok {
+			return domain, auth, true
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			break
+		}
+		domain = domain[i+1:]
+	}
+	return "", SiteAuth{}, false
+}
+
+// Recipe сценарий входа для хоста, если он задан
+func (s *Sessions) Recipe(host string) *LoginRecipe {
+	_, auth, _ := s.lookup(host)
+	return auth.Login
+}
+
+func (s *Sessions) session(domain string) *session {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st, ok := s.sessions[domain]
+	if !ok {
+		st = &session{}
+		s.sessions[domain] = st
+	}
+	return st
+}
+
+// Ensure выполняет вход на сайт хоста, если сессии еще нет. Остальные воркеры этого сайта
+// ждут завершения входа. Возвращает номер сессии для Invalidate
+func (s *Sessions) Ensure(ctx context.Context, host string, login func(*LoginRecipe) error) (int, error) {
+	domain, auth, ok := s.lookup(host)
+	if !ok || auth.Login == nil {
+		return 0, nil
+	}
+
+	st := s.session(domain)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.valid {
+		return st.gen, nil
+	}
+
+	logger := logging.FromContext(ctx).With("domain", domain)
+	logger.Info("Logging in")
+	if err := login(auth.Login); err != nil {
+		metrics.Logins.WithLabelValues(domain, "error").Inc()
+		return 0, fmt.Errorf("login to %s failed: %v", domain, err)
+	}
+	metrics.Logins.WithLabelValues(domain, "ok").Inc()
+	st.valid = true
+	st.gen++
+	logger.Info("Logged in", "session", st.gen)
+	return st.gen, nil
+}
+
+// Invalidate помечает сессию gen закончившейся, чтобы следующий Ensure вошел заново.
+// Если другой воркер уже вошел заново, новая сессия не сбрасывается
+func (s *Sessions) Invalidate(host string, gen int) {
+	domain, auth, ok := s.lookup(host)
+	if !ok || auth.Login == nil {
+		return
+	}
+	st := s.session(domain)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.gen == gen {
+		st.valid = false
+	}
+}
+
+// Authorize добавляет к запросу HTTP Basic или Bearer, заданные для его хоста
+func (s *Sessions) Authorize(req *http.Request) {
+	_, auth, ok := s.lookup(req.URL.Hostname())
+	if !ok {
+		return
+	}
+	switch {
+	case auth.Bearer != "":
+		req.Header.Set("Authorization", "Bearer "+auth.Bearer)
+	case auth.Basic != nil:
+		req.SetBasicAuth(auth.Basic.User, auth.Basic.Password)
+	}
+}
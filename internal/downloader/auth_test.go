@@ -0,0 +1,182 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testRecipe = &LoginRecipe{
+	Steps: []LoginStep{
+		{Action: StepNavigate, URL: "https://portal.test/login"},
+		{Action: StepFill, Selector: "#user", Value: "alice"},
+		{Action: StepFill, Selector: "#password", Env: "PORTAL_PASSWORD"},
+		{Action: StepClick, Selector: "button[type=submit]"},
+		{Action: StepWait, Selector: "#dashboard"},
+		{Action: StepSleep, Ms: 100},
+	},
+	LoggedInSelector:  ".user-menu",
+	LoggedOutSelector: "form#login",
+}
+
+func TestLoginRecipe(t *testing.T) {
+	t.Run("valid recipe", func(t *testing.T) {
+		actions, err := testRecipe.actions()
+		require.NoError(t, err)
+		// Шаги и проверка logged_in_selector
+		assert.Len(t, actions, len(testRecipe.Steps)+1)
+	})
+
+	t.Run("invalid recipes", func(t *testing.T) {
+		for name, recipe := range map[string]LoginRecipe{
+			"missing selector":  {Steps: []LoginStep{{Action: StepClick}}, LoggedInSelector: "#ok"},
+			"missing url":       {Steps: []LoginStep{{Action: StepNavigate}}, LoggedInSelector: "#ok"},
+			"unknown action":    {Steps: []LoginStep{{Action: "hover", Selector: "a"}}, LoggedInSelector: "#ok"},
+			"no logged-in mark": {Steps: []LoginStep{{Action: StepNavigate, URL: "https://portal.test"}}},
+		} {
+			_, err := NewSessions(AuthConfig{"portal.test": {Login: &recipe}})
+			assert.Error(t, err, name)
+		}
+	})
+
+	t.Run("redacted", func(t *testing.T) {
+		cfg := AuthConfig{
+			"portal.test": {Login: testRecipe},
+			"api.test":    {Basic: &BasicAuth{User: "bob", Password: "secret"}, Bearer: "token"},
+		}
+		r := cfg.Redacted()
+		assert.Equal(t, redacted, r["portal.test"].Login.Steps[1].Value)
+		assert.Equal(t, "PORTAL_PASSWORD", r["portal.test"].Login.Steps[2].Env)
+		assert.Equal(t, "bob", r["api.test"].Basic.User)
+		assert.Equal(t, redacted, r["api.test"].Basic.Password)
+		assert.Equal(t, redacted, r["api.test"].Bearer)
+		// Исходные настройки не меняются
+		assert.Equal(t, "alice", testRecipe.Steps[1].Value)
+		assert.Equal(t, "secret", cfg["api.test"].Basic.Password)
+	})
+}
+
+func TestSessions(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("login runs once for all workers", func(t *testing.T) {
+		sessions, err := NewSessions(AuthConfig{"portal.test": {Login: testRecipe}})
+		require.NoError(t, err)
+
+		var logins int64
+		login := func(r *LoginRecipe) error {
+			assert.Same(t, testRecipe, r)
+			atomic.AddInt64(&logins, 1)
+			return nil
+		}
+
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				gen, err := sessions.Ensure(ctx, "www.portal.test", login)
+				assert.NoError(t, err)
+				assert.Equal(t, 1, gen)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int64(1), logins)
+
+		gen, err := sessions.Ensure(ctx, "other.test", login)
+		assert.NoError(t, err)
+		assert.Zero(t, gen)
+		assert.Equal(t, int64(1), logins)
+	})
+
+	t.Run("re-login once per expired session", func(t *testing.T) {
+		sessions, err := NewSessions(AuthConfig{"portal.test": {Login: testRecipe}})
+		require.NoError(t, err)
+
+		var logins int64
+		login := func(*LoginRecipe) error {
+			atomic.AddInt64(&logins, 1)
+			return nil
+		}
+		gen, err := sessions.Ensure(ctx, "portal.test", login)
+		require.NoError(t, err)
+
+		// Два воркера увидели форму входа в одной и той же сессии
+		sessions.Invalidate("portal.test", gen)
+		newGen, err := sessions.Ensure(ctx, "portal.test", login)
+		require.NoError(t, err)
+		sessions.Invalidate("portal.test", gen)
+		again, err := sessions.Ensure(ctx, "portal.test", login)
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, newGen)
+		assert.Equal(t, 2, again)
+		assert.Equal(t, int64(2), logins)
+	})
+
+	t.Run("failed login is retried", func(t *testing.T) {
+		sessions, err := NewSessions(AuthConfig{"portal.test": {Login: testRecipe}})
+		require.NoError(t, err)
+
+		_, err = sessions.Ensure(ctx, "portal.test", func(*LoginRecipe) error { return errors.New("timeout") })
+		assert.ErrorContains(t, err, "login to portal.test failed")
+
+		gen, err := sessions.Ensure(ctx, "portal.test", func(*LoginRecipe) error { return nil })
+		assert.NoError(t, err)
+		assert.Equal(t, 1, gen)
+	})
+
+	t.Run("nil sessions", func(t *testing.T) {
+		var sessions *Sessions
+		gen, err := sessions.Ensure(ctx, "portal.test", nil)
+		assert.NoError(t, err)
+		assert.Zero(t, gen)
+		assert.Nil(t, sessions.Recipe("portal.test"))
+		sessions.Invalidate("portal.test", 0)
+	})
+}
+
+func TestFetchStaticAuth(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	server := newTestDNSServer(t)
+	server.A("basic.test", 300, "127.0.0.1")
+	server.A("api.bearer.test", 300, "127.0.0.1")
+	server.A("open.test", 300, "127.0.0.1")
+	resolver := newTestResolver(t, []string{server.addr}, DNSConfig{TimeoutMs: 500}, newRedisCache(mr))
+
+	var got string
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+	}))
+	defer site.Close()
+	_, port, _ := net.SplitHostPort(site.Listener.Addr().String())
+
+	sessions, err := NewSessions(AuthConfig{
+		"basic.test":  {Basic: &BasicAuth{User: "bob", Password: "secret"}},
+		"bearer.test": {Bearer: "token"},
+	})
+	require.NoError(t, err)
+	fetcher := &Fetcher{Resolver: resolver, Auth: sessions}
+
+	for host, want := range map[string]string{
+		"basic.test":      "Basic Ym9iOnNlY3JldA==",
+		"api.bearer.test": "Bearer token",
+		"open.test":       "",
+	} {
+		_, err := fetcher.FetchStaticHTML(context.Background(), "http://"+host+":"+port+"/")
+		require.NoError(t, err, host)
+		assert.Equal(t, want, got, host)
+	}
+}
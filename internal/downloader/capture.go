@@ -0,0 +1,229 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/png"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"web_crawler/internal/blob"
+	"web_crawler/internal/logging"
+	"web_crawler/internal/metrics"
+	"web_crawler/internal/phash"
+
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/device"
+)
+
+// Форматы снимка страницы
+const (
+	ScreenshotPNG  = "png"
+	ScreenshotWebP = "webp"
+)
+
+// hashWidth ширина уменьшенного снимка, по которому считается перцептивный хеш
+const hashWidth = 256
+
+// devices профили устройств для эмуляции
+var devices = map[string]device.Info{
+	"iphone_x":  device.IPhoneX.Device(),
+	"iphone_13": device.IPhone13.Device(),
+	"pixel_5":   device.Pixel5.Device(),
+	"galaxy_s9": device.GalaxyS9.Device(),
+	"ipad_pro":  device.IPadPro.Device(),
+}
+
+// CaptureConfig снимки отрисованных страниц: скриншот всей страницы и печать в PDF
+type CaptureConfig struct {
+	// Pattern регулярное выражение для URL; пусто - все страницы
+	Pattern string `json:"pattern"`
+	// Screenshot формат снимка: png или webp; пусто - без снимка
+	Screenshot string `json:"screenshot"`
+	// Quality качество webp от 1 до 100, по умолчанию 80
+	Quality int  `json:"quality"`
+	PDF     bool `json:"pdf"`
+	// Width и Height размер окна в CSS-пикселях, по умолчанию 1280x800
+	Width  int `json:"width"`
+	Height int `json:"height"`
+	// Scale плотность пикселей экрана, по умолчанию 1
+	Scale  float64 `json:"scale"`
+	Mobile bool    `json:"mobile"`
+	// Device профиль устройства вместо width, height, scale и mobile: iphone_x, iphone_13,
+	// pixel_5, galaxy_s9 или ipad_pro. User-Agent устройства не подменяет заданный в identity
+	Device string `json:"device"`
+	// MaxHeight предел высоты снимка в CSS-пикселях, по умолчанию 16384
+	MaxHeight int `json:"max_height"`
+	// Store куда сохранять снимки
+	Store blob.Config `json:"store"`
+}
+
+// Capture снимки страниц, URL которых подходит под шаблон. Методы допускают nil: снимки не нужны
+type Capture struct {
+	cfg    CaptureConfig
+	re     *regexp.Regexp
+	screen device.Info
+}
+
+// NewCapture проверяет настройки; если не нужны ни снимок, ни PDF, возвращает nil
+func NewCapture(cfg CaptureConfig) (*Capture, error) {
+	if cfg.Screenshot == "" && !cfg.PDF {
+		return nil, nil
+	}
+	c := &Capture{cfg: cfg}
+
+	switch cfg.Screenshot {
+	case "", ScreenshotPNG, ScreenshotWebP:
+	default:
+		return nil, fmt.Errorf("unknown screenshot format %q", cfg.Screenshot)
+	}
+	if c.cfg.Quality <= 0 || c.cfg.Quality > 100 {
+		c.cfg.Quality = 80
+	}
+	if c.cfg.MaxHeight <= 0 {
+		c.cfg.MaxHeight = 16384
+	}
+
+	if cfg.Pattern != "" {
+		re, err := regexp.Compile(cfg.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid capture pattern: %v", err)
+		}
+		c.re = re
+	}
+
+	if cfg.Device != "" {
+		info, ok := devices[cfg.Device]
+		if !ok {
+			names := make([]string, 0, len(devices))
+			for name := range devices {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return nil, fmt.Errorf("unknown device %q, expected one of %s", cfg.Device, strings.Join(names, ", "))
+		}
+		c.screen = info
+	} else {
+		c.screen = device.Info{
+			Width:  int64(cfg.Width),
+			Height: int64(cfg.Height),
+			Scale:  cfg.Scale,
+			Mobile: cfg.Mobile,
+			Touch:  cfg.Mobile,
+		}
+		if c.screen.Width <= 0 {
+			c.screen.Width = 1280
+		}
+		if c.screen.Height <= 0 {
+			c.screen.Height = 800
+		}
+		if c.screen.Scale <= 0 {
+			c.screen.Scale = 1
+		}
+	}
+	return c, nil
+}
+
+// Match нужны ли снимки страницы url
+func (c *Capture) Match(url string) bool {
+	return c != nil && (c.re == nil || c.re.MatchString(url))
+}
+
+// ScreenshotType MIME-тип снимка
+func (c *Capture) ScreenshotType() string {
+	return "image/" + c.cfg.Screenshot
+}
+
+func (c *Capture) timeout() time.Duration {
+	return 15 * time.Second
+}
+
+// emulate задает размер окна и устройство до перехода на страницу, чтобы она сверстала себя под них
+func (c *Capture) emulate() chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		err := emulation.SetDeviceMetricsOverride(c.screen.Width, c.screen.Height, c.screen.Scale, c.screen.Mobile).Do(ctx)
+		if err != nil {
+			return err
+		}
+		return emulation.SetTouchEmulationEnabled(c.screen.Touch).Do(ctx)
+	})
+}
+
+// run снимает страницу в page. Неудачный снимок не мешает сохранить страницу: ошибка только пишется в лог
+func (c *Capture) run(p *Page) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		logger := logging.FromContext(ctx)
+		if c.cfg.Screenshot != "" {
+			if err := c.screenshot(ctx, p); err != nil {
+				metrics.Captures.WithLabelValues("screenshot", "error").Inc()
+				logger.Warn("Failed to capture screenshot", logging.Err(err))
+			} else {
+				metrics.Captures.WithLabelValues("screenshot", "ok").Inc()
+			}
+		}
+		if c.cfg.PDF {
+			data, _, err := page.PrintToPDF().WithPrintBackground(true).Do(ctx)
+			if err != nil {
+				metrics.Captures.WithLabelValues("pdf", "error").Inc()
+				logger.Warn("Failed to print PDF", logging.Err(err))
+			} else {
+				metrics.Captures.WithLabelValues("pdf", "ok").Inc()
+				p.PDF = data
+			}
+		}
+		return nil
+	})
+}
+
+// screenshot снимает всю страницу, не выше MaxHeight, и считает хеш по уменьшенной копии
+func (c *Capture) screenshot(ctx context.Context, p *Page) error {
+	_, _, _, _, _, content, err := page.GetLayoutMetrics().Do(ctx)
+	if err != nil {
+		return err
+	}
+	width := math.Ceil(content.Width)
+	height := math.Min(math.Ceil(content.Height), float64(c.cfg.MaxHeight))
+	if width <= 0 || height <= 0 {
+		return fmt.Errorf("empty page")
+	}
+
+	shot := page.CaptureScreenshot().
+		WithCaptureBeyondViewport(true).
+		WithFromSurface(true).
+		WithClip(&page.Viewport{Width: width, Height: height, Scale: 1})
+	if c.cfg.Screenshot == ScreenshotWebP {
+		shot = shot.WithFormat(page.CaptureScreenshotFormatWebp).WithQuality(int64(c.cfg.Quality))
+	} else {
+		shot = shot.WithFormat(page.CaptureScreenshotFormatPng)
+	}
+	data, err := shot.Do(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Хеш считается по отдельному маленькому PNG: он не зависит от формата и качества снимка
+	thumb, err := page.CaptureScreenshot().
+		WithCaptureBeyondViewport(true).
+		WithFromSurface(true).
+		WithFormat(page.CaptureScreenshotFormatPng).
+		WithClip(&page.Viewport{Width: width, Height: height, Scale: hashWidth / width}).
+		Do(ctx)
+	if err != nil {
+		return err
+	}
+	img, err := png.Decode(bytes.NewReader(thumb))
+	if err != nil {
+		return fmt.Errorf("failed to decode screenshot: %v", err)
+	}
+
+	p.Screenshot = data
+	p.ScreenshotType = c.ScreenshotType()
+	p.VisualHash = phash.DHash(img)
+	return nil
+}
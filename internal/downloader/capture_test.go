@@ -0,0 +1,57 @@
+package downloader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCapture(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		c, err := NewCapture(CaptureConfig{Width: 390})
+		require.NoError(t, err)
+		assert.Nil(t, c)
+		assert.False(t, c.Match("https://example.com/"))
+	})
+
+	t.Run("defaults", func(t *testing.T) {
+		c, err := NewCapture(CaptureConfig{Screenshot: ScreenshotWebP})
+		require.NoError(t, err)
+		assert.True(t, c.Match("https://example.com/any"))
+		assert.Equal(t, "image/webp", c.ScreenshotType())
+		assert.Equal(t, 80, c.cfg.Quality)
+		assert.Equal(t, 16384, c.cfg.MaxHeight)
+		assert.Equal(t, int64(1280), c.screen.Width)
+		assert.Equal(t, int64(800), c.screen.Height)
+		assert.Equal(t, 1.0, c.screen.Scale)
+		assert.False(t, c.screen.Mobile)
+	})
+
+	t.Run("pattern and custom viewport", func(t *testing.T) {
+		c, err := NewCapture(CaptureConfig{PDF: true, Pattern: `^https://shop\.example\.com/`, Width: 390, Height: 844, Scale: 3, Mobile: true})
+		require.NoError(t, err)
+		assert.True(t, c.Match("https://shop.example.com/item/1"))
+		assert.False(t, c.Match("https://example.com/"))
+		assert.Equal(t, int64(390), c.screen.Width)
+		assert.True(t, c.screen.Touch)
+	})
+
+	t.Run("device", func(t *testing.T) {
+		c, err := NewCapture(CaptureConfig{Screenshot: ScreenshotPNG, Device: "pixel_5", Width: 1920})
+		require.NoError(t, err)
+		assert.True(t, c.screen.Mobile)
+		assert.Less(t, c.screen.Width, int64(1920), "device overrides width")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, cfg := range []CaptureConfig{
+			{Screenshot: "jpeg"},
+			{PDF: true, Pattern: "("},
+			{PDF: true, Device: "nokia_3310"},
+		} {
+			_, err := NewCapture(cfg)
+			assert.Error(t, err, cfg)
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package downloader
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+var utf8BOM = []byte("\xEF\xBB\xBF")
+
+// decodeHTML переводит страницу в UTF-8 и возвращает имя ее кодировки. Кодировка определяется
+// так же, как в браузере: по BOM, затем по charset из Content-Type, затем по <meta charset>
+// в начале страницы. Страница без объявления, целиком корректная в UTF-8, считается UTF-8,
+// иначе windows-1252
+func decodeHTML(data []byte, contentType string) (string, string, error) {
+	enc, name, certain := charset.DetermineEncoding(data, contentType)
+	if !certain && name == "windows-1252" && utf8.Valid(data) {
+		// DetermineEncoding смотрит только первый килобайт, где часто один ASCII
+		enc, name = encoding.Nop, "utf-8"
+	}
+	if name == "utf-8" {
+		data = bytes.TrimPrefix(data, utf8BOM)
+		if utf8.Valid(data) {
+			return string(data), name, nil
+		}
+	}
+	b, err := enc.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", name, fmt.Errorf("failed to decode %s: %v", name, err)
+	}
+	// Байты, которых нет в кодировке, не должны попасть в базу
+	return strings.ToValidUTF8(string(b), "�"), name, nil
+}
+
+// charsetName каноническое имя кодировки по метке, например "cp1251" -> "windows-1251"
+func charsetName(label string) string {
+	if _, name := charset.Lookup(label); name != "" {
+		return name
+	}
+	return strings.ToLower(strings.TrimSpace(label))
+}
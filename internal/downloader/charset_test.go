@@ -0,0 +1,102 @@
+package downloader
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+	"golang.org/x/text/encoding/charmap"
+)
+
+func encode(t *testing.T, enc *charmap.Charmap, s string) []byte {
+	b, err := enc.NewEncoder().Bytes([]byte(s))
+	require.NoError(t, err)
+	return b
+}
+
+func TestDecodeHTML(t *testing.T) {
+	page := "<html><head><title>Привет</title></head><body>Мир</body></html>"
+	padding := "<!--" + strings.Repeat(" ", 2048) + "-->"
+
+	for _, tc := range []struct {
+		name        string
+		data        []byte
+		contentType string
+		charset     string
+	}{
+		{"header", encode(t, charmap.Windows1251, page), "text/html; charset=windows-1251", "windows-1251"},
+		{"header alias", encode(t, charmap.Windows1251, page), "text/html; charset=cp1251", "windows-1251"},
+		{"meta", encode(t, charmap.KOI8R, `<meta charset="koi8-r">`+page), "text/html", "koi8-r"},
+		{"meta http-equiv", encode(t, charmap.Windows1251, `<meta http-equiv="Content-Type" content="text/html; charset=windows-1251">`+page), "", "windows-1251"},
+		{"bom wins over header", []byte("\xEF\xBB\xBF" + page), "text/html; charset=windows-1251", "utf-8"},
+		{"undeclared utf-8 after first kilobyte", []byte(padding + page), "text/html", "utf-8"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			text, name, err := decodeHTML(tc.data, tc.contentType)
+			require.NoError(t, err)
+			assert.Equal(t, tc.charset, name)
+			assert.Contains(t, text, "<title>Привет</title>")
+			assert.False(t, strings.HasPrefix(text, "\uFEFF"), "BOM is removed")
+		})
+	}
+
+	t.Run("undeclared legacy encoding", func(t *testing.T) {
+		_, name, err := decodeHTML([]byte("<p>caf\xE9</p>"), "")
+		require.NoError(t, err)
+		assert.Equal(t, "windows-1252", name)
+	})
+
+	t.Run("invalid utf-8", func(t *testing.T) {
+		text, name, err := decodeHTML([]byte("<p>\xFF\xFE ok</p>"), "text/html; charset=utf-8")
+		require.NoError(t, err)
+		assert.Equal(t, "utf-8", name)
+		assert.Equal(t, "<p>�� ok</p>", text)
+	})
+}
+
+func TestCharsetName(t *testing.T) {
+	assert.Equal(t, "utf-8", charsetName("UTF-8"))
+	assert.Equal(t, "windows-1251", charsetName("windows-1251"))
+	assert.Equal(t, "koi8-r", charsetName("KOI8-R"))
+	assert.Equal(t, "x-unknown", charsetName(" X-Unknown"))
+}
+
+func TestFetchStaticCharset(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	server := newTestDNSServer(t)
+	server.A("site.test", 300, "127.0.0.1")
+	resolver := newTestResolver(t, []string{server.addr}, DNSConfig{TimeoutMs: 500}, newRedisCache(mr))
+
+	body := encode(t, charmap.Windows1251, `<html><head><meta charset="windows-1251"><title>Новости</title></head><body><p>Погода в Москве</p></body></html>`)
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write(body)
+	}))
+	defer site.Close()
+	_, port, _ := net.SplitHostPort(site.Listener.Addr().String())
+
+	fetcher := &Fetcher{Resolver: resolver}
+	page, err := fetcher.FetchStaticPage(context.Background(), "http://site.test:"+port+"/")
+	require.NoError(t, err)
+	assert.Equal(t, "windows-1251", page.Charset)
+	assert.Equal(t, "text/html", page.ContentType)
+
+	n, err := fetcher.FetchStaticHTML(context.Background(), "http://site.test:"+port+"/")
+	require.NoError(t, err)
+
+	var b strings.Builder
+	require.NoError(t, html.Render(&b, n))
+	title, text := ExtractText(b.String())
+	assert.Equal(t, "Новости", title)
+	assert.Equal(t, "Погода в Москве", text)
+}
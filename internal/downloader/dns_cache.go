@@ -0,0 +1,216 @@
+package downloader
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"web_crawler/internal/logging"
+	"web_crawler/internal/metrics"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Откуда взята запись кеша; используется как метка метрики
+const (
+	cacheMemory = "memory"
+	cacheRedis  = "redis"
+	cacheMiss   = "miss"
+)
+
+// DNSCache двухуровневый кеш DNS: LRU в памяти процесса и общий Redis.
+// Пока Redis недоступен, кеш работает только в памяти и не тормозит резолвинг
+type DNSCache struct {
+	client *redis.Client
+	// memory nil, если кеш в памяти отключен
+	memory *memoryCache
+	retry  time.Duration
+
+	mu sync.Mutex
+	// downUntil до какого момента Redis не опрашивается после ошибки
+	downUntil time.Time
+}
+
+// NewDNSCache создает кеш; используются поля CacheSize и RedisRetrySec из cfg
+func NewDNSCache(addr string, cfg DNSConfig) *DNSCache {
+	cfg = cfg.withDefaults()
+	dc := &DNSCache{
+		client: redis.NewClient(&redis.Options{ // ===================================================== !
+			Addr:     addr,
+			Password: "", // no password set
+			DB:       0,  // use default DB
+		}),
+		retry: time.Duration(cfg.RedisRetrySec) * time.Second,
+	}
+	if cfg.CacheSize > 0 {
+		dc.memory = newMemoryCache(cfg.CacheSize)
+	}
+	return dc
+}
+
+func (dc *DNSCache) Get(ctx context.Context, host string) (*DNSEntry, error) {
+	entry, _, err := dc.get(ctx, host)
+	return entry, err
+}
+
+// get ищет запись сначала в памяти, затем в Redis, и возвращает, где она нашлась
+func (dc *DNSCache) get(ctx context.Context, host string) (*DNSEntry, string, error) {
+	if entry := dc.memory.get(host, time.Now()); entry != nil {
+		return entry, cacheMemory, nil
+	}
+	if !dc.redisAvailable() {
+		return nil, cacheMiss, nil
+	}
+
+	val, err := dc.client.Get(ctx, "dns:"+host).Result()
+	if err == redis.Nil {
+		dc.redisUp(ctx)
+		return nil, cacheMiss, nil // Ключ не найден - это не ошибка
+	} else if err != nil {
+		dc.redisDown(ctx, err)
+		return nil, cacheMiss, nil
+	}
+	dc.redisUp(ctx)
+
+	var entry DNSEntry
+	if err := json.Unmarshal([]byte(val), &entry); err != nil {
+		return nil, cacheMiss, err
+	}
+	dc.memory.set(host, &entry, time.Now())
+	return &entry, cacheRedis, nil
+}
+
+// Set сохраняет запись; ключ истекает вместе с TTL записи
+func (dc *DNSCache) Set(ctx context.Context, host string, entry *DNSEntry) error {
+	dc.memory.set(host, entry, time.Now())
+	if !dc.redisAvailable() {
+		return nil
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := dc.client.Set(ctx, "dns:"+host, data, time.Duration(entry.TTL)*time.Second).Err(); err != nil {
+		dc.redisDown(ctx, err)
+		return nil
+	}
+	dc.redisUp(ctx)
+	return nil
+}
+
+// Degraded сообщает, что Redis недоступен и кеш работает только в памяти
+func (dc *DNSCache) Degraded() bool {
+	return !dc.redisAvailable()
+}
+
+func (dc *DNSCache) redisAvailable() bool {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	return dc.downUntil.IsZero() || time.Now().After(dc.downUntil)
+}
+
+// redisDown отключает Redis на время retry. Ошибка из-за отмены запроса вызывающим не считается
+func (dc *DNSCache) redisDown(ctx context.Context, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+
+	dc.mu.Lock()
+	first := dc.downUntil.IsZero()
+	dc.downUntil = time.Now().Add(dc.retry)
+	dc.mu.Unlock()
+
+	metrics.DNSCacheDegraded.Set(1)
+	if first {
+		logging.FromContext(ctx).Warn("Redis is unavailable, DNS cache works in memory only",
+			"retry_in", dc.retry, logging.Err(err))
+	}
+}
+
+func (dc *DNSCache) redisUp(ctx context.Context) {
+	dc.mu.Lock()
+	wasDown := !dc.downUntil.IsZero()
+	dc.downUntil = time.Time{}
+	dc.mu.Unlock()
+
+	if wasDown {
+		metrics.DNSCacheDegraded.Set(0)
+		logging.FromContext(ctx).Info("Redis is available again, DNS cache uses it")
+	}
+}
+
+// memoryCache LRU ограниченного размера; записи истекают по ExpiresAt
+type memoryCache struct {
+	mu   sync.Mutex
+	size int
+	// order от недавно использованных к давно использованным
+	order *list.List
+	items map[string]*list.Element
+}
+
+type memoryItem struct {
+	host  string
+	entry *DNSEntry
+}
+
+func newMemoryCache(size int) *memoryCache {
+	return &memoryCache{
+		size:  size,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// get возвращает неистекшую запись; методы допускают nil-кеш
+func (c *memoryCache) get(host string, now time.Time) *DNSEntry {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[host]
+	if !ok {
+		return nil
+	}
+	item := el.Value.(*memoryItem)
+	if !now.Before(item.entry.ExpiresAt) {
+		c.order.Remove(el)
+		delete(c.items, host)
+		return nil
+	}
+	c.order.MoveToFront(el)
+	return item.entry
+}
+
+func (c *memoryCache) set(host string, entry *DNSEntry, now time.Time) {
+	if c == nil || !now.Before(entry.ExpiresAt) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[host]; ok {
+		el.Value.(*memoryItem).entry = entry
+		c.order.MoveToFront(el)
+		return
+	}
+	c.items[host] = c.order.PushFront(&memoryItem{host: host, entry: entry})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*memoryItem).host)
+	}
+}
+
+func (c *memoryCache) len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,159 @@
+package downloader
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryCache(t *testing.T) {
+	now := time.Now()
+	entry := func(ip string, ttl time.Duration) *DNSEntry {
+		return &DNSEntry{IPs: []net.IP{net.ParseIP(ip)}, ExpiresAt: now.Add(ttl)}
+	}
+
+	t.Run("LRU eviction", func(t *testing.T) {
+		c := newMemoryCache(2)
+		c.set("a", entry("10.0.0.1", time.Minute), now)
+		c.set("b", entry("10.0.0.2", time.Minute), now)
+		require.NotNil(t, c.get("a", now), "a becomes the most recently used")
+		c.set("c", entry("10.0.0.3", time.Minute), now)
+
+		assert.Equal(t, 2, c.len())
+		assert.NotNil(t, c.get("a", now))
+		assert.Nil(t, c.get("b", now))
+		assert.NotNil(t, c.get("c", now))
+	})
+
+	t.Run("expiration", func(t *testing.T) {
+		c := newMemoryCache(10)
+		c.set("a", entry("10.0.0.1", time.Minute), now)
+		c.set("expired", entry("10.0.0.2", -time.Second), now)
+
+		assert.NotNil(t, c.get("a", now))
+		assert.Nil(t, c.get("a", now.Add(2*time.Minute)))
+		assert.Equal(t, 0, c.len())
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		var c *memoryCache
+		c.set("a", entry("10.0.0.1", time.Minute), now)
+		assert.Nil(t, c.get("a", now))
+	})
+}
+
+func TestDNSCacheTiers(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	ctx := context.Background()
+	entry := &DNSEntry{IPs: []net.IP{net.ParseIP("10.0.0.1")}, TTL: 60, ExpiresAt: time.Now().Add(time.Minute)}
+
+	t.Run("memory in front of Redis", func(t *testing.T) {
+		cache := NewDNSCache(mr.Addr(), DNSConfig{})
+		require.NoError(t, cache.Set(ctx, "tiers.test", entry))
+		assert.True(t, mr.Exists("dns:tiers.test"))
+
+		mr.FlushAll()
+		got, source, err := cache.get(ctx, "tiers.test")
+		require.NoError(t, err)
+		require.NotNil(t, got)
+		assert.Equal(t, cacheMemory, source)
+	})
+
+	t.Run("Redis hit fills memory", func(t *testing.T) {
+		shared := NewDNSCache(mr.Addr(), DNSConfig{})
+		require.NoError(t, shared.Set(ctx, "shared.test", entry))
+
+		cache := NewDNSCache(mr.Addr(), DNSConfig{})
+		_, source, err := cache.get(ctx, "shared.test")
+		require.NoError(t, err)
+		assert.Equal(t, cacheRedis, source)
+
+		_, source, err = cache.get(ctx, "shared.test")
+		require.NoError(t, err)
+		assert.Equal(t, cacheMemory, source)
+	})
+
+	t.Run("degraded mode", func(t *testing.T) {
+		cache := NewDNSCache(mr.Addr(), DNSConfig{RedisRetrySec: 60})
+		mr.SetError("LOADING Redis is loading the dataset in memory")
+		defer mr.SetError("")
+
+		got, err := cache.Get(ctx, "down.test")
+		assert.NoError(t, err, "unavailable Redis is not an error")
+		assert.Nil(t, got)
+		assert.True(t, cache.Degraded())
+
+		// Пока Redis отключен, записи живут только в памяти
+		require.NoError(t, cache.Set(ctx, "down.test", entry))
+		mr.SetError("")
+		assert.False(t, mr.Exists("dns:down.test"))
+		got, err = cache.Get(ctx, "down.test")
+		require.NoError(t, err)
+		assert.NotNil(t, got)
+	})
+
+	t.Run("Redis comes back", func(t *testing.T) {
+		cache := NewDNSCache(mr.Addr(), DNSConfig{CacheSize: -1, RedisRetrySec: 1})
+		mr.SetError("ERR down")
+		_, err := cache.Get(ctx, "back.test")
+		require.NoError(t, err)
+		require.True(t, cache.Degraded())
+		mr.SetError("")
+
+		cache.mu.Lock()
+		cache.downUntil = time.Now().Add(-time.Second)
+		cache.mu.Unlock()
+
+		require.NoError(t, cache.Set(ctx, "back.test", entry))
+		assert.False(t, cache.Degraded())
+		assert.True(t, mr.Exists("dns:back.test"))
+	})
+}
+
+func TestDNSResolverSingleflight(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	server := newTestDNSServer(t)
+	server.A("flight.test", 300, "10.0.2.1")
+	server.mu.Lock()
+	server.delay = 50 * time.Millisecond
+	server.mu.Unlock()
+
+	r := newTestResolver(t, []string{server.addr}, DNSConfig{TimeoutMs: 1000}, NewDNSCache(mr.Addr(), DNSConfig{}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ips, err := r.Resolve(context.Background(), "flight.test")
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"10.0.2.1"}, ipStrings(ips))
+		}()
+	}
+	wg.Wait()
+
+	// Один общий запрос A и один AAAA
+	assert.Equal(t, int64(2), server.Queries())
+
+	t.Run("Redis down does not fail resolving", func(t *testing.T) {
+		mr.SetError("ERR down")
+		defer mr.SetError("")
+
+		server.A("noredis.test", 300, "10.0.2.2")
+		ips, err := r.Resolve(context.Background(), "noredis.test")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"10.0.2.2"}, ipStrings(ips))
+	})
+}
@@ -0,0 +1,219 @@
+package downloader
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"web_crawler/internal/metrics"
+)
+
+// Стратегии выбора DNS-сервера
+const (
+	// StrategyFastest сначала сервер с наименьшей средней задержкой
+	StrategyFastest = "fastest"
+	// StrategyRoundRobin серверы по кругу
+	StrategyRoundRobin = "round_robin"
+	// StrategyWeighted случайный сервер с весом, обратным задержке
+	StrategyWeighted = "weighted"
+	// StrategyRace запрос уходит двум самым быстрым серверам, побеждает первый ответ
+	StrategyRace = "race"
+)
+
+// Состояния circuit breaker сервера
+const (
+	upstreamClosed   = "closed"
+	upstreamOpen     = "open"
+	upstreamHalfOpen = "half-open"
+)
+
+// ewmaAlpha вес нового замера в скользящей средней задержки
+const ewmaAlpha = 0.3
+
+// UpstreamStats статистика одного DNS-сервера
+type UpstreamStats struct {
+	Upstream  string  `json:"upstream"`
+	Queries   int64   `json:"queries"`
+	Failures  int64   `json:"failures"`
+	LatencyMs float64 `json:"latency_ms"`
+	State     string  `json:"state"`
+}
+
+// DiffStats вычитает из after счетчики before, чтобы получить статистику за период.
+// Задержка и состояние берутся из after
+func DiffStats(before, after []UpstreamStats) []UpstreamStats {
+	prev := make(map[string]UpstreamStats, len(before))
+	for _, s := range before {
+		prev[s.Upstream] = s
+	}
+
+	out := make([]UpstreamStats, 0, len(after))
+	for _, s := range after {
+		p := prev[s.Upstream]
+		s.Queries -= p.Queries
+		s.Failures -= p.Failures
+		out = append(out, s)
+	}
+	return out
+}
+
+// upstreamHealth здоровье сервера: средняя задержка, счетчики ошибок и circuit breaker
+type upstreamHealth struct {
+	up dnsUpstream
+
+	mu sync.Mutex
+	// ewma средняя задержка в секундах; 0 - замеров еще не было
+	ewma        float64
+	queries     int64
+	failures    int64
+	consecutive int
+	// openUntil до какого момента сервер исключен из выбора
+	openUntil time.Time
+}
+
+func (h *upstreamHealth) latency() float64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.ewma
+}
+
+// acquire сообщает, можно ли сейчас слать запрос серверу. После cooldown открытый
+// breaker пропускает одну пробу: на время probe сервер снова исключается из выбора
+func (h *upstreamHealth) acquire(now time.Time, probe time.Duration) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.openUntil.IsZero() {
+		return true
+	}
+	if now.Before(h.openUntil) {
+		return false
+	}
+	h.openUntil = now.Add(probe)
+	return true
+}
+
+// record учитывает результат запроса. Сетевые ошибки и таймауты считаются отказами,
+// SERVFAIL - нет: он чаще говорит о сломанной зоне, чем о сервере
+func (h *upstreamHealth) record(latency time.Duration, failed bool, threshold int, cooldown time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.queries++
+	if h.ewma == 0 {
+		h.ewma = latency.Seconds()
+	} else {
+		h.ewma = ewmaAlpha*latency.Seconds() + (1-ewmaAlpha)*h.ewma
+	}
+
+	result := "success"
+	if failed {
+		result = "error"
+		h.failures++
+		h.consecutive++
+		if h.consecutive >= threshold {
+			h.openUntil = time.Now().Add(cooldown)
+		}
+	} else {
+		h.consecutive = 0
+		h.openUntil = time.Time{}
+	}
+
+	name := h.up.String()
+	metrics.DNSUpstreamRequests.WithLabelValues(name, result).Inc()
+	metrics.DNSUpstreamLatency.WithLabelValues(name).Observe(latency.Seconds())
+	metrics.DNSUpstreamOpen.WithLabelValues(name).Set(boolGauge(!h.openUntil.IsZero()))
+}
+
+func (h *upstreamHealth) stats(now time.Time) UpstreamStats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state := upstreamClosed
+	switch {
+	case h.openUntil.IsZero():
+	case now.Before(h.openUntil):
+		state = upstreamOpen
+	default:
+		state = upstreamHalfOpen
+	}
+	return UpstreamStats{
+		Upstream:  h.up.String(),
+		Queries:   h.queries,
+		Failures:  h.failures,
+		LatencyMs: math.Round(h.ewma*1e6) / 1e3,
+		State:     state,
+	}
+}
+
+func boolGauge(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+// upstreamSelector упорядочивает серверы для одного запроса согласно стратегии
+type upstreamSelector struct {
+	strategy string
+	next     uint64
+}
+
+// order возвращает доступные серверы в порядке опроса, а серверы с открытым
+// breaker - в конце, как последний шанс
+func (s *upstreamSelector) order(health []*upstreamHealth, probe time.Duration) []*upstreamHealth {
+	now := time.Now()
+	var available, open []*upstreamHealth
+	for _, h := range health {
+		if h.acquire(now, probe) {
+			available = append(available, h)
+		} else {
+			open = append(open, h)
+		}
+	}
+
+	switch s.strategy {
+	case StrategyRoundRobin:
+		if n := len(available); n > 0 {
+			shift := int(atomic.AddUint64(&s.next, 1) % uint64(n))
+			available = append(available[shift:], available[:shift]...)
+		}
+	case StrategyWeighted:
+		available = weightedOrder(available)
+	default:
+		// Серверы без замеров идут первыми, чтобы их задержка стала известна
+		sort.SliceStable(available, func(i, j int) bool {
+			return available[i].latency() < available[j].latency()
+		})
+	}
+	return append(available, open...)
+}
+
+// weightedOrder случайная перестановка, в которой быстрые серверы чаще оказываются первыми
+func weightedOrder(health []*upstreamHealth) []*upstreamHealth {
+	rest := append([]*upstreamHealth(nil), health...)
+	out := make([]*upstreamHealth, 0, len(rest))
+	for len(rest) > 0 {
+		weights := make([]float64, len(rest))
+		total := 0.0
+		for i, h := range rest {
+			// 1 мс в знаменателе не дает серверу без замеров получить бесконечный вес
+			weights[i] = 1 / (h.latency() + 0.001)
+			total += weights[i]
+		}
+
+		pick := rand.Float64() * total
+		i := 0
+		for ; i < len(rest)-1; i++ {
+			pick -= weights[i]
+			if pick < 0 {
+				break
+			}
+		}
+		out = append(out, rest[i])
+		rest = append(rest[:i], rest[i+1:]...)
+	}
+	return out
+}
@@ -0,0 +1,167 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// namedUpstream upstream-заглушка для тестов выбора серверов
+type namedUpstream string
+
+func (u namedUpstream) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (u namedUpstream) String() string { return string(u) }
+
+func newHealth(name string, latency time.Duration) *upstreamHealth {
+	return &upstreamHealth{up: namedUpstream(name), ewma: latency.Seconds()}
+}
+
+func names(health []*upstreamHealth) []string {
+	out := make([]string, len(health))
+	for i, h := range health {
+		out[i] = h.up.String()
+	}
+	return out
+}
+
+func TestUpstreamHealth(t *testing.T) {
+	t.Run("latency EWMA", func(t *testing.T) {
+		h := newHealth("a", 0)
+		h.record(100*time.Millisecond, false, 3, time.Minute)
+		h.record(200*time.Millisecond, false, 3, time.Minute)
+
+		stats := h.stats(time.Now())
+		assert.Equal(t, int64(2), stats.Queries)
+		assert.Equal(t, int64(0), stats.Failures)
+		assert.InDelta(t, 130, stats.LatencyMs, 0.001)
+		assert.Equal(t, upstreamClosed, stats.State)
+	})
+
+	t.Run("circuit breaker", func(t *testing.T) {
+		h := newHealth("a", 0)
+		now := time.Now()
+		h.record(time.Millisecond, true, 2, time.Minute)
+		assert.True(t, h.acquire(now, time.Second), "one failure keeps the breaker closed")
+
+		h.record(time.Millisecond, true, 2, time.Minute)
+		assert.False(t, h.acquire(now, time.Second))
+		assert.Equal(t, upstreamOpen, h.stats(now).State)
+
+		// После cooldown пропускается одна проба
+		later := now.Add(2 * time.Minute)
+		assert.Equal(t, upstreamHalfOpen, h.stats(later).State)
+		assert.True(t, h.acquire(later, time.Second))
+		assert.False(t, h.acquire(later, time.Second), "only one probe at a time")
+
+		h.record(time.Millisecond, false, 2, time.Minute)
+		assert.True(t, h.acquire(later, time.Second))
+		assert.Equal(t, upstreamClosed, h.stats(later).State)
+		assert.Equal(t, int64(2), h.stats(later).Failures)
+	})
+
+	t.Run("DiffStats", func(t *testing.T) {
+		before := []UpstreamStats{{Upstream: "a", Queries: 5, Failures: 1}}
+		after := []UpstreamStats{{Upstream: "a", Queries: 8, Failures: 3, LatencyMs: 10}, {Upstream: "b", Queries: 2}}
+
+		assert.Equal(t, []UpstreamStats{
+			{Upstream: "a", Queries: 3, Failures: 2, LatencyMs: 10},
+			{Upstream: "b", Queries: 2},
+		}, DiffStats(before, after))
+	})
+}
+
+func TestUpstreamSelector(t *testing.T) {
+	slow := newHealth("slow", 50*time.Millisecond)
+	fast := newHealth("fast", 5*time.Millisecond)
+	fresh := newHealth("fresh", 0)
+	health := []*upstreamHealth{slow, fast, fresh}
+
+	t.Run("fastest", func(t *testing.T) {
+		s := &upstreamSelector{strategy: StrategyFastest}
+		assert.Equal(t, []string{"fresh", "fast", "slow"}, names(s.order(health, time.Second)))
+	})
+
+	t.Run("round robin", func(t *testing.T) {
+		s := &upstreamSelector{strategy: StrategyRoundRobin}
+		first := names(s.order(health, time.Second))
+		second := names(s.order(health, time.Second))
+		assert.Equal(t, []string{"fast", "fresh", "slow"}, first)
+		assert.Equal(t, []string{"fresh", "slow", "fast"}, second)
+	})
+
+	t.Run("weighted prefers fast servers", func(t *testing.T) {
+		s := &upstreamSelector{strategy: StrategyWeighted}
+		pair := []*upstreamHealth{slow, fast}
+		wins := 0
+		for i := 0; i < 1000; i++ {
+			order := s.order(pair, time.Second)
+			require.Len(t, order, 2)
+			if order[0] == fast {
+				wins++
+			}
+		}
+		assert.Greater(t, wins, 800)
+	})
+
+	t.Run("open servers go last", func(t *testing.T) {
+		broken := newHealth("broken", time.Millisecond)
+		broken.openUntil = time.Now().Add(time.Minute)
+
+		s := &upstreamSelector{strategy: StrategyFastest}
+		assert.Equal(t, []string{"fast", "slow", "broken"}, names(s.order([]*upstreamHealth{broken, slow, fast}, time.Second)))
+	})
+}
+
+func TestDNSResolverHealth(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	server := newTestDNSServer(t)
+	server.A("health.test", 300, "10.0.1.1")
+	ctx := context.Background()
+
+	t.Run("dead server is skipped after the breaker opens", func(t *testing.T) {
+		dead := closedUDPAddr(t)
+		cfg := DNSConfig{TimeoutMs: 500, Strategy: StrategyRoundRobin, FailureThreshold: 1, CooldownSec: 60}
+		r := newTestResolver(t, []string{dead, server.addr}, cfg, newRedisCache(mr))
+
+		for i := 0; i < 3; i++ {
+			mr.FlushAll()
+			ips, err := r.Resolve(ctx, "health.test")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"10.0.1.1"}, ipStrings(ips))
+		}
+
+		stats := r.Stats()
+		require.Len(t, stats, 2)
+		assert.Equal(t, "udp://"+dead, stats[0].Upstream)
+		assert.Equal(t, upstreamOpen, stats[0].State)
+		assert.LessOrEqual(t, stats[0].Failures, int64(2), "open breaker stops queries to the dead server")
+		assert.Equal(t, int64(6), stats[1].Queries)
+		assert.Equal(t, int64(0), stats[1].Failures)
+	})
+
+	t.Run("race", func(t *testing.T) {
+		cfg := DNSConfig{TimeoutMs: 500, Strategy: StrategyRace}
+		r := newTestResolver(t, []string{closedUDPAddr(t), server.addr}, cfg, newRedisCache(mr))
+
+		mr.FlushAll()
+		ips, err := r.Resolve(ctx, "health.test")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"10.0.1.1"}, ipStrings(ips))
+	})
+
+	t.Run("unknown strategy", func(t *testing.T) {
+		_, err := NewDNSResolver([]string{server.addr}, DNSConfig{Strategy: "random"}, newRedisCache(mr))
+		assert.Error(t, err)
+	})
+}
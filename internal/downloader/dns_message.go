@@ -0,0 +1,218 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ednsUDPSize размер UDP-ответа, который мы объявляем серверу (рекомендация DNS Flag Day 2020)
+const ednsUDPSize = 1232
+
+// maxCNAMEChain сколько CNAME подряд резолвер готов пройти
+const maxCNAMEChain = 8
+
+var errDNSIDMismatch = errors.New("dns response id mismatch")
+
+// dnsAnswer разобранный ответ на один вопрос
+type dnsAnswer struct {
+	rcode dnsmessage.RCode
+	ips   []net.IP
+	// ttl минимальный TTL записей цепочки от имени вопроса до адресов
+	ttl uint32
+	// target конец цепочки CNAME, для которого в ответе нет адресов; пустой, если цепочка разрешена
+	target string
+	// negTTL TTL отрицательного ответа из SOA (RFC 2308); 0, если SOA нет
+	negTTL    uint32
+	truncated bool
+}
+
+// fqdn приводит имя хоста к абсолютному виду с точкой на конце
+func fqdn(host string) string {
+	if strings.HasSuffix(host, ".") {
+		return host
+	}
+	return host + "."
+}
+
+func buildQuery(id uint16, name string, qtype dnsmessage.Type) ([]byte, error) {
+	qname, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	b := dnsmessage.NewBuilder(make([]byte, 0, 512), dnsmessage.Header{ID: id, RecursionDesired: true})
+	b.EnableCompression()
+	if err := b.StartQuestions(); err != nil {
+		return nil, err
+	}
+	if err := b.Question(dnsmessage.Question{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}); err != nil {
+		return nil, err
+	}
+	if err := b.StartAdditionals(); err != nil {
+		return nil, err
+	}
+	var opt dnsmessage.ResourceHeader
+	if err := opt.SetEDNS0(ednsUDPSize, dnsmessage.RCodeSuccess, false); err != nil {
+		return nil, err
+	}
+	if err := b.OPTResource(opt, dnsmessage.OPTResource{}); err != nil {
+		return nil, err
+	}
+	return b.Finish()
+}
+
+// parseAnswer разбирает ответ и проходит цепочку CNAME, начиная с name
+func parseAnswer(msg []byte, id uint16, name string, qtype dnsmessage.Type) (*dnsAnswer, error) {
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil {
+		return nil, err
+	}
+	if h.ID != id {
+		return nil, errDNSIDMismatch
+	}
+	if !h.Response {
+		return nil, fmt.Errorf("dns message is not a response")
+	}
+
+	ans := &dnsAnswer{rcode: h.RCode, truncated: h.Truncated}
+	if h.Truncated {
+		return ans, nil
+	}
+
+	questions, err := p.AllQuestions()
+	if err != nil {
+		return nil, err
+	}
+	if len(questions) != 1 || !strings.EqualFold(questions[0].Name.String(), name) || questions[0].Type != qtype {
+		return nil, fmt.Errorf("dns response does not match question %s", name)
+	}
+
+	cnames := make(map[string]string)
+	cnameTTL := make(map[string]uint32)
+	ips := make(map[string][]net.IP)
+	ipTTL := make(map[string]uint32)
+	for {
+		rh, err := p.AnswerHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		owner := strings.ToLower(rh.Name.String())
+		switch rh.Type {
+		case dnsmessage.TypeCNAME:
+			r, err := p.CNAMEResource()
+			if err != nil {
+				return nil, err
+			}
+			cnames[owner] = strings.ToLower(r.CNAME.String())
+			cnameTTL[owner] = rh.TTL
+		case dnsmessage.TypeA:
+			r, err := p.AResource()
+			if err != nil {
+				return nil, err
+			}
+			if qtype == dnsmessage.TypeA {
+				ips[owner] = append(ips[owner], net.IP(r.A[:]).To16())
+				ipTTL[owner] = minTTL(ipTTL[owner], rh.TTL)
+			}
+		case dnsmessage.TypeAAAA:
+			r, err := p.AAAAResource()
+			if err != nil {
+				return nil, err
+			}
+			if qtype == dnsmessage.TypeAAAA {
+				ips[owner] = append(ips[owner], net.IP(r.AAAA[:]))
+				ipTTL[owner] = minTTL(ipTTL[owner], rh.TTL)
+			}
+		default:
+			if err := p.SkipAnswer(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// SOA в authority задает TTL отрицательного ответа
+	for {
+		rh, err := p.AuthorityHeader()
+		if err == dnsmessage.ErrSectionDone {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if rh.Type != dnsmessage.TypeSOA {
+			if err := p.SkipAuthority(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		soa, err := p.SOAResource()
+		if err != nil {
+			return nil, err
+		}
+		ans.negTTL = minTTL(rh.TTL, soa.MinTTL)
+	}
+
+	cur := strings.ToLower(name)
+	for i := 0; ; i++ {
+		if addrs, ok := ips[cur]; ok {
+			ans.ips = addrs
+			ans.ttl = minTTL(ans.ttl, ipTTL[cur])
+			return ans, nil
+		}
+		next, ok := cnames[cur]
+		if !ok {
+			break
+		}
+		if i >= maxCNAMEChain {
+			return nil, fmt.Errorf("CNAME chain of %s is too long", name)
+		}
+		ans.ttl = minTTL(ans.ttl, cnameTTL[cur])
+		cur = next
+	}
+	if cur != strings.ToLower(name) {
+		ans.target = cur
+	}
+	return ans, nil
+}
+
+// minTTL возвращает меньший из TTL, считая 0 отсутствием значения
+func minTTL(a, b uint32) uint32 {
+	if a == 0 || (b != 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// exchange отправляет вопрос name/qtype через upstream и разбирает ответ
+func exchange(ctx context.Context, up dnsUpstream, name string, qtype dnsmessage.Type, timeout time.Duration) (*dnsAnswer, error) {
+	id := uint16(rand.Intn(1 << 16))
+	query, err := buildQuery(id, name, qtype)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	msg, err := up.exchange(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	ans, err := parseAnswer(msg, id, name, qtype)
+	if err == nil && ans.truncated {
+		return nil, fmt.Errorf("truncated response")
+	}
+	return ans, err
+}
@@ -2,104 +2,400 @@ package downloader
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"math/rand"
 	"net"
+	"sync"
 	"time"
 
-	"github.com/redis/go-redis/v9"
+	"web_crawler/internal/logging"
+	"web_crawler/internal/metrics"
+	"web_crawler/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/dns/dnsmessage"
+	"golang.org/x/sync/singleflight"
+)
+
+// Отрицательные ответы DNS; кешируются так же, как адреса
+var (
+	ErrNXDomain    = errors.New("no such host")
+	ErrServFail    = errors.New("server failure")
+	ErrNoAddresses = errors.New("no IP addresses found")
 )
 
-type DNSCache struct {
-	client *redis.Client
-	ttl    time.Duration
+// Причины отрицательного ответа в DNSEntry
+const (
+	negativeNXDomain = "NXDOMAIN"
+	negativeServFail = "SERVFAIL"
+	negativeNoData   = "NODATA"
+)
+
+// DNSEntry запись кеша DNS: адреса или причина отрицательного ответа вместе с TTL
+type DNSEntry struct {
+	IPs      []net.IP `json:"ips,omitempty"`
+	Negative string   `json:"negative,omitempty"`
+	// TTL в секундах, с которым запись была сохранена
+	TTL       uint32    `json:"ttl"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
-func NewDNSCache(addr string, ttl time.Duration) *DNSCache {
-	return &DNSCache{
-		client: redis.NewClient(&redis.Options{ // ===================================================== !
-			Addr:     addr,
-			Password: "", // no password set
-			DB:       0,  // use default DB
-		}),
-		ttl: ttl,
+// Err возвращает ошибку для отрицательной записи или nil
+func (e *DNSEntry) Err(host string) error {
+	switch e.Negative {
+	case "":
+		return nil
+	case negativeNXDomain:
+		return fmt.Errorf("lookup %s: %w", host, ErrNXDomain)
+	case negativeServFail:
+		return fmt.Errorf("lookup %s: %w", host, ErrServFail)
+	default:
+		return fmt.Errorf("lookup %s: %w", host, ErrNoAddresses)
 	}
 }
 
-func (dc *DNSCache) Get(ctx context.Context, host string) ([]net.IP, error) {
-	val, err := dc.client.Get(ctx, "dns:"+host).Result()
-	if err == redis.Nil {
-		return nil, nil // Ключ не найден - это не ошибка
-	} else if err != nil {
-		return nil, err
-	}
+// DNSConfig настройки резолвера, значения в секундах
+type DNSConfig struct {
+	// MinTTL и MaxTTL ограничивают TTL из ответа; по умолчанию 30 секунд и сутки
+	MinTTL uint32 `json:"min_ttl"`
+	MaxTTL uint32 `json:"max_ttl"`
+	// NegativeTTL TTL для NXDOMAIN/SERVFAIL без SOA и верхняя граница TTL из SOA, по умолчанию 60
+	NegativeTTL uint32 `json:"negative_ttl"`
+	// TimeoutMs время ожидания ответа одного сервера, по умолчанию 2000
+	TimeoutMs int `json:"timeout_ms"`
+	// Strategy порядок опроса серверов: fastest (по умолчанию), round_robin, weighted или race
+	Strategy string `json:"strategy"`
+	// FailureThreshold число ошибок подряд, после которого сервер исключается, по умолчанию 3
+	FailureThreshold int `json:"failure_threshold"`
+	// CooldownSec на сколько исключается сервер, по умолчанию 30
+	CooldownSec int `json:"cooldown_sec"`
+	// CacheSize число записей в кеше в памяти перед Redis, по умолчанию 10000; -1 отключает его
+	CacheSize int `json:"cache_size"`
+	// RedisRetrySec через сколько секунд повторить обращение к недоступному Redis, по умолчанию 30
+	RedisRetrySec int `json:"redis_retry_sec"`
+}
 
-	var ips []net.IP
-	if err := json.Unmarshal([]byte(val), &ips); err != nil {
-		return nil, err
+func (c DNSConfig) withDefaults() DNSConfig {
+	if c.MinTTL == 0 {
+		c.MinTTL = 30
+	}
+	if c.MaxTTL == 0 {
+		c.MaxTTL = 24 * 60 * 60
+	}
+	if c.MaxTTL < c.MinTTL {
+		c.MaxTTL = c.MinTTL
 	}
-	return ips, nil
+	if c.NegativeTTL == 0 {
+		c.NegativeTTL = 60
+	}
+	if c.TimeoutMs <= 0 {
+		c.TimeoutMs = 2000
+	}
+	if c.Strategy == "" {
+		c.Strategy = StrategyFastest
+	}
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = 3
+	}
+	if c.CooldownSec <= 0 {
+		c.CooldownSec = 30
+	}
+	if c.CacheSize == 0 {
+		c.CacheSize = 10000
+	}
+	if c.RedisRetrySec <= 0 {
+		c.RedisRetrySec = 30
+	}
+	return c
 }
 
-func (dc *DNSCache) Set(ctx context.Context, host string, ips []net.IP) error {
-	data, err := json.Marshal(ips)
-	if err != nil {
-		return err
+func (c DNSConfig) validate() error {
+	switch c.Strategy {
+	case "", StrategyFastest, StrategyRoundRobin, StrategyWeighted, StrategyRace:
+		return nil
 	}
-	return dc.client.Set(ctx, "dns:"+host, data, dc.ttl).Err()
+	return fmt.Errorf("unknown DNS strategy %q", c.Strategy)
 }
 
-// DNSResolver - кастомный DNS-резолвер с кешированием
+// DNSResolver - кастомный DNS-резолвер с кешированием.
+// Запрашивает A и AAAA напрямую у серверов, проходит CNAME и учитывает TTL записей
 type DNSResolver struct {
-	cache   *DNSCache
-	servers []string
+	cache    *DNSCache
+	health   []*upstreamHealth
+	selector *upstreamSelector
+	group    singleflight.Group
+	cfg      DNSConfig
+}
+
+// NewDNSResolver создает резолвер; servers - адреса в формате parseUpstream
+func NewDNSResolver(servers []string, cfg DNSConfig, dnscache *DNSCache) (*DNSResolver, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	upstreams := make([]dnsUpstream, 0, len(servers))
+	for _, server := range servers {
+		up, err := parseUpstream(server)
+		if err != nil {
+			return nil, err
+		}
+		upstreams = append(upstreams, up)
+	}
+	return newDNSResolver(upstreams, cfg, dnscache), nil
 }
 
-func NewDNSResolver(servers []string, dnscache DNSCache) *DNSResolver {
+func newDNSResolver(upstreams []dnsUpstream, cfg DNSConfig, dnscache *DNSCache) *DNSResolver {
+	cfg = cfg.withDefaults()
+	health := make([]*upstreamHealth, len(upstreams))
+	for i, up := range upstreams {
+		health[i] = &upstreamHealth{up: up}
+	}
 	return &DNSResolver{
-		cache:   &dnscache,
-		servers: servers,
+		cache:    dnscache,
+		health:   health,
+		selector: &upstreamSelector{strategy: cfg.Strategy},
+		cfg:      cfg,
 	}
 }
 
-func (r *DNSResolver) Resolve(ctx context.Context, host string) ([]net.IP, error) {
-	// Пытаемся получить из кеша
-	if cached, err := r.cache.Get(ctx, host); err != nil {
-		return nil, fmt.Errorf("cache error: %v", err)
-	} else if cached != nil {
-		//fmt.Printf("[CACHE HIT] %s\n", host)
-		return cached, nil
+// Stats возвращает накопленную статистику серверов в порядке из настроек
+func (r *DNSResolver) Stats() []UpstreamStats {
+	now := time.Now()
+	stats := make([]UpstreamStats, len(r.health))
+	for i, h := range r.health {
+		stats[i] = h.stats(now)
 	}
+	return stats
+}
 
-	//fmt.Printf("[CACHE MISS] %s\n", host)
+func (r *DNSResolver) Resolve(ctx context.Context, host string) (ips []net.IP, err error) {
+	ctx, span := tracer.Start(ctx, "dns.resolve", trace.WithAttributes(attribute.String("server.address", host)))
+	defer func() { tracing.End(span, err) }()
 
-	// Выполняем DNS-запрос
-	resolver := &net.Resolver{
-		PreferGo: true,
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			d := net.Dialer{Timeout: 10 * time.Second}
-			server := r.servers[rand.Intn(len(r.servers))]
-			return d.DialContext(ctx, "udp", server+":53")
-		},
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
 	}
 
-	ips, err := resolver.LookupIPAddr(ctx, host)
+	// Пытаемся получить из кеша. Испорченная запись не мешает резолвингу
+	cached, source, err := r.cache.get(ctx, host)
 	if err != nil {
-		return nil, err
+		logging.FromContext(ctx).Warn("DNS cache read failed", logging.KeyHost, host, logging.Err(err))
+	}
+	metrics.DNSCache.WithLabelValues(source).Inc()
+	span.SetAttributes(attribute.Bool("dns.cache_hit", cached != nil), attribute.String("dns.cache", source))
+	if cached != nil {
+		logging.FromContext(ctx).Debug("DNS cache hit", logging.KeyHost, host, "cache", source)
+		return cached.IPs, cached.Err(host)
 	}
 
-	var result []net.IP
-	for _, ip := range ips {
-		result = append(result, ip.IP)
+	// Одновременные промахи по одному хосту ждут один общий запрос
+	ch := r.group.DoChan(host, func() (interface{}, error) {
+		return r.resolveUncached(context.WithoutCancel(ctx), host)
+	})
+	select {
+	case res := <-ch:
+		span.SetAttributes(attribute.Bool("dns.shared", res.Shared))
+		if res.Err != nil {
+			return nil, res.Err
+		}
+		entry := res.Val.(*DNSEntry)
+		return entry.IPs, entry.Err(host)
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// resolveUncached опрашивает серверы и сохраняет ответ в кеш. Контекст не должен
+// отменяться вызывающим: результат нужен всем, кто ждет этот хост
+func (r *DNSResolver) resolveUncached(ctx context.Context, host string) (*DNSEntry, error) {
+	start := time.Now()
+	entry, err := r.lookup(ctx, host)
+	metrics.DNSDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		return nil, err
 	}
 
 	// Сохраняем в кеш
-	if err := r.cache.Set(ctx, host, result); err != nil {
-		fmt.Printf("Warning: failed to cache DNS result: %v\n", err)
+	if err := r.cache.Set(ctx, host, entry); err != nil {
+		logging.FromContext(ctx).Warn("Failed to cache DNS result", logging.KeyHost, host, logging.Err(err))
+	}
+	return entry, nil
+}
+
+// lookup параллельно запрашивает A и AAAA и собирает из ответов запись кеша.
+// Ошибка возвращается, только если ни один сервер не ответил
+func (r *DNSResolver) lookup(ctx context.Context, host string) (*DNSEntry, error) {
+	qtypes := []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA}
+	answers := make([]*dnsAnswer, len(qtypes))
+	errs := make([]error, len(qtypes))
+
+	var wg sync.WaitGroup
+	for i, qtype := range qtypes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			answers[i], errs[i] = r.lookupType(ctx, fqdn(host), qtype)
+		}()
 	}
+	wg.Wait()
 
-	return result, nil
+	entry := &DNSEntry{}
+	var ttl, negTTL uint32
+	var rcodes []dnsmessage.RCode
+	for i, ans := range answers {
+		if errs[i] != nil {
+			continue
+		}
+		rcodes = append(rcodes, ans.rcode)
+		if len(ans.ips) > 0 {
+			entry.IPs = append(entry.IPs, ans.ips...)
+			ttl = minTTL(ttl, ans.ttl)
+		}
+		negTTL = minTTL(negTTL, ans.negTTL)
+	}
+
+	switch {
+	case len(entry.IPs) > 0:
+		entry.TTL = r.clamp(ttl, r.cfg.MaxTTL)
+	case len(rcodes) == 0:
+		return nil, fmt.Errorf("lookup %s: %v", host, errors.Join(errs...))
+	default:
+		entry.Negative = negativeNoData
+		for _, rcode := range rcodes {
+			switch rcode {
+			case dnsmessage.RCodeNameError:
+				entry.Negative = negativeNXDomain
+			case dnsmessage.RCodeServerFailure, dnsmessage.RCodeRefused:
+				if entry.Negative != negativeNXDomain {
+					entry.Negative = negativeServFail
+				}
+			}
+		}
+		if negTTL == 0 || entry.Negative == negativeServFail {
+			negTTL = r.cfg.NegativeTTL
+		}
+		entry.TTL = r.clamp(negTTL, r.cfg.NegativeTTL)
+	}
+	entry.ExpiresAt = time.Now().Add(time.Duration(entry.TTL) * time.Second)
+	return entry, nil
+}
+
+func (r *DNSResolver) clamp(ttl, max uint32) uint32 {
+	if ttl > max {
+		ttl = max
+	}
+	if ttl < r.cfg.MinTTL {
+		ttl = r.cfg.MinTTL
+	}
+	return ttl
+}
+
+// lookupType запрашивает записи одного типа, дозапрашивая конец цепочки CNAME,
+// если сервер не вернул его адреса
+func (r *DNSResolver) lookupType(ctx context.Context, name string, qtype dnsmessage.Type) (*dnsAnswer, error) {
+	result := &dnsAnswer{}
+	for i := 0; i <= maxCNAMEChain; i++ {
+		ans, err := r.query(ctx, name, qtype)
+		if err != nil {
+			return nil, err
+		}
+		result.rcode = ans.rcode
+		result.ips = ans.ips
+		result.ttl = minTTL(result.ttl, ans.ttl)
+		result.negTTL = ans.negTTL
+		if ans.rcode != dnsmessage.RCodeSuccess || len(ans.ips) > 0 || ans.target == "" {
+			return result, nil
+		}
+		name = ans.target
+	}
+	return nil, fmt.Errorf("CNAME chain of %s is too long", name)
+}
+
+// query отправляет вопрос серверам в порядке стратегии. Следующий сервер пробуется
+// при сетевой ошибке и при SERVFAIL/REFUSED; в стратегии race первые два опрашиваются одновременно
+func (r *DNSResolver) query(ctx context.Context, name string, qtype dnsmessage.Type) (*dnsAnswer, error) {
+	if len(r.health) == 0 {
+		return nil, fmt.Errorf("no DNS servers configured")
+	}
+
+	timeout := time.Duration(r.cfg.TimeoutMs) * time.Millisecond
+	order := r.selector.order(r.health, timeout)
+
+	var failed *dnsAnswer
+	var errs []error
+	// accept запоминает неудачный ответ и сообщает, можно ли вернуть ans
+	accept := func(h *upstreamHealth, ans *dnsAnswer, err error) bool {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", h.up, err))
+			return false
+		}
+		if ans.rcode == dnsmessage.RCodeServerFailure || ans.rcode == dnsmessage.RCodeRefused {
+			failed = ans
+			return false
+		}
+		return true
+	}
+
+	if r.cfg.Strategy == StrategyRace && len(order) > 1 {
+		if ans, ok := r.race(ctx, order[:2], name, qtype, timeout, accept); ok {
+			return ans, nil
+		}
+		order = order[2:]
+	}
+	for _, h := range order {
+		if ctx.Err() != nil {
+			break
+		}
+		ans, err := r.exchange(ctx, h, name, qtype, timeout)
+		if accept(h, ans, err) {
+			return ans, nil
+		}
+	}
+
+	if failed != nil {
+		return failed, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// race опрашивает серверы одновременно и возвращает первый ответ, принятый accept.
+// Остальные запросы отменяются
+func (r *DNSResolver) race(ctx context.Context, servers []*upstreamHealth, name string, qtype dnsmessage.Type,
+	timeout time.Duration, accept func(*upstreamHealth, *dnsAnswer, error) bool) (*dnsAnswer, bool) {
+	type result struct {
+		h   *upstreamHealth
+		ans *dnsAnswer
+		err error
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan result, len(servers))
+	for _, h := range servers {
+		go func() {
+			ans, err := r.exchange(ctx, h, name, qtype, timeout)
+			results <- result{h, ans, err}
+		}()
+	}
+	for range servers {
+		res := <-results
+		if accept(res.h, res.ans, res.err) {
+			return res.ans, true
+		}
+	}
+	return nil, false
+}
+
+// exchange отправляет вопрос одному серверу и учитывает результат в его здоровье.
+// Запрос, отмененный вызывающим (например, проигравший в race), не учитывается
+func (r *DNSResolver) exchange(ctx context.Context, h *upstreamHealth, name string, qtype dnsmessage.Type, timeout time.Duration) (*dnsAnswer, error) {
+	start := time.Now()
+	ans, err := exchange(ctx, h.up, name, qtype, timeout)
+	if ctx.Err() == nil {
+		h.record(time.Since(start), err != nil, r.cfg.FailureThreshold, time.Duration(r.cfg.CooldownSec)*time.Second)
+	}
+	return ans, err
 }
 
 // ResolveWithPreference разрешает домен с предпочтением IPv4/IPv6
@@ -130,7 +426,7 @@ func (r *DNSResolver) ResolveWithPreference(ctx context.Context, host string, pr
 		return ipv6[0], nil
 	}
 
-	return nil, fmt.Errorf("no IP addresses found")
+	return nil, fmt.Errorf("lookup %s: %w", host, ErrNoAddresses)
 }
 
 // ipVersion возвращает версию IP-адреса
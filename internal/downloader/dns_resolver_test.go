@@ -9,6 +9,7 @@ import (
 	"github.com/alicebob/miniredis/v2"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
 )
 
 func TestDNSCache(t *testing.T) {
@@ -18,44 +19,54 @@ func TestDNSCache(t *testing.T) {
 	defer mr.Close()
 
 	ctx := context.Background()
-	ttl := 10 * time.Minute
-	cache := NewDNSCache(mr.Addr(), ttl)
+	cache := newRedisCache(mr)
 
 	t.Run("Get non-existent key", func(t *testing.T) {
-		ips, err := cache.Get(ctx, "nonexistent.com")
+		entry, err := cache.Get(ctx, "nonexistent.com")
 		assert.NoError(t, err)
-		assert.Nil(t, ips)
+		assert.Nil(t, entry)
 	})
 
 	t.Run("Set and Get", func(t *testing.T) {
 		testIPs := []net.IP{net.ParseIP("1.1.1.1"), net.ParseIP("2606:4700:4700::1111")}
 
-		err := cache.Set(ctx, "example.com", testIPs)
+		err := cache.Set(ctx, "example.com", &DNSEntry{IPs: testIPs, TTL: 300})
 		assert.NoError(t, err)
 
-		// Verify TTL was set
-		ttlRemaining := mr.TTL("dns:example.com")
-		assert.True(t, ttlRemaining > 0 && ttlRemaining <= ttl)
+		// TTL ключа равен TTL записи
+		assert.Equal(t, 300*time.Second, mr.TTL("dns:example.com"))
 
 		// Get the value back
-		ips, err := cache.Get(ctx, "example.com")
+		entry, err := cache.Get(ctx, "example.com")
 		assert.NoError(t, err)
-		assert.Equal(t, testIPs, ips)
+		assert.Equal(t, testIPs, entry.IPs)
+		assert.Equal(t, uint32(300), entry.TTL)
+		assert.NoError(t, entry.Err("example.com"))
+	})
+
+	t.Run("Negative entry", func(t *testing.T) {
+		require.NoError(t, cache.Set(ctx, "missing.com", &DNSEntry{Negative: negativeNXDomain, TTL: 60}))
+
+		entry, err := cache.Get(ctx, "missing.com")
+		require.NoError(t, err)
+		assert.ErrorIs(t, entry.Err("missing.com"), ErrNXDomain)
 	})
 
 	t.Run("Get with invalid data", func(t *testing.T) {
 		mr.Set("dns:bad.com", "invalid json")
-		ips, err := cache.Get(ctx, "bad.com")
+		entry, err := cache.Get(ctx, "bad.com")
 		assert.Error(t, err)
-		assert.Nil(t, ips)
+		assert.Nil(t, entry)
 	})
 
 	t.Run("Redis connection error", func(t *testing.T) {
 		// Close the Redis server to simulate connection error
 		mr.Close()
-		ips, err := cache.Get(ctx, "example.com")
-		assert.Error(t, err)
-		assert.Nil(t, ips)
+		entry, err := cache.Get(ctx, "example.com")
+		// Недоступный Redis переводит кеш в режим работы без него, а не ломает резолвинг
+		assert.NoError(t, err)
+		assert.Nil(t, entry)
+		assert.True(t, cache.Degraded())
 
 		// Reopen for other tests
 		mr.Start()
@@ -69,41 +80,144 @@ func TestDNSResolver(t *testing.T) {
 	defer mr.Close()
 
 	ctx := context.Background()
-	ttl := 10 * time.Minute
-	cache := NewDNSCache(mr.Addr(), ttl)
+	server := newTestDNSServer(t)
+	server.A("example.test", 300, "10.0.0.1")
+	server.AAAA("example.test", 600, "fd00::1")
+	server.CNAME("www.example.test", 100, "example.test")
+	server.CNAME("cdn.example.test", 120, "www.example.test")
+	server.A("v4only.test", 300, "10.0.0.2")
+	server.A("short.test", 5, "10.0.0.3")
+	server.A("long.test", 1000000, "10.0.0.4")
 
-	// Mock DNS servers (we'll use system resolver in tests)
-	servers := []string{"8.8.8.8", "1.1.1.1"}
-	resolver := NewDNSResolver(servers, *cache)
+	cfg := DNSConfig{MinTTL: 10, MaxTTL: 3600, NegativeTTL: 60, TimeoutMs: 500}
+	resolver := newTestResolver(t, []string{server.addr}, cfg, newRedisCache(mr))
 
-	t.Run("ResolveWithPreference - IPv4", func(t *testing.T) {
-		host := "google.com" // Assuming this has both IPv4 and IPv6
+	t.Run("A and AAAA", func(t *testing.T) {
+		ips, err := resolver.Resolve(ctx, "example.test")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"10.0.0.1", "fd00::1"}, ipStrings(ips))
+		assert.Equal(t, 300*time.Second, mr.TTL("dns:example.test"))
+	})
+
+	t.Run("CNAME chain", func(t *testing.T) {
+		ips, err := resolver.Resolve(ctx, "cdn.example.test")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"10.0.0.1", "fd00::1"}, ipStrings(ips))
+		// Минимальный TTL по всей цепочке
+		assert.Equal(t, 100*time.Second, mr.TTL("dns:cdn.example.test"))
+	})
+
+	t.Run("CNAME target without addresses is queried again", func(t *testing.T) {
+		server.mu.Lock()
+		server.noChase = true
+		server.mu.Unlock()
+		defer func() {
+			server.mu.Lock()
+			server.noChase = false
+			server.mu.Unlock()
+		}()
+
+		before := server.Queries()
+		ips, err := resolver.Resolve(ctx, "www.example.test")
+		require.NoError(t, err)
+		assert.ElementsMatch(t, []string{"10.0.0.1", "fd00::1"}, ipStrings(ips))
+		// A и AAAA, каждый по два запроса: псевдоним и цель
+		assert.Equal(t, int64(4), server.Queries()-before)
+	})
+
+	t.Run("TTL clamps", func(t *testing.T) {
+		_, err := resolver.Resolve(ctx, "short.test")
+		require.NoError(t, err)
+		assert.Equal(t, 10*time.Second, mr.TTL("dns:short.test"))
 
-		ip, err := resolver.ResolveWithPreference(ctx, host, false)
+		_, err = resolver.Resolve(ctx, "long.test")
+		require.NoError(t, err)
+		assert.Equal(t, time.Hour, mr.TTL("dns:long.test"))
+	})
+
+	t.Run("cache hit", func(t *testing.T) {
+		before := server.Queries()
+		ips, err := resolver.Resolve(ctx, "example.test")
+		require.NoError(t, err)
+		assert.Len(t, ips, 2)
+		assert.Equal(t, before, server.Queries())
+	})
+
+	t.Run("NXDOMAIN is cached with SOA TTL", func(t *testing.T) {
+		_, err := resolver.Resolve(ctx, "missing.test")
+		assert.ErrorIs(t, err, ErrNXDomain)
+		assert.Equal(t, 20*time.Second, mr.TTL("dns:missing.test"))
+
+		before := server.Queries()
+		_, err = resolver.Resolve(ctx, "missing.test")
+		assert.ErrorIs(t, err, ErrNXDomain)
+		assert.Equal(t, before, server.Queries())
+	})
+
+	t.Run("SERVFAIL is cached with negative TTL", func(t *testing.T) {
+		server.RCode("broken.test", dnsmessage.RCodeServerFailure)
+
+		_, err := resolver.Resolve(ctx, "broken.test")
+		assert.ErrorIs(t, err, ErrServFail)
+		assert.Equal(t, 60*time.Second, mr.TTL("dns:broken.test"))
+	})
+
+	t.Run("truncated answer is retried over TCP", func(t *testing.T) {
+		server.mu.Lock()
+		server.truncate = true
+		server.mu.Unlock()
+		defer func() {
+			server.mu.Lock()
+			server.truncate = false
+			server.mu.Unlock()
+		}()
+
+		server.A("tcp.test", 300, "10.0.0.5")
+		ips, err := resolver.Resolve(ctx, "tcp.test")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"10.0.0.5"}, ipStrings(ips))
+	})
+
+	t.Run("failover to next server", func(t *testing.T) {
+		dead := closedUDPAddr(t)
+		r := newTestResolver(t, []string{dead, server.addr}, cfg, newRedisCache(mr))
+
+		server.A("failover.test", 300, "10.0.0.6")
+		ips, err := r.Resolve(ctx, "failover.test")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"10.0.0.6"}, ipStrings(ips))
+	})
+
+	t.Run("unreachable servers are not cached", func(t *testing.T) {
+		r := newTestResolver(t, []string{closedUDPAddr(t)}, cfg, newRedisCache(mr))
+
+		_, err := r.Resolve(ctx, "unreachable.test")
+		assert.Error(t, err)
+		assert.False(t, mr.Exists("dns:unreachable.test"))
+	})
+
+	t.Run("IP literal", func(t *testing.T) {
+		ips, err := resolver.Resolve(ctx, "192.0.2.1")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"192.0.2.1"}, ipStrings(ips))
+	})
+
+	t.Run("ResolveWithPreference - IPv4", func(t *testing.T) {
+		ip, err := resolver.ResolveWithPreference(ctx, "example.test", false)
 		assert.NoError(t, err)
-		assert.NotNil(t, ip)
-		assert.NotNil(t, ip.To4(), "should return IPv4 address")
+		assert.Equal(t, "10.0.0.1", ip.String())
 	})
 
 	t.Run("ResolveWithPreference - IPv6", func(t *testing.T) {
-		host := "google.com" // Assuming this has IPv6
-
-		ip, err := resolver.ResolveWithPreference(ctx, host, true)
+		ip, err := resolver.ResolveWithPreference(ctx, "example.test", true)
 		assert.NoError(t, err)
-		assert.NotNil(t, ip)
-		if ip.To4() == nil {
-			// Only check for IPv6 if the host actually has IPv6
-			assert.Nil(t, ip.To4(), "should return IPv6 address")
-		}
+		assert.Equal(t, "fd00::1", ip.String())
 	})
 
 	t.Run("ResolveWithPreference - fallback", func(t *testing.T) {
-		// This test assumes example.com has at least one IP address
-		host := "example.com"
-
-		ip, err := resolver.ResolveWithPreference(ctx, host, true)
+		ip, err := resolver.ResolveWithPreference(ctx, "v4only.test", true)
 		assert.NoError(t, err)
-		assert.NotNil(t, ip)
+		assert.Equal(t, "10.0.0.2", ip.String())
 	})
 
 	t.Run("Resolve invalid host", func(t *testing.T) {
@@ -112,6 +226,35 @@ func TestDNSResolver(t *testing.T) {
 	})
 }
 
+func newTestResolver(t *testing.T, servers []string, cfg DNSConfig, cache *DNSCache) *DNSResolver {
+	t.Helper()
+	r, err := NewDNSResolver(servers, cfg, cache)
+	require.NoError(t, err)
+	return r
+}
+
+func ipStrings(ips []net.IP) []string {
+	out := make([]string, len(ips))
+	for i, ip := range ips {
+		out[i] = ip.String()
+	}
+	return out
+}
+
+// newRedisCache кеш только на Redis, чтобы тесты видели каждое обращение к серверам
+func newRedisCache(mr *miniredis.Miniredis) *DNSCache {
+	return NewDNSCache(mr.Addr(), DNSConfig{CacheSize: -1})
+}
+
+// closedUDPAddr возвращает адрес, на котором никто не слушает
+func closedUDPAddr(t *testing.T) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := pc.LocalAddr().String()
+	pc.Close()
+	return addr
+}
+
 func TestIpVersion(t *testing.T) {
 	tests := []struct {
 		ip      string
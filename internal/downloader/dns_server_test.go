@@ -0,0 +1,242 @@
+package downloader
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testDNSServer DNS-сервер для тестов, отвечающий по UDP и TCP на 127.0.0.1
+type testDNSServer struct {
+	addr    string
+	queries int64
+
+	mu sync.Mutex
+	// zone записи по имени (в нижнем регистре, с точкой на конце)
+	zone map[string][]dnsmessage.Resource
+	// rcodes принудительный код ответа для имени
+	rcodes map[string]dnsmessage.RCode
+	// noChase не добавлять в ответ записи цели CNAME
+	noChase bool
+	// truncate отвечать по UDP усеченным сообщением
+	truncate bool
+	soaTTL   uint32
+	// delay задержка перед каждым ответом
+	delay time.Duration
+}
+
+func newTestDNSServer(t *testing.T) *testDNSServer {
+	t.Helper()
+
+	// Порт TCP с тем же номером может быть занят: тогда берется другой порт UDP
+	var pc net.PacketConn
+	var ln net.Listener
+	var err error
+	for attempt := 0; attempt < 10; attempt++ {
+		pc, err = net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		if ln, err = net.Listen("tcp", pc.LocalAddr().String()); err == nil {
+			break
+		}
+		pc.Close()
+	}
+	require.NoError(t, err)
+
+	s := &testDNSServer{
+		addr:   pc.LocalAddr().String(),
+		zone:   make(map[string][]dnsmessage.Resource),
+		rcodes: make(map[string]dnsmessage.RCode),
+		soaTTL: 20,
+	}
+	t.Cleanup(func() {
+		pc.Close()
+		ln.Close()
+	})
+
+	go s.serveUDP(pc)
+	go s.serveTCP(ln)
+	return s
+}
+
+// ServeTLS принимает DNS-over-TLS с сертификатом cert и возвращает адрес
+func (s *testDNSServer) ServeTLS(t *testing.T, cert tls.Certificate) string {
+	t.Helper()
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go s.serveTCP(ln)
+	return ln.Addr().String()
+}
+
+// ServeHTTP отвечает на DoH-запросы POST в wire-формате
+func (s *testDNSServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dnsMessageType {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	msg, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	resp := s.respond(msg, false)
+	if resp == nil {
+		http.Error(w, "malformed query", http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", dnsMessageType)
+	w.Write(resp)
+}
+
+func (s *testDNSServer) Queries() int64 {
+	return atomic.LoadInt64(&s.queries)
+}
+
+func (s *testDNSServer) A(name string, ttl uint32, ip string) {
+	var a [4]byte
+	copy(a[:], net.ParseIP(ip).To4())
+	s.add(name, ttl, dnsmessage.TypeA, &dnsmessage.AResource{A: a})
+}
+
+func (s *testDNSServer) AAAA(name string, ttl uint32, ip string) {
+	var a [16]byte
+	copy(a[:], net.ParseIP(ip).To16())
+	s.add(name, ttl, dnsmessage.TypeAAAA, &dnsmessage.AAAAResource{AAAA: a})
+}
+
+func (s *testDNSServer) CNAME(name string, ttl uint32, target string) {
+	s.add(name, ttl, dnsmessage.TypeCNAME, &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName(fqdn(target))})
+}
+
+func (s *testDNSServer) RCode(name string, rcode dnsmessage.RCode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rcodes[fqdn(name)] = rcode
+}
+
+func (s *testDNSServer) add(name string, ttl uint32, typ dnsmessage.Type, body dnsmessage.ResourceBody) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name = fqdn(name)
+	s.zone[name] = append(s.zone[name], dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET, TTL: ttl},
+		Body:   body,
+	})
+}
+
+func (s *testDNSServer) serveUDP(pc net.PacketConn) {
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if resp := s.respond(buf[:n], true); resp != nil {
+			pc.WriteTo(resp, addr)
+		}
+	}
+}
+
+func (s *testDNSServer) serveTCP(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			defer conn.Close()
+			var length [2]byte
+			if _, err := io.ReadFull(conn, length[:]); err != nil {
+				return
+			}
+			msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+			if _, err := io.ReadFull(conn, msg); err != nil {
+				return
+			}
+			resp := s.respond(msg, false)
+			binary.BigEndian.PutUint16(length[:], uint16(len(resp)))
+			conn.Write(append(length[:], resp...))
+		}()
+	}
+}
+
+func (s *testDNSServer) respond(msg []byte, udp bool) []byte {
+	atomic.AddInt64(&s.queries, 1)
+	s.mu.Lock()
+	delay := s.delay
+	s.mu.Unlock()
+	time.Sleep(delay)
+
+	var p dnsmessage.Parser
+	h, err := p.Start(msg)
+	if err != nil {
+		return nil
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	resp := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: h.ID, Response: true, RecursionAvailable: true},
+		Questions: []dnsmessage.Question{q},
+	}
+	name := strings.ToLower(q.Name.String())
+
+	switch rcode, forced := s.rcodes[name]; {
+	case forced:
+		resp.RCode = rcode
+	case udp && s.truncate:
+		resp.Truncated = true
+	case s.zone[name] == nil:
+		resp.RCode = dnsmessage.RCodeNameError
+		resp.Authorities = []dnsmessage.Resource{{
+			Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName("test."), Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET, TTL: 3600},
+			Body: &dnsmessage.SOAResource{
+				NS: dnsmessage.MustNewName("ns.test."), MBox: dnsmessage.MustNewName("admin.test."),
+				Serial: 1, Refresh: 3600, Retry: 600, Expire: 86400, MinTTL: s.soaTTL,
+			},
+		}}
+	default:
+		for i := 0; i <= maxCNAMEChain; i++ {
+			var cname *dnsmessage.Resource
+			for _, rr := range s.zone[name] {
+				if rr.Header.Type == q.Type {
+					resp.Answers = append(resp.Answers, rr)
+				} else if rr.Header.Type == dnsmessage.TypeCNAME {
+					rr := rr
+					cname = &rr
+				}
+			}
+			if len(resp.Answers) > 0 && resp.Answers[len(resp.Answers)-1].Header.Type == q.Type || cname == nil {
+				break
+			}
+			resp.Answers = append(resp.Answers, *cname)
+			if s.noChase {
+				break
+			}
+			name = strings.ToLower(cname.Body.(*dnsmessage.CNAMEResource).CNAME.String())
+		}
+	}
+
+	out, err := resp.Pack()
+	if err != nil {
+		return nil
+	}
+	return out
+}
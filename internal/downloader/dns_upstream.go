@@ -0,0 +1,196 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsMessageType MIME-тип сообщения DNS в DoH (RFC 8484)
+const dnsMessageType = "application/dns-message"
+
+// dnsUpstream сервер DNS вместе с транспортом: принимает запрос и возвращает ответ в wire-формате
+type dnsUpstream interface {
+	exchange(ctx context.Context, query []byte) ([]byte, error)
+	String() string
+}
+
+// parseUpstream разбирает адрес сервера из dns_servers:
+//
+//	1.1.1.1, udp://1.1.1.1:53   - UDP с переходом на TCP при усеченном ответе
+//	tcp://1.1.1.1               - только TCP
+//	tls://dns.google:853        - DNS-over-TLS
+//	https://dns.google/dns-query - DNS-over-HTTPS
+func parseUpstream(spec string) (dnsUpstream, error) {
+	if !strings.Contains(spec, "://") {
+		return &udpUpstream{addr: hostPort(spec, "53")}, nil
+	}
+
+	u, err := url.Parse(spec)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DNS server %q: %v", spec, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid DNS server %q: no host", spec)
+	}
+
+	switch u.Scheme {
+	case "udp":
+		return &udpUpstream{addr: hostPort(u.Host, "53")}, nil
+	case "tcp":
+		return &tcpUpstream{addr: hostPort(u.Host, "53")}, nil
+	case "tls":
+		return &tcpUpstream{
+			addr: hostPort(u.Host, "853"),
+			tls:  &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12},
+		}, nil
+	case "https":
+		return &dohUpstream{url: u.String(), client: http.DefaultClient}, nil
+	default:
+		return nil, fmt.Errorf("unsupported DNS server scheme %q", u.Scheme)
+	}
+}
+
+// hostPort добавляет порт по умолчанию, если он не указан
+func hostPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+type udpUpstream struct {
+	addr string
+}
+
+func (u *udpUpstream) String() string { return "udp://" + u.addr }
+
+func (u *udpUpstream) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "udp", u.addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write(query); err != nil {
+		return nil, err
+	}
+
+	id := binary.BigEndian.Uint16(query)
+	buf := make([]byte, ednsUDPSize*2)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		// Чужие и поврежденные датаграммы пропускаем и ждем настоящий ответ
+		var p dnsmessage.Parser
+		h, err := p.Start(buf[:n])
+		if err != nil || h.ID != id {
+			continue
+		}
+		if h.Truncated {
+			tcp := &tcpUpstream{addr: u.addr}
+			return tcp.exchange(ctx, query)
+		}
+		return append([]byte(nil), buf[:n]...), nil
+	}
+}
+
+// tcpUpstream DNS поверх TCP или, если задан tls, поверх TLS (RFC 7858)
+type tcpUpstream struct {
+	addr string
+	tls  *tls.Config
+}
+
+func (u *tcpUpstream) String() string {
+	if u.tls != nil {
+		return "tls://" + u.addr
+	}
+	return "tcp://" + u.addr
+}
+
+func (u *tcpUpstream) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	var (
+		conn net.Conn
+		err  error
+	)
+	if u.tls != nil {
+		d := tls.Dialer{Config: u.tls}
+		conn, err = d.DialContext(ctx, "tcp", u.addr)
+	} else {
+		var d net.Dialer
+		conn, err = d.DialContext(ctx, "tcp", u.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// По TCP сообщение предваряется двухбайтовой длиной
+	framed := make([]byte, 2+len(query))
+	binary.BigEndian.PutUint16(framed, uint16(len(query)))
+	copy(framed[2:], query)
+	if _, err := conn.Write(framed); err != nil {
+		return nil, err
+	}
+
+	var length [2]byte
+	if _, err := io.ReadFull(conn, length[:]); err != nil {
+		return nil, err
+	}
+	msg := make([]byte, binary.BigEndian.Uint16(length[:]))
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// dohUpstream DNS-over-HTTPS: запрос отправляется POST в wire-формате (RFC 8484)
+type dohUpstream struct {
+	url    string
+	client *http.Client
+}
+
+func (u *dohUpstream) String() string { return u.url }
+
+func (u *dohUpstream) exchange(ctx context.Context, query []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.url, bytes.NewReader(query))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", dnsMessageType)
+	req.Header.Set("Accept", dnsMessageType)
+
+	resp, err := u.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DoH server returned status %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, dnsMessageType) {
+		return nil, fmt.Errorf("DoH server returned content type %q", ct)
+	}
+	// Сообщение DNS не длиннее 64 КБ
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+}
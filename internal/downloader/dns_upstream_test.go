@@ -0,0 +1,109 @@
+package downloader
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestParseUpstream(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"1.1.1.1", "udp://1.1.1.1:53"},
+		{"127.0.0.1:5353", "udp://127.0.0.1:5353"},
+		{"2606:4700:4700::1111", "udp://[2606:4700:4700::1111]:53"},
+		{"udp://8.8.8.8", "udp://8.8.8.8:53"},
+		{"tcp://8.8.8.8:5353", "tcp://8.8.8.8:5353"},
+		{"tls://dns.google", "tls://dns.google:853"},
+		{"https://dns.google/dns-query", "https://dns.google/dns-query"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			up, err := parseUpstream(tt.spec)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, up.String())
+		})
+	}
+
+	t.Run("tls server name", func(t *testing.T) {
+		up, err := parseUpstream("tls://dns.google:853")
+		require.NoError(t, err)
+		assert.Equal(t, "dns.google", up.(*tcpUpstream).tls.ServerName)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, spec := range []string{"quic://dns.adguard.com", "tls://", "https://%zz"} {
+			_, err := parseUpstream(spec)
+			assert.Error(t, err, spec)
+		}
+	})
+}
+
+func TestUpstreams(t *testing.T) {
+	ctx := context.Background()
+	server := newTestDNSServer(t)
+	server.A("example.test", 300, "10.0.0.1")
+
+	doh := httptest.NewTLSServer(server)
+	defer doh.Close()
+	pool := x509.NewCertPool()
+	pool.AddCert(doh.Certificate())
+	dotAddr := server.ServeTLS(t, doh.TLS.Certificates[0])
+
+	upstreams := []dnsUpstream{
+		&udpUpstream{addr: server.addr},
+		&tcpUpstream{addr: server.addr},
+		&tcpUpstream{addr: dotAddr, tls: &tls.Config{RootCAs: pool, ServerName: "example.com"}},
+		&dohUpstream{url: doh.URL + "/dns-query", client: doh.Client()},
+	}
+
+	for _, up := range upstreams {
+		t.Run(up.String(), func(t *testing.T) {
+			ans, err := exchange(ctx, up, "example.test.", dnsmessage.TypeA, time.Second)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"10.0.0.1"}, ipStrings(ans.ips))
+			assert.Equal(t, uint32(300), ans.ttl)
+		})
+	}
+
+	t.Run("udp falls back to tcp on truncation", func(t *testing.T) {
+		server.mu.Lock()
+		server.truncate = true
+		server.mu.Unlock()
+		defer func() {
+			server.mu.Lock()
+			server.truncate = false
+			server.mu.Unlock()
+		}()
+
+		ans, err := exchange(ctx, &udpUpstream{addr: server.addr}, "example.test.", dnsmessage.TypeA, time.Second)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"10.0.0.1"}, ipStrings(ans.ips))
+	})
+
+	t.Run("tls with untrusted certificate", func(t *testing.T) {
+		up := &tcpUpstream{addr: dotAddr, tls: &tls.Config{ServerName: "example.com"}}
+		_, err := exchange(ctx, up, "example.test.", dnsmessage.TypeA, time.Second)
+		assert.Error(t, err)
+	})
+
+	t.Run("doh error status", func(t *testing.T) {
+		failing := httptest.NewServer(http.NotFoundHandler())
+		defer failing.Close()
+
+		up := &dohUpstream{url: failing.URL, client: failing.Client()}
+		_, err := exchange(ctx, up, "example.test.", dnsmessage.TypeA, time.Second)
+		assert.ErrorContains(t, err, "status 404")
+	})
+}
@@ -0,0 +1,241 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"web_crawler/internal/document"
+	"web_crawler/internal/logging"
+	"web_crawler/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DocumentConfig загрузка документов PDF и Office без браузера
+type DocumentConfig struct {
+	Enabled bool `json:"enabled"`
+	// MaxBytes предельный размер файла, по умолчанию 20 МБ; max_body_bytes из limits тоже действует
+	MaxBytes int64 `json:"max_bytes"`
+	// TimeoutSec время на загрузку файла, по умолчанию 60 секунд; заменяет total_timeout_sec из limits
+	TimeoutSec int `json:"timeout_sec"`
+}
+
+// limits ограничения загрузки страниц хоста, измененные для файлов
+func (c DocumentConfig) limits(lim FetchLimits) FetchLimits {
+	maxBytes := c.MaxBytes
+	if maxBytes <= 0 {
+		maxBytes = 20 << 20
+	}
+	if lim.MaxBodyBytes == 0 || maxBytes < lim.MaxBodyBytes {
+		lim.MaxBodyBytes = maxBytes
+	}
+	lim.TotalTimeoutSec = c.TimeoutSec
+	if lim.TotalTimeoutSec <= 0 {
+		lim.TotalTimeoutSec = 60
+	}
+	return lim
+}
+
+// probeTimeoutSec время на запрос HEAD, по которому выбирается способ загрузки
+const probeTimeoutSec = 10
+
+// errNotDocument по содержимому ответа оказалось, что это страница, а не документ
+var errNotDocument = errors.New("response is not a document")
+
+// newRequest запрос net/http с заголовками идентификации и авторизацией сайта
+func (f *Fetcher) newRequest(ctx context.Context, method, url string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = f.Identity.Header()
+	f.Auth.Authorize(req)
+	return req, nil
+}
+
+// do выполняет запрос клиентом с cookie краулера под ограничениями lim и сообщает пулу прокси
+// результат. Общее время и размер ответа ограничивают и чтение тела
+func (f *Fetcher) do(rt *router, transport http.RoundTripper, req *http.Request, lim FetchLimits) (*http.Response, error) {
+	ctx, cancelTotal := context.WithTimeoutCause(req.Context(), lim.total(),
+		&LimitError{Limit: LimitTotalTimeout, Detail: fmt.Sprintf("not loaded in %v", lim.total())})
+	ctx, cancelTTFB := context.WithCancelCause(ctx)
+	cancel := func() {
+		cancelTTFB(nil)
+		cancelTotal()
+	}
+	var ttfb *time.Timer
+	if d := lim.ttfb(); d > 0 {
+		ttfb = time.AfterFunc(d, func() {
+			cancelTTFB(&LimitError{Limit: LimitTTFB, Detail: fmt.Sprintf("no response in %v", d)})
+		})
+	}
+
+	client := &http.Client{Transport: transport, CheckRedirect: lim.checkRedirect}
+	if f.Jar != nil {
+		client.Jar = f.Jar
+	}
+	host := req.URL.Hostname()
+	resp, err := client.Do(req.WithContext(ctx))
+	if ttfb != nil {
+		ttfb.Stop()
+	}
+	if err != nil {
+		if le := limitCause(ctx); le != nil {
+			err = le
+		}
+		cancel()
+		f.Proxies.ReportError(rt.route(host), err)
+		return nil, err
+	}
+	f.Proxies.ReportStatus(rt.route(host), resp.StatusCode)
+	resp.Body = &limitedBody{ReadCloser: resp.Body, ctx: ctx, cancel: cancel, lim: lim}
+	return resp, nil
+}
+
+// Fetch загружает URL. Если включена загрузка документов, тип ответа сначала узнается запросом
+// HEAD: PDF и файлы Office скачиваются напрямую, остальное открывается в Chrome
+func (f *Fetcher) Fetch(ctx context.Context, url string) (*Page, error) {
+	if !f.Documents.Enabled {
+		return f.FetchDynamicHTML(ctx, url)
+	}
+
+	start := time.Now()
+	host, err := GetHost(url)
+	if err != nil {
+		return nil, err
+	}
+	lim := f.Limits.For(host)
+	rt, err := newRouter(ctx, f.Resolver, f.Proxies, host)
+	if err != nil {
+		return nil, err
+	}
+	rt.connectTimeout = lim.connectTimeout()
+	transport := rt.transport()
+	defer transport.CloseIdleConnections()
+
+	contentType, err := f.probe(ctx, rt, transport, url, lim)
+	if err == nil {
+		err = lim.checkType(contentType)
+	}
+	if LimitOf(err) != "" {
+		// Сервер не уложился в ограничения уже на HEAD, браузер не запускается
+		observeFetch(host, 0, err, time.Since(start))
+		return nil, err
+	}
+	if err != nil {
+		// Часть серверов не отвечает на HEAD; тогда страницу загружает браузер
+		logging.FromContext(ctx).Debug("Content type probe failed", logging.Err(err))
+		return f.FetchDynamicHTML(ctx, url)
+	}
+	if !document.IsDocument(contentType) {
+		return f.FetchDynamicHTML(ctx, url)
+	}
+
+	page, err := f.fetchDocument(ctx, rt, transport, url, host, f.Documents.limits(lim))
+	if errors.Is(err, errNotDocument) {
+		return f.FetchDynamicHTML(ctx, url)
+	}
+	return page, err
+}
+
+// probe возвращает Content-Type ответа на HEAD
+func (f *Fetcher) probe(ctx context.Context, rt *router, transport http.RoundTripper, url string, lim FetchLimits) (string, error) {
+	ctx, span := tracer.Start(ctx, "fetch.probe")
+	defer span.End()
+
+	req, err := f.newRequest(ctx, http.MethodHead, url)
+	if err != nil {
+		return "", err
+	}
+	lim.TotalTimeoutSec = min(lim.TotalTimeoutSec, probeTimeoutSec)
+	resp, err := f.do(rt, transport, req, lim)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	return resp.Header.Get("Content-Type"), nil
+}
+
+// fetchDocument скачивает файл и извлекает из него текст и свойства.
+// Файл, который не удалось разобрать, сохраняется без текста
+func (f *Fetcher) fetchDocument(ctx context.Context, rt *router, transport http.RoundTripper, url, host string, lim FetchLimits) (page *Page, err error) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "fetch.document", trace.WithAttributes(
+		attribute.String("url.full", url),
+		attribute.String("server.address", host),
+	))
+	defer func() {
+		status := 0
+		if page != nil {
+			status = page.Status
+			span.SetAttributes(attribute.Int("http.response.status_code", status))
+		}
+		if errors.Is(err, errNotDocument) {
+			span.End()
+			return
+		}
+		observeFetch(host, status, err, time.Since(start))
+		tracing.End(span, err)
+	}()
+
+	req, err := f.newRequest(ctx, http.MethodGet, url)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.do(rt, transport, req, lim)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error: status code %d", resp.StatusCode)
+	}
+
+	if resp.ContentLength > lim.MaxBodyBytes {
+		return nil, lim.bodyError()
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read document: %w", err)
+	}
+
+	doc, err := document.Parse(resp.Header.Get("Content-Type"), data)
+	if strings.HasPrefix(doc.MIME, "text/") {
+		// application/octet-stream с HTML или текстом внутри
+		return nil, errNotDocument
+	}
+	if terr := lim.checkType(doc.MIME); terr != nil {
+		return nil, terr
+	}
+	switch {
+	case errors.Is(err, document.ErrUnsupported):
+		logging.FromContext(ctx).Debug("Document saved without text", "type", doc.MIME)
+	case err != nil:
+		logging.FromContext(ctx).Warn("Failed to parse document", "type", doc.MIME, logging.Err(err))
+	}
+	span.SetAttributes(attribute.String("document.type", doc.MIME), attribute.Int("document.size", doc.Size))
+
+	page = &Page{
+		Status:      resp.StatusCode,
+		ContentType: doc.MIME,
+		Document:    doc,
+		Duration:    time.Since(start),
+	}
+	if ip := rt.PeerIP(host); ip != nil {
+		page.IP = ip.String()
+	}
+	if pr := rt.route(host); pr != nil {
+		page.Proxy = pr.String()
+	}
+	logging.FromContext(ctx).Debug("Document fetched", "type", doc.MIME, "bytes", doc.Size, "duration", page.Duration)
+	return page, nil
+}
@@ -0,0 +1,59 @@
+package downloader
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"web_crawler/internal/document"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchDocument(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	server := newTestDNSServer(t)
+	server.A("site.test", 300, "127.0.0.1")
+	resolver := newTestResolver(t, []string{server.addr}, DNSConfig{TimeoutMs: 500}, newRedisCache(mr))
+
+	xls := "\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1" + strings.Repeat("x", 100)
+	var methods []string
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		w.Header().Set("Content-Type", document.MIMEXLS)
+		w.Write([]byte(xls))
+	}))
+	defer site.Close()
+	_, port, _ := net.SplitHostPort(site.Listener.Addr().String())
+	url := "http://site.test:" + port + "/prices.xls"
+
+	t.Run("downloaded without browser", func(t *testing.T) {
+		methods = nil
+		fetcher := &Fetcher{Resolver: resolver, Documents: DocumentConfig{Enabled: true}}
+
+		page, err := fetcher.Fetch(context.Background(), url)
+		require.NoError(t, err)
+		assert.Equal(t, []string{http.MethodHead, http.MethodGet}, methods)
+		assert.Equal(t, 200, page.Status)
+		assert.Equal(t, document.MIMEXLS, page.ContentType)
+		assert.Empty(t, page.HTML)
+		require.NotNil(t, page.Document)
+		assert.Equal(t, len(xls), page.Document.Size)
+		assert.Equal(t, "127.0.0.1", page.IP)
+	})
+
+	t.Run("size limit", func(t *testing.T) {
+		fetcher := &Fetcher{Resolver: resolver, Documents: DocumentConfig{Enabled: true, MaxBytes: 64}}
+
+		_, err := fetcher.Fetch(context.Background(), url)
+		assert.ErrorContains(t, err, "larger than 64 bytes")
+	})
+}
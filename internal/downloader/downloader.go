@@ -2,18 +2,79 @@ package downloader
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 
+	"web_crawler/internal/document"
+	"web_crawler/internal/egress"
+	"web_crawler/internal/identity"
+	"web_crawler/internal/logging"
+	"web_crawler/internal/metrics"
+	"web_crawler/internal/tracing"
+
 	"github.com/chromedp/chromedp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/net/html"
 )
 
-func FetchStaticHTML(url string) (*html.Node, error) {
-	resp, err := http.Get(url)
+// Fetcher загружает страницы; настройки общие для всех воркеров
+type Fetcher struct {
+	Resolver *DNSResolver
+	// Proxies прокси выхода; nil - загрузка напрямую
+	Proxies *egress.Pool
+	// Identity заголовки, с которыми краулер представляется сайтам
+	Identity identity.Config
+	// Jar cookie краулера; nil - cookie между страницами не передаются
+	Jar *identity.Jar
+	// Auth входы на сайты и авторизация запросов net/http; nil - без авторизации
+	Auth *Sessions
+	// Scripts действия над страницами перед сохранением HTML; nil - страница сохраняется как есть
+	Scripts *Scripts
+	// Capture снимки и PDF отрисованных страниц; nil - без снимков
+	Capture *Capture
+	// Documents загрузка PDF и файлов Office без браузера
+	Documents DocumentConfig
+	// Limits ограничения размера, типа, времени и переадресаций; nil - значения по умолчанию
+	Limits *Limits
+}
+
+func (f *Fetcher) FetchStaticHTML(ctx context.Context, url string) (*html.Node, error) {
+	page, err := f.FetchStaticPage(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+	return html.Parse(strings.NewReader(page.HTML))
+}
+
+// FetchStaticPage загружает страницу через net/http без браузера. HTML переводится в UTF-8,
+// исходная кодировка сохраняется в Charset
+func (f *Fetcher) FetchStaticPage(ctx context.Context, url string) (*Page, error) {
+	start := time.Now()
+	host, err := GetHost(url)
+	if err != nil {
+		return nil, err
+	}
+	lim := f.Limits.For(host)
+	rt, err := newRouter(ctx, f.Resolver, f.Proxies, host)
+	if err != nil {
+		return nil, err
+	}
+	rt.connectTimeout = lim.connectTimeout()
+	transport := rt.transport()
+	defer transport.CloseIdleConnections()
+
+	req, err := f.newRequest(ctx, http.MethodGet, url)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := f.do(rt, transport, req, lim)
 	if err != nil {
 		return nil, err
 	}
@@ -22,72 +83,311 @@ func FetchStaticHTML(url string) (*html.Node, error) {
 	if resp.StatusCode != 200 {
 		return nil, fmt.Errorf("error: status code %d", resp.StatusCode)
 	}
+	if err := lim.checkType(resp.Header.Get("Content-Type")); err != nil {
+		return nil, err
+	}
 
-	return html.Parse(resp.Body)
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	page := &Page{
+		Status:          resp.StatusCode,
+		ContentType:     resp.Header.Get("Content-Type"),
+		ContentLanguage: resp.Header.Get("Content-Language"),
+	}
+	if page.HTML, page.Charset, err = decodeHTML(data, page.ContentType); err != nil {
+		return nil, err
+	}
+	page.Duration = time.Since(start)
+	return page, nil
 }
 
 func GetHost(u string) (string, error) {
 	URL, err := url.Parse(u)
 	if err != nil {
-		return "", fmt.Errorf("Error parsing URL: %d", err)
+		return "", fmt.Errorf("Error parsing URL: %v", err)
 	}
 	return URL.Hostname(), nil
 }
 
-func FetchDynamicHTML(ctx context.Context, ur string, resolver *DNSResolver) (string, int, error) {
+// Page результат загрузки страницы
+type Page struct {
+	HTML        string
+	Status      int
+	ContentType string
+	// HTMLLang язык из атрибута lang у <html> или <meta http-equiv="Content-Language">,
+	// ContentLanguage - из заголовка ответа; это подсказки для определения языка текста
+	HTMLLang        string
+	ContentLanguage string
+	// Charset кодировка, в которой страница пришла от сервера; HTML уже переведен в UTF-8
+	Charset string
+	// Duration полное время загрузки, включая DNS и запуск браузера
+	Duration time.Duration
+	// IP адрес, с которого получена страница; пустой, если она загружена через прокси
+	IP string
+	// Proxy прокси выхода, через который загружена страница, без пароля
+	Proxy string
+	// Links ссылки, которых может не быть в итоговом HTML: с промежуточных состояний страницы,
+	// из запросов XHR и fetch, маршрутов SPA, window.open и onclick
+	Links []Link
+	// Screenshot снимок всей страницы типа ScreenshotType, если он настроен для URL
+	Screenshot     []byte
+	ScreenshotType string
+	// VisualHash перцептивный хеш снимка для поиска визуальных изменений между обходами
+	VisualHash string
+	// PDF страница, напечатанная в PDF
+	PDF []byte
+	// Document текст и свойства файла PDF или Office; у такой страницы нет HTML
+	Document *document.Document
+}
+
+var tracer = tracing.Tracer("downloader")
 
-	var htmlPage string
-	var statusCode int
+// htmlLangJS язык, объявленный страницей
+const htmlLangJS = `document.documentElement.lang ||
+	(document.querySelector('meta[http-equiv="content-language" i]') || {}).content || ''`
+
+func (f *Fetcher) FetchDynamicHTML(ctx context.Context, ur string) (page *Page, err error) {
+	start := time.Now()
+	page = &Page{}
 
 	host, err := GetHost(ur)
 	if err != nil {
-		fmt.Printf("Getting host from url falied: %v\n", err)
-		return "", 0, err
+		return nil, err
+	}
+
+	ctx, span := tracer.Start(ctx, "fetch", trace.WithAttributes(
+		attribute.String("url.full", ur),
+		attribute.String("server.address", host),
+	))
+	defer func() {
+		// Ошибка этапа после истечения общего времени - это его исход, а не сбой браузера
+		if err != nil && LimitOf(err) == "" {
+			if le := limitCause(ctx); le != nil {
+				err = le
+			}
+		}
+		status := 0
+		if page != nil {
+			status = page.Status
+			span.SetAttributes(attribute.Int("http.response.status_code", status))
+		}
+		observeFetch(host, status, err, time.Since(start))
+		tracing.End(span, err)
+	}()
+
+	lim := f.Limits.For(host)
+	rt, err := newRouter(ctx, f.Resolver, f.Proxies, host)
+	if err != nil {
+		return nil, err
 	}
+	rt.connectTimeout = lim.connectTimeout()
+	egressProxy := rt.route(host)
 
-	// 2. Разрешаем DNS
-	ips, err := resolver.ResolveWithPreference(ctx, host, false)
+	// 2. Разрешаем DNS заранее, чтобы не запускать браузер для несуществующего хоста.
+	// Через прокси выхода имя разрешает сам прокси
+	if egressProxy == nil {
+		if _, err = f.Resolver.Resolve(ctx, host); err != nil {
+			return nil, fmt.Errorf("DNS resolution failed: %v", err)
+		}
+	}
+
+	// Все запросы Chrome, включая ресурсы с других хостов, идут через локальный прокси:
+	// он разрешает имена нашим резолвером и передает соединения прокси выхода вместе с авторизацией
+	proxy, err := startProxy(rt)
 	if err != nil {
-		fmt.Printf("DNS resolution failed: %v\n", err)
-		return "", 0, err
+		return nil, err
 	}
+	defer proxy.Close()
 
-	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	timeout := lim.total()
+	recipe := f.Auth.Recipe(host)
+	if recipe != nil {
+		// Запас на вход и повторную загрузку страницы после него
+		timeout += recipe.timeout() + 20*time.Second
+	}
+	script := f.Scripts.Match(ur)
+	if script != nil {
+		timeout += script.timeout()
+	}
+	capture := f.Capture.Match(ur)
+	if capture {
+		timeout += f.Capture.timeout()
+	}
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout,
+		&LimitError{Limit: LimitTotalTimeout, Detail: fmt.Sprintf("not loaded in %v", timeout)})
 	defer cancel()
 
-	// Настраиваем ChromeDP с кастомным DNS
 	opts := append(chromedp.DefaultExecAllocatorOptions[:],
-		chromedp.Flag("host-resolver-rules", fmt.Sprintf("MAP %s %s", host, ips)),
+		chromedp.ProxyServer(proxy.URL()),
+		chromedp.UserAgent(f.Identity.UserAgentString()),
 	)
 
+	metrics.ActiveBrowsers.Inc()
+	defer metrics.ActiveBrowsers.Dec()
+
 	allocCtx, cancel := chromedp.NewExecAllocator(ctx, opts...)
 	defer cancel()
 
 	taskCtx, cancel := chromedp.NewContext(allocCtx)
 	defer cancel()
 
-	err = chromedp.Run(taskCtx,
-		chromedp.Navigate(ur),
-		chromedp.Sleep(3*time.Second),
-		chromedp.OuterHTML("html", &htmlPage),
-		chromedp.Evaluate(`
-			window.performance.getEntries()
-				.filter(entry => entry.entryType === 'navigation')
-				.map(entry => entry.responseStatus)[0]
-		`, &statusCode),
-	)
+	// Этапы выполняются по отдельности, чтобы у каждого был свой спан.
+	// Run без действий только запускает браузер
+	if err = runStage(taskCtx, "chrome.start"); err != nil {
+		return nil, fmt.Errorf("Error starting chrome: %v", err)
+	}
+	// Вход выполняется до настройки вкладки, чтобы она получила cookie новой сессии из jar
+	session, err := f.Auth.Ensure(ctx, host, f.login(taskCtx, host))
 	if err != nil {
-		return "", 0, fmt.Errorf("Error running chromedp: %d", err)
+		return nil, err
+	}
+	if err = runStage(taskCtx, "chrome.setup", f.chromeSetup(host)); err != nil {
+		return nil, fmt.Errorf("Error setting up chrome: %v", err)
+	}
+	if capture {
+		if err = runStage(taskCtx, "chrome.emulate", f.Capture.emulate()); err != nil {
+			return nil, fmt.Errorf("Error setting up chrome: %v", err)
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		// Запросы XHR и fetch попадают в ссылки, пока загружается эта попытка
+		links := &linkSet{}
+		listenCtx, stopListening := context.WithCancel(taskCtx)
+		defer stopListening()
+		listenRequests(listenCtx, links)
+
+		// Переход прерывается, как только ответ нарушает ограничения
+		nav := watchNavigation(taskCtx, lim)
+		err = runStage(nav.ctx, "chrome.navigate", chromedp.Navigate(ur))
+		if err == nil {
+			err = runStage(nav.ctx, "chrome.wait", chromedp.Sleep(3*time.Second))
+		}
+		hit := limitCause(nav.ctx)
+		nav.stop()
+		page.ContentLanguage = nav.contentLanguage()
+		if hit == nil {
+			hit = rt.LimitHit(host)
+		}
+		if hit != nil {
+			return nil, hit
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error running chromedp: %v", err)
+		}
+		if script != nil {
+			if err = runStage(taskCtx, "chrome.actions", script.run(links)); err != nil {
+				return nil, fmt.Errorf("Error running page actions: %v", err)
+			}
+		}
+		if lim.MaxDOMNodes > 0 {
+			var nodes int
+			if err = chromedp.Run(taskCtx, chromedp.Evaluate(`document.getElementsByTagName('*').length`, &nodes)); err != nil {
+				return nil, fmt.Errorf("Error running chromedp: %v", err)
+			}
+			if nodes > lim.MaxDOMNodes {
+				return nil, &LimitError{Limit: LimitDOMSize, Detail: fmt.Sprintf("%d elements, at most %d allowed", nodes, lim.MaxDOMNodes)}
+			}
+		}
+		err = runStage(taskCtx, "chrome.extract",
+			chromedp.OuterHTML("html", &page.HTML),
+			chromedp.Evaluate(`
+				window.performance.getEntries()
+					.filter(entry => entry.entryType === 'navigation')
+					.map(entry => entry.responseStatus)[0]
+			`, &page.Status),
+			chromedp.Evaluate(`document.contentType`, &page.ContentType),
+			chromedp.Evaluate(`document.characterSet`, &page.Charset),
+			chromedp.Evaluate(htmlLangJS, &page.HTMLLang),
+			collectLinks(links, false),
+			f.saveChromeCookies(),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("Error running chromedp: %v", err)
+		}
+		stopListening()
+		page.Charset = charsetName(page.Charset)
+		links.add(OriginOnclick, ExtractScriptLinks(page.HTML, ur)...)
+		page.Links = links.list()
+
+		if recipe == nil || recipe.LoggedOutSelector == "" || attempt > 0 {
+			break
+		}
+		var loggedOut bool
+		if err = chromedp.Run(taskCtx, chromedp.Evaluate(selectorExists(recipe.LoggedOutSelector), &loggedOut)); err != nil {
+			return nil, fmt.Errorf("Error running chromedp: %v", err)
+		}
+		if !loggedOut {
+			break
+		}
+
+		// Сессия закончилась: один воркер входит заново, остальные получают его cookie
+		logging.FromContext(ctx).Info("Session expired, logging in again")
+		f.Auth.Invalidate(host, session)
+		if session, err = f.Auth.Ensure(ctx, host, f.login(taskCtx, host)); err != nil {
+			return nil, err
+		}
+		if err = runStage(taskCtx, "chrome.setup", f.chromeSetup(host)); err != nil {
+			return nil, fmt.Errorf("Error setting up chrome: %v", err)
+		}
+	}
+
+	if capture {
+		// Без снимков страница все равно сохраняется
+		if err := runStage(taskCtx, "chrome.capture", f.Capture.run(page)); err != nil {
+			logging.FromContext(ctx).Warn("Failed to capture page", logging.Err(err))
+		}
 	}
 
 	//_ = chromedp.Cancel(taskCtx)
-	return htmlPage, statusCode, nil
+	page.Duration = time.Since(start)
+	if ip := proxy.PeerIP(host); ip != nil {
+		page.IP = ip.String()
+		span.SetAttributes(attribute.String("network.peer.address", page.IP))
+	}
+	if egressProxy != nil {
+		page.Proxy = egressProxy.String()
+		span.SetAttributes(attribute.String("proxy", page.Proxy))
+		f.Proxies.ReportStatus(egressProxy, page.Status)
+	}
+	logging.FromContext(ctx).Debug("Page fetched", "ip", page.IP, "proxy", page.Proxy, "status", page.Status, "duration", page.Duration)
+	return page, nil
+}
+
+// login выполняет сценарий входа во вкладке загрузки и сохраняет cookie сессии в jar
+func (f *Fetcher) login(ctx context.Context, host string) func(*LoginRecipe) error {
+	return func(recipe *LoginRecipe) error {
+		actions, err := recipe.actions()
+		if err != nil {
+			return err
+		}
+		ctx, cancel := context.WithTimeout(ctx, recipe.timeout())
+		defer cancel()
+
+		actions = append([]chromedp.Action{f.chromeSetup(host)}, actions...)
+		return runStage(ctx, "chrome.login", append(actions, f.saveChromeCookies())...)
+	}
+}
+
+// selectorExists выражение JS, истинное, если на странице есть элемент selector
+func selectorExists(selector string) string {
+	quoted, _ := json.Marshal(selector)
+	return fmt.Sprintf("document.querySelector(%s) !== null", quoted)
+}
+
+// runStage выполняет действия chromedp в отдельном спане name
+func runStage(ctx context.Context, name string, actions ...chromedp.Action) error {
+	ctx, span := tracer.Start(ctx, name)
+	err := chromedp.Run(ctx, actions...)
+	tracing.End(span, err)
+	return err
 }
 
 func ExtractLinks(htmlPage string, baseURL string) []string {
 	n, err := html.Parse(strings.NewReader(htmlPage))
 	if err != nil {
-		fmt.Printf("Error parsing html document: %v\n", err)
+		slog.Warn("Error parsing html document", logging.KeyURL, baseURL, logging.Err(err))
 		return nil
 	}
 
@@ -120,3 +420,56 @@ func ExtractLinks(htmlPage string, baseURL string) []string {
 	f(n)
 	return links
 }
+
+// MergeLinks объединяет списки ссылок без повторов, сохраняя порядок
+func MergeLinks(lists ...[]string) []string {
+	var out []string
+	seen := make(map[string]bool)
+	for _, links := range lists {
+		for _, link := range links {
+			if !seen[link] {
+				seen[link] = true
+				out = append(out, link)
+			}
+		}
+	}
+	return out
+}
+
+// skipTextTags теги, содержимое которых не является текстом страницы
+var skipTextTags = map[string]bool{"script": true, "style": true, "noscript": true, "template": true, "svg": true}
+
+// ExtractText возвращает заголовок страницы и ее видимый текст с нормализованными пробелами
+func ExtractText(htmlPage string) (string, string) {
+	n, err := html.Parse(strings.NewReader(htmlPage))
+	if err != nil {
+		slog.Warn("Error parsing html document", logging.Err(err))
+		return "", ""
+	}
+
+	var title string
+	var sb strings.Builder
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if skipTextTags[n.Data] {
+				return
+			}
+			if n.Data == "title" {
+				if title == "" && n.FirstChild != nil {
+					title = strings.Join(strings.Fields(n.FirstChild.Data), " ")
+				}
+				return
+			}
+		}
+		if n.Type == html.TextNode {
+			sb.WriteString(n.Data)
+			sb.WriteString(" ")
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(n)
+	return title, strings.Join(strings.Fields(sb.String()), " ")
+}
@@ -0,0 +1,27 @@
+package downloader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractText(t *testing.T) {
+	page := `<html><head><title>
+		Books  to Scrape </title><style>body { color: red }</style></head>
+		<body><h1>All products</h1>
+		<script>var x = "hidden";</script>
+		<p>A  Light in
+		the Attic</p><noscript>enable js</noscript></body></html>`
+
+	title, text := ExtractText(page)
+	assert.Equal(t, "Books to Scrape", title)
+	assert.Equal(t, "All products A Light in the Attic", text)
+}
+
+func TestExtractLinks(t *testing.T) {
+	page := `<a href="https://other.org/x">abs</a><a href="//cdn.net/y">proto</a><a href="/z">rel</a>`
+
+	links := ExtractLinks(page, "http://example.com")
+	assert.Equal(t, []string{"https://other.org/x", "http://cdn.net/y", "http://example.com/z"}, links)
+}
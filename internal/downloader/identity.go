@@ -0,0 +1,117 @@
+package downloader
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"web_crawler/internal/identity"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// chromeSetup передает Chrome заголовки и cookie краулера до перехода на страницу и
+// перехватывает переходы из скриптов. User-Agent задается флагом запуска, чтобы его видел и navigator.userAgent
+func (f *Fetcher) chromeSetup(host string) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		if err := network.Enable().Do(ctx); err != nil {
+			return err
+		}
+		if _, err := page.AddScriptToEvaluateOnNewDocument(hookLinksJS).Do(ctx); err != nil {
+			return err
+		}
+		if headers := chromeHeaders(f.Identity.Header()); len(headers) > 0 {
+			if err := network.SetExtraHTTPHeaders(headers).Do(ctx); err != nil {
+				return err
+			}
+		}
+		if f.Jar == nil {
+			return nil
+		}
+		if cookies := chromeCookies(f.Jar.Site(host)); len(cookies) > 0 {
+			return network.SetCookies(cookies).Do(ctx)
+		}
+		return nil
+	})
+}
+
+// saveChromeCookies сохраняет в jar cookie, которые браузер получил при загрузке страницы
+func (f *Fetcher) saveChromeCookies() chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		if f.Jar == nil {
+			return nil
+		}
+		cookies, err := network.GetCookies().Do(ctx)
+		if err != nil {
+			return err
+		}
+		f.Jar.Load(fromChromeCookies(cookies))
+		return nil
+	})
+}
+
+func chromeHeaders(h http.Header) network.Headers {
+	headers := make(network.Headers)
+	for k, v := range h {
+		if k == "User-Agent" {
+			continue
+		}
+		headers[k] = strings.Join(v, ", ")
+	}
+	return headers
+}
+
+// chromeCookies переводит cookie jar в параметры Network.setCookies. Cookie без поддоменов
+// задается через URL: так Chrome тоже считает ее host-only
+func chromeCookies(cookies []identity.Cookie) []*network.CookieParam {
+	params := make([]*network.CookieParam, 0, len(cookies))
+	for _, c := range cookies {
+		p := &network.CookieParam{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HTTPOnly: c.HttpOnly,
+		}
+		if c.HostOnly {
+			scheme := "http://"
+			if c.Secure {
+				scheme = "https://"
+			}
+			p.URL = scheme + c.Domain + c.Path
+		} else {
+			p.Domain = "." + c.Domain
+		}
+		if !c.Expires.IsZero() {
+			expires := cdp.TimeSinceEpoch(c.Expires)
+			p.Expires = &expires
+		}
+		params = append(params, p)
+	}
+	return params
+}
+
+// fromChromeCookies переводит cookie браузера в формат jar; точка в начале домена означает поддомены
+func fromChromeCookies(cookies []*network.Cookie) []identity.Cookie {
+	out := make([]identity.Cookie, 0, len(cookies))
+	for _, c := range cookies {
+		ic := identity.Cookie{
+			Domain:   strings.TrimPrefix(c.Domain, "."),
+			Path:     c.Path,
+			Name:     c.Name,
+			Value:    c.Value,
+			Secure:   c.Secure,
+			HttpOnly: c.HTTPOnly,
+			HostOnly: !strings.HasPrefix(c.Domain, "."),
+		}
+		if !c.Session {
+			ic.Expires = time.Unix(int64(c.Expires), 0)
+		}
+		out = append(out, ic)
+	}
+	return out
+}
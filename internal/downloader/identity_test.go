@@ -0,0 +1,90 @@
+package downloader
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"web_crawler/internal/identity"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/chromedp/cdproto/network"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchStaticIdentity(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	server := newTestDNSServer(t)
+	server.A("site.test", 300, "127.0.0.1")
+	resolver := newTestResolver(t, []string{server.addr}, DNSConfig{TimeoutMs: 500}, newRedisCache(mr))
+
+	var got http.Header
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		http.SetCookie(w, &http.Cookie{Name: "visited", Value: "yes", Path: "/"})
+	}))
+	defer site.Close()
+	_, port, _ := net.SplitHostPort(site.Listener.Addr().String())
+
+	jar := identity.NewJar()
+	jar.Load([]identity.Cookie{{Domain: "site.test", Path: "/", Name: "session", Value: "abc"}})
+	fetcher := &Fetcher{
+		Resolver: resolver,
+		Identity: identity.Config{
+			UserAgent:      "TestBot/1.0",
+			From:           "bot@example.com",
+			ContactURL:     "https://example.com/bot",
+			AcceptLanguage: "ru",
+			Headers:        map[string]string{"X-Purpose": "research"},
+		},
+		Jar: jar,
+	}
+
+	_, err = fetcher.FetchStaticHTML(context.Background(), "http://site.test:"+port+"/")
+	require.NoError(t, err)
+
+	assert.Equal(t, "TestBot/1.0 (+https://example.com/bot)", got.Get("User-Agent"))
+	assert.Equal(t, "bot@example.com", got.Get("From"))
+	assert.Equal(t, "ru", got.Get("Accept-Language"))
+	assert.Equal(t, "research", got.Get("X-Purpose"))
+	assert.Equal(t, "session=abc", got.Get("Cookie"))
+
+	var names []string
+	for _, c := range jar.Site("site.test") {
+		names = append(names, c.Name)
+	}
+	assert.Equal(t, []string{"session", "visited"}, names)
+}
+
+func TestChromeCookies(t *testing.T) {
+	expires := time.Unix(1893456000, 0)
+	cookies := []identity.Cookie{
+		{Domain: "example.com", Path: "/", Name: "sid", Value: "abc", Expires: expires, Secure: true, HttpOnly: true},
+		{Domain: "shop.example.com", Path: "/cart", Name: "cart", Value: "1", HostOnly: true},
+	}
+
+	params := chromeCookies(cookies)
+	require.Len(t, params, 2)
+	assert.Equal(t, ".example.com", params[0].Domain)
+	assert.Empty(t, params[0].URL)
+	assert.Equal(t, expires, params[0].Expires.Time())
+	assert.Equal(t, "http://shop.example.com/cart", params[1].URL)
+	assert.Empty(t, params[1].Domain)
+	assert.Nil(t, params[1].Expires)
+
+	back := fromChromeCookies([]*network.Cookie{
+		{Domain: ".example.com", Path: "/", Name: "sid", Value: "abc", Expires: float64(expires.Unix()), Secure: true, HTTPOnly: true},
+		{Domain: "shop.example.com", Path: "/cart", Name: "cart", Value: "1", Expires: -1, Session: true},
+	})
+	assert.Equal(t, cookies, back)
+
+	headers := chromeHeaders(identity.Config{AcceptLanguage: "ru"}.Header())
+	assert.Equal(t, network.Headers{"Accept-Language": "ru"}, headers)
+}
@@ -0,0 +1,371 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"web_crawler/internal/metrics"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// Ограничения загрузки. Имя ограничения записывается как исход загрузки, которую оно прервало
+const (
+	LimitBodySize       = "body_size"
+	LimitDOMSize        = "dom_size"
+	LimitContentType    = "content_type"
+	LimitConnectTimeout = "connect_timeout"
+	LimitTTFB           = "ttfb_timeout"
+	LimitTotalTimeout   = "total_timeout"
+	LimitRedirects      = "redirects"
+)
+
+// LimitError загрузка прервана ограничением Limit
+type LimitError struct {
+	Limit  string
+	Detail string
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s limit exceeded: %s", e.Limit, e.Detail)
+}
+
+// LimitOf имя ограничения, которым прервана загрузка, или пустая строка
+func LimitOf(err error) string {
+	var le *LimitError
+	if errors.As(err, &le) {
+		return le.Limit
+	}
+	return ""
+}
+
+// limitCause ограничение, из-за которого отменен ctx
+func limitCause(ctx context.Context) *LimitError {
+	var le *LimitError
+	if errors.As(context.Cause(ctx), &le) {
+		return le
+	}
+	return nil
+}
+
+// FetchLimits ограничения загрузки одной страницы; 0 - без ограничения
+type FetchLimits struct {
+	// MaxBodyBytes размер ответа сервера на запрос страницы
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// MaxDOMNodes число элементов отрисованной страницы
+	MaxDOMNodes int `json:"max_dom_nodes"`
+	// AllowedTypes допустимые типы содержимого, например "text/html" или "text/*"; пустой список - любые
+	AllowedTypes []string `json:"allowed_types"`
+	// ConnectTimeoutMs время на соединение с сервером или прокси выхода
+	ConnectTimeoutMs int `json:"connect_timeout_ms"`
+	// TTFBTimeoutMs время от запроса до заголовков ответа
+	TTFBTimeoutMs int `json:"ttfb_timeout_ms"`
+	// TotalTimeoutSec время загрузки без входа, действий и снимков, по умолчанию 20 секунд
+	TotalTimeoutSec int `json:"total_timeout_sec"`
+	// MaxRedirects число переадресаций, по умолчанию 10; -1 запрещает переадресации
+	MaxRedirects int `json:"max_redirects"`
+}
+
+// LimitsConfig общие ограничения и ограничения доменов вместе с поддоменами.
+// Ненулевые поля домена заменяют общие
+type LimitsConfig struct {
+	FetchLimits
+	Domains map[string]FetchLimits `json:"domains"`
+}
+
+// Limits ограничения загрузки по хостам
+type Limits struct {
+	base    FetchLimits
+	domains map[string]FetchLimits
+}
+
+// NewLimits проверяет ограничения; без настроек действуют значения по умолчанию
+func NewLimits(cfg LimitsConfig) (*Limits, error) {
+	if err := cfg.FetchLimits.validate(); err != nil {
+		return nil, fmt.Errorf("invalid limits: %v", err)
+	}
+	l := &Limits{base: cfg.FetchLimits, domains: make(map[string]FetchLimits, len(cfg.Domains))}
+	for domain, lim := range cfg.Domains {
+		if err := lim.validate(); err != nil {
+			return nil, fmt.Errorf("invalid limits for %s: %v", domain, err)
+		}
+		l.domains[strings.ToLower(strings.TrimSuffix(domain, "."))] = lim
+	}
+	return l, nil
+}
+
+func (l FetchLimits) validate() error {
+	if l.MaxBodyBytes < 0 || l.MaxDOMNodes < 0 || l.ConnectTimeoutMs < 0 || l.TTFBTimeoutMs < 0 || l.TotalTimeoutSec < 0 {
+		return errors.New("sizes and timeouts must not be negative")
+	}
+	if l.MaxRedirects < -1 {
+		return fmt.Errorf("invalid max_redirects %d", l.MaxRedirects)
+	}
+	for _, t := range l.AllowedTypes {
+		if major, minor, ok := strings.Cut(t, "/"); !ok || major == "" || minor == "" {
+			return fmt.Errorf("invalid content type %q", t)
+		}
+	}
+	return nil
+}
+
+// For ограничения для хоста: берутся настройки самого хоста или ближайшего родительского домена
+func (l *Limits) For(host string) FetchLimits {
+	if l == nil {
+		return FetchLimits{}.withDefaults()
+	}
+	lim := l.base
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for domain := host; domain != ""; {
+		if d, ok := l.domains[domain]; ok {
+			lim = lim.merge(d)
+			break
+		}
+		i := strings.IndexByte(domain, '.')
+		if i < 0 {
+			break
+		}
+		domain = domain[i+1:]
+	}
+	return lim.withDefaults()
+}
+
+// merge заменяет поля l ненулевыми полями o
+func (l FetchLimits) merge(o FetchLimits) FetchLimits {
+	if o.MaxBodyBytes != 0 {
+		l.MaxBodyBytes = o.MaxBodyBytes
+	}
+	if o.MaxDOMNodes != 0 {
+		l.MaxDOMNodes = o.MaxDOMNodes
+	}
+	if o.AllowedTypes != nil {
+		l.AllowedTypes = o.AllowedTypes
+	}
+	if o.ConnectTimeoutMs != 0 {
+		l.ConnectTimeoutMs = o.ConnectTimeoutMs
+	}
+	if o.TTFBTimeoutMs != 0 {
+		l.TTFBTimeoutMs = o.TTFBTimeoutMs
+	}
+	if o.TotalTimeoutSec != 0 {
+		l.TotalTimeoutSec = o.TotalTimeoutSec
+	}
+	if o.MaxRedirects != 0 {
+		l.MaxRedirects = o.MaxRedirects
+	}
+	return l
+}
+
+func (l FetchLimits) withDefaults() FetchLimits {
+	if l.TotalTimeoutSec == 0 {
+		l.TotalTimeoutSec = 20
+	}
+	if l.MaxRedirects == 0 {
+		l.MaxRedirects = 10
+	}
+	return l
+}
+
+func (l FetchLimits) total() time.Duration {
+	return time.Duration(l.TotalTimeoutSec) * time.Second
+}
+
+func (l FetchLimits) connectTimeout() time.Duration {
+	return time.Duration(l.ConnectTimeoutMs) * time.Millisecond
+}
+
+func (l FetchLimits) ttfb() time.Duration {
+	return time.Duration(l.TTFBTimeoutMs) * time.Millisecond
+}
+
+func (l FetchLimits) redirects() int {
+	return max(l.MaxRedirects, 0)
+}
+
+// checkType проверяет тип содержимого по AllowedTypes; пустой тип не проверяется
+func (l FetchLimits) checkType(contentType string) error {
+	if len(l.AllowedTypes) == 0 || contentType == "" {
+		return nil
+	}
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mt = strings.ToLower(strings.TrimSpace(contentType))
+	}
+	major, _, _ := strings.Cut(mt, "/")
+	for _, allowed := range l.AllowedTypes {
+		allowed = strings.ToLower(allowed)
+		if allowed == mt || allowed == major+"/*" || allowed == "*/*" {
+			return nil
+		}
+	}
+	return &LimitError{Limit: LimitContentType, Detail: fmt.Sprintf("%s is not allowed", mt)}
+}
+
+// checkRedirect CheckRedirect для http.Client
+func (l FetchLimits) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > l.redirects() {
+		return &LimitError{Limit: LimitRedirects, Detail: fmt.Sprintf("more than %d redirects", l.redirects())}
+	}
+	return nil
+}
+
+func (l FetchLimits) bodyError() error {
+	return &LimitError{Limit: LimitBodySize, Detail: fmt.Sprintf("response is larger than %d bytes", l.MaxBodyBytes)}
+}
+
+// observeFetch учитывает загрузку в метриках; прерванные ограничением считаются отдельно
+func observeFetch(host string, status int, err error, d time.Duration) {
+	metrics.ObservePage(host, status, err, d)
+	if limit := LimitOf(err); limit != "" {
+		metrics.FetchLimits.WithLabelValues(limit).Inc()
+	}
+}
+
+// limitedBody тело ответа net/http под ограничениями размера и времени: ошибка чтения
+// после срабатывания ограничения заменяется на LimitError
+type limitedBody struct {
+	io.ReadCloser
+	ctx    context.Context
+	cancel func()
+	lim    FetchLimits
+	read   int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if b.lim.MaxBodyBytes > 0 && b.read > b.lim.MaxBodyBytes {
+		return n, b.lim.bodyError()
+	}
+	if err != nil && err != io.EOF {
+		if le := limitCause(b.ctx); le != nil {
+			return n, le
+		}
+	}
+	return n, err
+}
+
+func (b *limitedBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// navigation следит в Chrome за запросом документа главной вкладки и прерывает переход,
+// если ответ нарушает ограничения: не пришел за TTFB, слишком много переадресаций,
+// недопустимый тип или слишком большой размер
+type navigation struct {
+	// ctx контекст перехода: отменяется с LimitError при нарушении ограничений
+	ctx   context.Context
+	lim   FetchLimits
+	frame cdp.FrameID
+	abort context.CancelCauseFunc
+	// stopListening отписывает от событий вкладки
+	stopListening context.CancelFunc
+
+	mu        sync.Mutex
+	request   network.RequestID
+	redirects int
+	received  int64
+	ttfb      *time.Timer
+	// language заголовок Content-Language ответа
+	language string
+}
+
+// watchNavigation начинает наблюдение за переходом во вкладке ctx
+func watchNavigation(ctx context.Context, lim FetchLimits) *navigation {
+	navCtx, abort := context.WithCancelCause(ctx)
+	n := &navigation{ctx: navCtx, lim: lim, abort: abort}
+	if c := chromedp.FromContext(ctx); c != nil && c.Target != nil {
+		n.frame = cdp.FrameID(c.Target.TargetID)
+	}
+	if d := lim.ttfb(); d > 0 {
+		n.ttfb = time.AfterFunc(d, func() {
+			n.fail(LimitTTFB, fmt.Sprintf("no response in %v", d))
+		})
+	}
+
+	listenCtx, cancel := context.WithCancel(navCtx)
+	n.stopListening = cancel
+	chromedp.ListenTarget(listenCtx, n.handle)
+	return n
+}
+
+// stop прекращает наблюдение
+func (n *navigation) stop() {
+	n.stopListening()
+	if n.ttfb != nil {
+		n.ttfb.Stop()
+	}
+	n.abort(nil)
+}
+
+// contentLanguage заголовок Content-Language главного документа
+func (n *navigation) contentLanguage() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.language
+}
+
+func (n *navigation) fail(limit, detail string) {
+	n.abort(&LimitError{Limit: limit, Detail: detail})
+}
+
+func (n *navigation) handle(ev any) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	switch e := ev.(type) {
+	case *network.EventRequestWillBeSent:
+		// Главный документ: запрос навигации (requestId совпадает с loaderId) во фрейме вкладки
+		if e.Type != network.ResourceTypeDocument || e.RequestID != network.RequestID(e.LoaderID) ||
+			(n.frame != "" && e.FrameID != n.frame) {
+			return
+		}
+		n.request = e.RequestID
+		if e.RedirectResponse == nil {
+			return
+		}
+		n.redirects++
+		if n.redirects > n.lim.redirects() {
+			n.fail(LimitRedirects, fmt.Sprintf("more than %d redirects", n.lim.redirects()))
+			return
+		}
+		// Время до ответа отсчитывается для каждого запроса цепочки
+		if n.ttfb != nil {
+			n.ttfb.Reset(n.lim.ttfb())
+		}
+	case *network.EventResponseReceived:
+		if e.RequestID != n.request || e.Response == nil {
+			return
+		}
+		if n.ttfb != nil {
+			n.ttfb.Stop()
+		}
+		for name, v := range e.Response.Headers {
+			if s, ok := v.(string); ok && strings.EqualFold(name, "Content-Language") {
+				n.language = s
+			}
+		}
+		if err := n.lim.checkType(e.Response.MimeType); err != nil {
+			n.abort(err)
+		}
+	case *network.EventDataReceived:
+		if e.RequestID != n.request || n.lim.MaxBodyBytes == 0 {
+			return
+		}
+		n.received += e.DataLength
+		if n.received > n.lim.MaxBodyBytes {
+			n.abort(n.lim.bodyError())
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package downloader
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitsFor(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		var l *Limits
+		lim := l.For("example.com")
+		assert.Equal(t, 20*time.Second, lim.total())
+		assert.Equal(t, 10, lim.redirects())
+		assert.Zero(t, lim.MaxBodyBytes)
+	})
+
+	t.Run("domain overrides", func(t *testing.T) {
+		l, err := NewLimits(LimitsConfig{
+			FetchLimits: FetchLimits{MaxBodyBytes: 1 << 20, TTFBTimeoutMs: 3000, AllowedTypes: []string{"text/html"}},
+			Domains: map[string]FetchLimits{
+				"Slow.Example.com.": {TTFBTimeoutMs: 10000, TotalTimeoutSec: 60, MaxRedirects: -1},
+			},
+		})
+		require.NoError(t, err)
+
+		lim := l.For("cdn.slow.example.com")
+		assert.Equal(t, int64(1<<20), lim.MaxBodyBytes, "unset fields are inherited")
+		assert.Equal(t, 10*time.Second, lim.ttfb())
+		assert.Equal(t, time.Minute, lim.total())
+		assert.Equal(t, 0, lim.redirects())
+
+		lim = l.For("example.com")
+		assert.Equal(t, 3*time.Second, lim.ttfb())
+		assert.Equal(t, 10, lim.redirects())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, cfg := range []LimitsConfig{
+			{FetchLimits: FetchLimits{MaxBodyBytes: -1}},
+			{FetchLimits: FetchLimits{MaxRedirects: -2}},
+			{FetchLimits: FetchLimits{AllowedTypes: []string{"html"}}},
+			{Domains: map[string]FetchLimits{"example.com": {TTFBTimeoutMs: -5}}},
+		} {
+			_, err := NewLimits(cfg)
+			assert.Error(t, err, cfg)
+		}
+	})
+}
+
+func TestCheckType(t *testing.T) {
+	lim := FetchLimits{AllowedTypes: []string{"text/html", "application/*"}}
+	assert.NoError(t, lim.checkType("text/html; charset=utf-8"))
+	assert.NoError(t, lim.checkType("application/pdf"))
+	assert.NoError(t, lim.checkType(""), "unknown type is not checked")
+
+	err := lim.checkType("image/png")
+	assert.Equal(t, LimitContentType, LimitOf(err))
+	assert.NoError(t, FetchLimits{}.checkType("image/png"))
+}
+
+func TestRouterConnectTimeout(t *testing.T) {
+	rt := &router{connectTimeout: 20 * time.Millisecond, limits: make(map[string]*LimitError)}
+	hang := func(ctx context.Context) (net.Conn, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	_, err := rt.connect(context.Background(), "slow.test", hang)
+	assert.Equal(t, LimitConnectTimeout, LimitOf(err))
+	assert.NotNil(t, rt.LimitHit("slow.test"))
+	assert.Nil(t, rt.LimitHit("other.test"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = rt.connect(ctx, "stopped.test", hang)
+	assert.Empty(t, LimitOf(err), "cancelled crawl is not a limit")
+}
+
+func TestFetchStaticLimits(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	server := newTestDNSServer(t)
+	server.A("site.test", 300, "127.0.0.1")
+	resolver := newTestResolver(t, []string{server.addr}, DNSConfig{TimeoutMs: 500}, newRedisCache(mr))
+
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/big":
+			w.Write([]byte("<html><body>" + strings.Repeat("x", 4096) + "</body></html>"))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("\x89PNG"))
+		case "/loop":
+			http.Redirect(w, r, "/loop", http.StatusFound)
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte("<html></html>"))
+		case "/stream":
+			w.Write([]byte("<html>"))
+			w.(http.Flusher).Flush()
+			time.Sleep(1200 * time.Millisecond)
+		}
+	}))
+	defer site.Close()
+	_, port, _ := net.SplitHostPort(site.Listener.Addr().String())
+	base := "http://site.test:" + port
+
+	limits, err := NewLimits(LimitsConfig{FetchLimits: FetchLimits{
+		MaxBodyBytes:    1024,
+		AllowedTypes:    []string{"text/html"},
+		TTFBTimeoutMs:   100,
+		TotalTimeoutSec: 1,
+		MaxRedirects:    3,
+	}})
+	require.NoError(t, err)
+	fetcher := &Fetcher{Resolver: resolver, Limits: limits}
+
+	for path, limit := range map[string]string{
+		"/big":    LimitBodySize,
+		"/image":  LimitContentType,
+		"/loop":   LimitRedirects,
+		"/slow":   LimitTTFB,
+		"/stream": LimitTotalTimeout,
+	} {
+		_, err := fetcher.FetchStaticHTML(context.Background(), base+path)
+		assert.Equal(t, limit, LimitOf(err), "%s: %v", path, err)
+	}
+}
@@ -0,0 +1,177 @@
+package downloader
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"golang.org/x/net/html"
+)
+
+// Откуда взялась ссылка
+const (
+	// OriginAnchor элемент <a href> в DOM
+	OriginAnchor = "anchor"
+	// OriginXHR и OriginFetch GET-запросы страницы к API
+	OriginXHR   = "xhr"
+	OriginFetch = "fetch"
+	// OriginHistory маршрут SPA из history.pushState или replaceState
+	OriginHistory = "history"
+	// OriginWindowOpen адрес, переданный window.open
+	OriginWindowOpen = "window_open"
+	// OriginOnclick переход в обработчике onclick или ссылке javascript:
+	OriginOnclick = "onclick"
+)
+
+// Link ссылка, найденная на странице, и ее происхождение
+type Link struct {
+	URL    string
+	Origin string
+}
+
+// linkSet ссылки со всех состояний страницы без повторов и в порядке появления.
+// Пополняется и из обработчика событий Chrome, поэтому защищен мьютексом
+type linkSet struct {
+	mu    sync.Mutex
+	seen  map[string]bool
+	links []Link
+}
+
+func (l *linkSet) add(origin string, hrefs ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.seen == nil {
+		l.seen = make(map[string]bool)
+	}
+	for _, href := range hrefs {
+		if !strings.HasPrefix(href, "http://") && !strings.HasPrefix(href, "https://") {
+			continue
+		}
+		// Якорь не меняет страницу
+		if i := strings.IndexByte(href, '#'); i >= 0 {
+			href = href[:i]
+		}
+		if !l.seen[href] {
+			l.seen[href] = true
+			l.links = append(l.links, Link{URL: href, Origin: origin})
+		}
+	}
+}
+
+func (l *linkSet) list() []Link {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Link(nil), l.links...)
+}
+
+// hookLinksJS выполняется до скриптов страницы и запоминает адреса из history.pushState,
+// history.replaceState и window.open. Всплывающие окна не открываются
+const hookLinksJS = `(() => {
+	// chromeSetup может добавить скрипт несколько раз, например при повторном входе
+	if (window.__crawlerLinks) return;
+	const links = window.__crawlerLinks = [];
+	const record = (url, origin) => {
+		try { links.push({url: new URL(url, location.href).href, origin}); } catch (e) {}
+	};
+	for (const name of ['pushState', 'replaceState']) {
+		const orig = history[name];
+		history[name] = function (state, title, url) {
+			if (url != null) record(String(url), 'history');
+			return orig.apply(this, arguments);
+		};
+	}
+	window.open = function (url) {
+		if (url) record(String(url), 'window_open');
+		return null;
+	};
+})()`
+
+// drainHookedJS забирает адреса, накопленные hookLinksJS
+const drainHookedJS = `(window.__crawlerLinks || []).splice(0)`
+
+// collectLinks собирает ссылки текущего состояния страницы: <a href> и перехваченные переходы
+func collectLinks(links *linkSet, anchors bool) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		if anchors {
+			var hrefs []string
+			if err := chromedp.Evaluate(collectLinksJS, &hrefs).Do(ctx); err != nil {
+				return err
+			}
+			links.add(OriginAnchor, hrefs...)
+		}
+
+		var hooked []struct {
+			URL    string `json:"url"`
+			Origin string `json:"origin"`
+		}
+		if err := chromedp.Evaluate(drainHookedJS, &hooked).Do(ctx); err != nil {
+			return err
+		}
+		for _, h := range hooked {
+			links.add(h.Origin, h.URL)
+		}
+		return nil
+	})
+}
+
+// listenRequests добавляет в links адреса GET-запросов XHR и fetch, пока ctx не отменен
+func listenRequests(ctx context.Context, links *linkSet) {
+	chromedp.ListenTarget(ctx, func(ev any) {
+		e, ok := ev.(*network.EventRequestWillBeSent)
+		if !ok || e.Request == nil || e.Request.Method != "GET" {
+			return
+		}
+		switch e.Type {
+		case network.ResourceTypeXHR:
+			links.add(OriginXHR, e.Request.URL)
+		case network.ResourceTypeFetch:
+			links.add(OriginFetch, e.Request.URL)
+		}
+	})
+}
+
+// scriptNavigation адрес в присваивании location, location.assign/replace или window.open
+var scriptNavigation = regexp.MustCompile(
+	`(?:location(?:\.href)?\s*=|location\.(?:assign|replace)\s*\(|window\.open\s*\()\s*['"]([^'"]+)['"]`)
+
+// ExtractScriptLinks возвращает адреса переходов из обработчиков onclick и ссылок javascript:
+func ExtractScriptLinks(htmlPage string, pageURL string) []string {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil
+	}
+	n, err := html.Parse(strings.NewReader(htmlPage))
+	if err != nil {
+		return nil
+	}
+
+	var links []string
+	var f func(*html.Node)
+	f = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			for _, a := range n.Attr {
+				code := ""
+				switch {
+				case a.Key == "onclick":
+					code = a.Val
+				case a.Key == "href" && strings.HasPrefix(strings.TrimSpace(a.Val), "javascript:"):
+					code = a.Val
+				}
+				for _, m := range scriptNavigation.FindAllStringSubmatch(code, -1) {
+					if ref, err := url.Parse(m[1]); err == nil {
+						links = append(links, base.ResolveReference(ref).String())
+					}
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			f(c)
+		}
+	}
+	f(n)
+	return links
+}
@@ -0,0 +1,36 @@
+package downloader
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinkSet(t *testing.T) {
+	var links linkSet
+	links.add(OriginAnchor, "https://shop.test/a", "javascript:void(0)", "https://shop.test/b#reviews")
+	links.add(OriginXHR, "https://shop.test/a", "mailto:shop@shop.test", "https://shop.test/b", "https://shop.test/api/items")
+	assert.Equal(t, []Link{
+		{URL: "https://shop.test/a", Origin: OriginAnchor},
+		{URL: "https://shop.test/b", Origin: OriginAnchor},
+		{URL: "https://shop.test/api/items", Origin: OriginXHR},
+	}, links.list())
+}
+
+func TestExtractScriptLinks(t *testing.T) {
+	page := `<html><body>
+		<button onclick="location.href='/catalog?page=2'">Next</button>
+		<div onclick="window.location = 'https://shop.test/about'"></div>
+		<span onclick="location.assign(&quot;/cart&quot;)"></span>
+		<a href="javascript:window.open('/help', '_blank')">Help</a>
+		<a href="/plain">Plain</a>
+		<button onclick="track('click')">Track</button>
+	</body></html>`
+
+	assert.Equal(t, []string{
+		"https://shop.test/catalog?page=2",
+		"https://shop.test/about",
+		"https://shop.test/cart",
+		"https://shop.test/help",
+	}, ExtractScriptLinks(page, "https://shop.test/products/"))
+}
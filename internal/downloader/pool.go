@@ -4,6 +4,7 @@ import (
 	"sync"
 )
 
+// URLsPool - frontier краулера: множество уже известных URL
 type URLsPool struct {
 	m       *sync.RWMutex
 	content map[string]bool
@@ -17,16 +18,37 @@ func CreatePool(mu *sync.RWMutex) *URLsPool {
 }
 
 func (p *URLsPool) Exist(url string) bool {
-	value, ok := p.content[url]
-	if ok {
-		return value && ok
-	} else {
-		return ok
-	}
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return p.content[url]
 }
 
 func (p *URLsPool) Add(url string) {
-	p.m.RLock()
+	p.m.Lock()
+	p.content[url] = true
+	p.m.Unlock()
+}
+
+// Remove убирает URL из пула, например если он не попал в очередь
+func (p *URLsPool) Remove(url string) {
+	p.m.Lock()
+	delete(p.content, url)
+	p.m.Unlock()
+}
+
+// TryAdd добавляет URL и возвращает true, если его еще не было в пуле
+func (p *URLsPool) TryAdd(url string) bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+	if p.content[url] {
+		return false
+	}
 	p.content[url] = true
-	p.m.RUnlock()
+	return true
+}
+
+func (p *URLsPool) Len() int {
+	p.m.RLock()
+	defer p.m.RUnlock()
+	return len(p.content)
 }
@@ -0,0 +1,399 @@
+package downloader
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"web_crawler/internal/egress"
+	"web_crawler/internal/logging"
+	"web_crawler/internal/metrics"
+)
+
+// happyEyeballsDelay через сколько запускается попытка соединения со следующим адресом (RFC 8305)
+const happyEyeballsDelay = 250 * time.Millisecond
+
+// hopHeaders заголовки одного соединения, которые прокси не передает дальше
+var hopHeaders = []string{
+	"Connection", "Proxy-Connection", "Keep-Alive", "Proxy-Authenticate", "Proxy-Authorization",
+	"Te", "Trailer", "Transfer-Encoding", "Upgrade",
+}
+
+// router решает, как соединяться с хостом: через прокси выхода или напрямую по адресам
+// из DNSResolver. Через прокси имя хоста разрешает сам прокси
+type router struct {
+	ctx      context.Context
+	resolver *DNSResolver
+	pool     *egress.Pool
+	// sticky прокси, выбранный для всей загрузки; хосты с закрепленным прокси идут через свой
+	sticky *egress.Proxy
+	// connectTimeout время на одно соединение; 0 - без ограничения
+	connectTimeout time.Duration
+
+	mu sync.Mutex
+	// peers адрес последнего прямого соединения с каждым хостом
+	peers map[string]net.IP
+	// limits ограничения, сработавшие при соединении с хостом
+	limits map[string]*LimitError
+}
+
+// newRouter выбирает прокси выхода для загрузки страницы с host; ctx используется для логов
+func newRouter(ctx context.Context, resolver *DNSResolver, pool *egress.Pool, host string) (*router, error) {
+	sticky, err := pool.Pick(host)
+	if err != nil {
+		return nil, err
+	}
+	return &router{
+		ctx:      ctx,
+		resolver: resolver,
+		pool:     pool,
+		sticky:   sticky,
+		peers:    make(map[string]net.IP),
+		limits:   make(map[string]*LimitError),
+	}, nil
+}
+
+// route прокси выхода для хоста; nil - соединение напрямую
+func (r *router) route(host string) *egress.Proxy {
+	if pr, ok := r.pool.Assigned(host); ok {
+		return pr
+	}
+	return r.sticky
+}
+
+// transport http.Transport, который ходит через прокси выхода или напрямую
+func (r *router) transport() *http.Transport {
+	return &http.Transport{
+		Proxy: func(req *http.Request) (*url.URL, error) {
+			if pr := r.route(req.URL.Hostname()); pr != nil {
+				return pr.URL(), nil
+			}
+			return nil, nil
+		},
+		// С самим прокси выхода транспорт соединяется напрямую
+		DialContext:         r.dialDirect,
+		MaxIdleConnsPerHost: 4,
+		IdleConnTimeout:     30 * time.Second,
+	}
+}
+
+// LimitHit ограничение, которое сработало при соединении с host. Через локальный прокси Chrome
+// видит такую ошибку только как ответ 502 или обрыв туннеля
+func (r *router) LimitHit(host string) *LimitError {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.limits[host]
+}
+
+// connect вызывает dial не дольше connectTimeout и отличает истечение этого времени от прочих ошибок
+func (r *router) connect(ctx context.Context, host string, dial func(context.Context) (net.Conn, error)) (net.Conn, error) {
+	if r.connectTimeout <= 0 {
+		return dial(ctx)
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, r.connectTimeout)
+	defer cancel()
+	conn, err := dial(dialCtx)
+	if err != nil && dialCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		le := &LimitError{Limit: LimitConnectTimeout, Detail: fmt.Sprintf("no connection to %s in %v", host, r.connectTimeout)}
+		r.mu.Lock()
+		r.limits[host] = le
+		r.mu.Unlock()
+		return nil, le
+	}
+	return conn, err
+}
+
+// PeerIP возвращает адрес, с которым последним было прямое соединение для host
+func (r *router) PeerIP(host string) net.IP {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.peers[host]
+}
+
+// resolvingProxy локальный HTTP-прокси для Chrome. Chrome отдает ему имена хостов,
+// прокси разрешает их через DNSResolver и соединяется по Happy Eyeballs, поэтому
+// собственный DNS браузера не используется ни для страницы, ни для ресурсов с CDN.
+// Если настроены прокси выхода, соединения идут через них
+type resolvingProxy struct {
+	*router
+	ln        net.Listener
+	srv       *http.Server
+	transport *http.Transport
+
+	mu sync.Mutex
+	// tunnels соединения CONNECT, которые http.Server не закрывает сам
+	tunnels map[net.Conn]struct{}
+}
+
+// startProxy запускает прокси на случайном порту 127.0.0.1
+func startProxy(r *router) (*resolvingProxy, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to start proxy: %v", err)
+	}
+
+	p := &resolvingProxy{
+		router:    r,
+		ln:        ln,
+		transport: r.transport(),
+		tunnels:   make(map[net.Conn]struct{}),
+	}
+	p.srv = &http.Server{Handler: p, ReadHeaderTimeout: 10 * time.Second}
+	go p.srv.Serve(ln)
+	return p, nil
+}
+
+// URL адрес прокси для флага --proxy-server
+func (p *resolvingProxy) URL() string {
+	return "http://" + p.ln.Addr().String()
+}
+
+func (p *resolvingProxy) Close() error {
+	err := p.srv.Close()
+	p.transport.CloseIdleConnections()
+
+	p.mu.Lock()
+	for conn := range p.tunnels {
+		conn.Close()
+	}
+	p.mu.Unlock()
+	return err
+}
+
+func (p *resolvingProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "proxy expects an absolute URL", http.StatusBadRequest)
+		return
+	}
+
+	out := r.Clone(r.Context())
+	out.RequestURI = ""
+	for _, h := range hopHeaders {
+		out.Header.Del(h)
+	}
+
+	resp, err := p.transport.RoundTrip(out)
+	p.pool.ReportError(p.route(r.URL.Hostname()), err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, h := range hopHeaders {
+		resp.Header.Del(h)
+	}
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// tunnel обрабатывает CONNECT: HTTPS и WebSocket идут через него как есть
+func (p *resolvingProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	upstream, err := p.dial(r.Context(), "tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		upstream.Close()
+		http.Error(w, "hijacking is not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buf, err := hj.Hijack()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	p.track(client, true)
+	p.track(upstream, true)
+	defer func() {
+		p.track(client, false)
+		p.track(upstream, false)
+		client.Close()
+		upstream.Close()
+	}()
+
+	if _, err := client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		return
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		// В буфере могут остаться байты, которые клиент отправил сразу после CONNECT
+		io.Copy(upstream, buf)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(client, upstream)
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+func (p *resolvingProxy) track(conn net.Conn, add bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if add {
+		p.tunnels[conn] = struct{}{}
+	} else {
+		delete(p.tunnels, conn)
+	}
+}
+
+// dial соединяется с addr через прокси выхода хоста или напрямую
+func (r *router) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	pr := r.route(host)
+	if pr == nil {
+		return r.dialDirect(ctx, network, addr)
+	}
+
+	conn, err := r.connect(ctx, host, func(ctx context.Context) (net.Conn, error) {
+		return pr.DialContext(ctx, network, addr)
+	})
+	r.pool.ReportError(pr, err)
+	if err != nil {
+		logging.FromContext(r.ctx).Debug("Proxy dial failed", logging.KeyHost, host, "proxy", pr.String(), logging.Err(err))
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dialDirect разрешает хост через DNSResolver и соединяется с одним из его адресов
+func (r *router) dialDirect(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Резолвинг идет в контексте загрузки, чтобы его спаны и логи были привязаны к странице
+	ips, err := r.resolver.Resolve(r.ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("lookup %s: %w", host, ErrNoAddresses)
+	}
+
+	var ip net.IP
+	conn, err := r.connect(ctx, host, func(ctx context.Context) (conn net.Conn, err error) {
+		conn, ip, err = dialHappyEyeballs(ctx, interleave(ips), port, happyEyeballsDelay)
+		return conn, err
+	})
+	if LimitOf(err) != "" {
+		return nil, err
+	}
+	if err != nil {
+		logging.FromContext(r.ctx).Debug("Dial failed", logging.KeyHost, host, logging.Err(err))
+		return nil, fmt.Errorf("dial %s: %v", host, err)
+	}
+
+	r.mu.Lock()
+	r.peers[host] = ip
+	r.mu.Unlock()
+	return conn, nil
+}
+
+// interleave чередует IPv6 и IPv4, начиная с IPv6, как советует RFC 8305
+func interleave(ips []net.IP) []net.IP {
+	var v4, v6 []net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			v4 = append(v4, ip)
+		} else {
+			v6 = append(v6, ip)
+		}
+	}
+
+	out := make([]net.IP, 0, len(ips))
+	for i := 0; i < len(v4) || i < len(v6); i++ {
+		if i < len(v6) {
+			out = append(out, v6[i])
+		}
+		if i < len(v4) {
+			out = append(out, v4[i])
+		}
+	}
+	return out
+}
+
+// dialHappyEyeballs соединяется с адресами по очереди: следующая попытка начинается,
+// если предыдущая не удалась или не успела за delay. Побеждает первое соединение
+func dialHappyEyeballs(ctx context.Context, ips []net.IP, port string, delay time.Duration) (net.Conn, net.IP, error) {
+	type result struct {
+		conn net.Conn
+		ip   net.IP
+		err  error
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan result, len(ips))
+	var d net.Dialer
+	next, pending := 0, 0
+	start := func() {
+		ip := ips[next]
+		next++
+		pending++
+		if next > 1 {
+			metrics.Retries.WithLabelValues("dial").Inc()
+		}
+		go func() {
+			conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+			results <- result{conn, ip, err}
+		}()
+	}
+
+	start()
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var errs []error
+	for pending > 0 {
+		select {
+		case res := <-results:
+			pending--
+			if res.err == nil {
+				// Опоздавшие попытки отменяются, успевшие соединиться закрываются
+				go func(n int) {
+					for i := 0; i < n; i++ {
+						if late := <-results; late.conn != nil {
+							late.conn.Close()
+						}
+					}
+				}(pending)
+				return res.conn, res.ip, nil
+			}
+			errs = append(errs, fmt.Errorf("%s: %v", res.ip, res.err))
+			if next < len(ips) {
+				start()
+				timer.Reset(delay)
+			}
+		case <-timer.C:
+			if next < len(ips) {
+				start()
+				timer.Reset(delay)
+			}
+		}
+	}
+	return nil, nil, errors.Join(errs...)
+}
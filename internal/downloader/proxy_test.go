@@ -0,0 +1,222 @@
+package downloader
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"web_crawler/internal/egress"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterleave(t *testing.T) {
+	ips := []net.IP{
+		net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3"),
+		net.ParseIP("fd00::1"),
+	}
+	assert.Equal(t, []string{"fd00::1", "10.0.0.1", "10.0.0.2", "10.0.0.3"}, ipStrings(interleave(ips)))
+}
+
+func TestDialHappyEyeballs(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, err := net.SplitHostPort(ln.Addr().String())
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	t.Run("falls back to the next address", func(t *testing.T) {
+		// На 127.0.0.2 с этим портом никто не слушает
+		ips := []net.IP{net.ParseIP("127.0.0.2"), net.ParseIP("127.0.0.1")}
+		conn, ip, err := dialHappyEyeballs(ctx, ips, port, time.Second)
+		require.NoError(t, err)
+		conn.Close()
+		assert.Equal(t, "127.0.0.1", ip.String())
+	})
+
+	t.Run("does not wait for a hanging address", func(t *testing.T) {
+		// 192.0.2.0/24 (TEST-NET-1) не маршрутизируется: соединение зависает или сразу падает
+		ips := []net.IP{net.ParseIP("192.0.2.1"), net.ParseIP("127.0.0.1")}
+		begin := time.Now()
+		conn, ip, err := dialHappyEyeballs(ctx, ips, port, 50*time.Millisecond)
+		require.NoError(t, err)
+		conn.Close()
+		assert.Equal(t, "127.0.0.1", ip.String())
+		assert.Less(t, time.Since(begin), time.Second)
+	})
+
+	t.Run("all addresses fail", func(t *testing.T) {
+		_, _, err := dialHappyEyeballs(ctx, []net.IP{net.ParseIP("127.0.0.2")}, port, time.Second)
+		assert.Error(t, err)
+	})
+}
+
+func TestResolvingProxy(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	server := newTestDNSServer(t)
+	server.A("site.test", 300, "127.0.0.1")
+	resolver := newTestResolver(t, []string{server.addr}, DNSConfig{TimeoutMs: 500}, newRedisCache(mr))
+
+	proxy := newTestProxy(t, resolver, nil)
+	client := newProxyClient(t, proxy.URL())
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "host=%s", r.Host)
+	})
+
+	t.Run("plain HTTP", func(t *testing.T) {
+		site := httptest.NewServer(handler)
+		defer site.Close()
+		_, port, _ := net.SplitHostPort(site.Listener.Addr().String())
+
+		resp, err := client.Get("http://site.test:" + port + "/page")
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "host=site.test:"+port, string(body))
+		assert.Equal(t, "127.0.0.1", proxy.PeerIP("site.test").String())
+	})
+
+	t.Run("HTTPS through CONNECT", func(t *testing.T) {
+		site := httptest.NewTLSServer(handler)
+		defer site.Close()
+		_, port, _ := net.SplitHostPort(site.Listener.Addr().String())
+
+		resp, err := client.Get("https://site.test:" + port + "/")
+		require.NoError(t, err)
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		assert.Equal(t, "host=site.test:"+port, string(body))
+	})
+
+	t.Run("unknown host", func(t *testing.T) {
+		resp, err := client.Get("http://missing.test/")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+		assert.Nil(t, proxy.PeerIP("missing.test"))
+	})
+}
+
+func TestResolvingProxyEgress(t *testing.T) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	defer mr.Close()
+
+	server := newTestDNSServer(t)
+	server.A("site.test", 300, "127.0.0.1")
+	server.A("direct.test", 300, "127.0.0.1")
+	resolver := newTestResolver(t, []string{server.addr}, DNSConfig{TimeoutMs: 500}, newRedisCache(mr))
+
+	// Прокси выхода - еще один локальный прокси без пула: он сам разрешает имена
+	upstream := newTestProxy(t, resolver, nil)
+	pool, err := egress.NewPool(egress.Config{
+		Proxies:        []string{upstream.URL()},
+		Domains:        map[string]string{"direct.test": egress.Direct},
+		HealthCheckSec: -1,
+	})
+	require.NoError(t, err)
+	defer pool.Close()
+
+	proxy := newTestProxy(t, resolver, pool)
+	client := newProxyClient(t, proxy.URL())
+
+	var status int64 = http.StatusOK
+	site := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt64(&status)))
+		fmt.Fprint(w, "<html><body>ok</body></html>")
+	}))
+	defer site.Close()
+	plain := httptest.NewServer(site.Config.Handler)
+	defer plain.Close()
+	_, port, _ := net.SplitHostPort(site.Listener.Addr().String())
+	_, plainPort, _ := net.SplitHostPort(plain.Listener.Addr().String())
+
+	t.Run("HTTPS through egress proxy", func(t *testing.T) {
+		resp, err := client.Get("https://site.test:" + port + "/")
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		// Имя разрешил прокси выхода, локальный прокси напрямую не соединялся
+		assert.Nil(t, proxy.PeerIP("site.test"))
+		assert.Equal(t, "127.0.0.1", upstream.PeerIP("site.test").String())
+	})
+
+	t.Run("plain HTTP through egress proxy", func(t *testing.T) {
+		resp, err := client.Get("http://site.test:" + plainPort + "/")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Nil(t, proxy.PeerIP("site.test"))
+	})
+
+	t.Run("direct domain bypasses egress proxy", func(t *testing.T) {
+		resp, err := client.Get("https://direct.test:" + port + "/")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, "127.0.0.1", proxy.PeerIP("direct.test").String())
+		assert.Nil(t, upstream.PeerIP("direct.test"))
+	})
+
+	t.Run("ban status takes proxy out of rotation", func(t *testing.T) {
+		atomic.StoreInt64(&status, http.StatusTooManyRequests)
+		fetcher := &Fetcher{Resolver: resolver, Proxies: pool}
+		_, err := fetcher.FetchStaticHTML(context.Background(), "http://site.test:"+plainPort+"/")
+		assert.Error(t, err)
+
+		_, err = fetcher.FetchStaticHTML(context.Background(), "http://site.test:"+plainPort+"/")
+		assert.ErrorIs(t, err, egress.ErrNoProxy)
+	})
+}
+
+func newTestProxy(t *testing.T, resolver *DNSResolver, pool *egress.Pool) *resolvingProxy {
+	t.Helper()
+	rt, err := newRouter(context.Background(), resolver, pool, "site.test")
+	require.NoError(t, err)
+	proxy, err := startProxy(rt)
+	require.NoError(t, err)
+	t.Cleanup(func() { proxy.Close() })
+	return proxy
+}
+
+// newProxyClient клиент, который ходит через прокси и доверяет сертификатам тестовых серверов
+func newProxyClient(t *testing.T, proxy string) *http.Client {
+	proxyURL, err := url.Parse(proxy)
+	require.NoError(t, err)
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		Timeout: 5 * time.Second,
+	}
+	t.Cleanup(client.CloseIdleConnections)
+	return client
+}
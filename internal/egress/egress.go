@@ -0,0 +1,492 @@
+package egress
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"web_crawler/internal/logging"
+	"web_crawler/internal/metrics"
+
+	"golang.org/x/net/proxy"
+)
+
+// Способы ротации прокси
+const (
+	RotationRoundRobin = "round_robin"
+	RotationRandom     = "random"
+)
+
+// Direct значение в domains: домен загружается без прокси
+const Direct = "direct"
+
+// ErrNoProxy все прокси пула забанены или не прошли проверку
+var ErrNoProxy = errors.New("no healthy proxy available")
+
+// Config настройки прокси выхода
+type Config struct {
+	// Proxies пул для ротации: http://, https:// (TLS до прокси) или socks5://, с user:password@ для авторизации
+	Proxies []string `json:"proxies"`
+	// Domains закрепляет за доменом и его поддоменами прокси или "direct"
+	Domains map[string]string `json:"domains"`
+	// Rotation round_robin (по умолчанию) или random
+	Rotation string `json:"rotation"`
+	// HealthCheckURL адрес для проверки прокси; без него проверяется только соединение с прокси
+	HealthCheckURL string `json:"health_check_url"`
+	// HealthCheckSec период проверки, по умолчанию 60; -1 отключает проверки, тогда выведенный
+	// из ротации прокси возвращается в нее через ban_cooldown_sec
+	HealthCheckSec int `json:"health_check_sec"`
+	// FailureThreshold число ошибок соединения подряд, после которого прокси выводится из ротации, по умолчанию 3
+	FailureThreshold int `json:"failure_threshold"`
+	// BanStatuses коды ответа, по которым прокси считается забаненным, по умолчанию 407 и 429.
+	// 403 обычно означает запрет конкретной страницы, а не прокси
+	BanStatuses []int `json:"ban_statuses"`
+	// BanCooldownSec на сколько забаненный прокси выводится из ротации, по умолчанию 300
+	BanCooldownSec int `json:"ban_cooldown_sec"`
+}
+
+func (c Config) withDefaults() Config {
+	if c.Rotation == "" {
+		c.Rotation = RotationRoundRobin
+	}
+	if c.HealthCheckSec == 0 {
+		c.HealthCheckSec = 60
+	}
+	if c.FailureThreshold <= 0 {
+		c.FailureThreshold = 3
+	}
+	if c.BanStatuses == nil {
+		c.BanStatuses = []int{http.StatusProxyAuthRequired, http.StatusTooManyRequests}
+	}
+	if c.BanCooldownSec <= 0 {
+		c.BanCooldownSec = 300
+	}
+	return c
+}
+
+// Redacted копия настроек без паролей прокси, для сохранения вместе с запуском
+func (c Config) Redacted() Config {
+	redact := func(raw string) string {
+		if u, err := url.Parse(raw); err == nil {
+			return u.Redacted()
+		}
+		return raw
+	}
+
+	proxies := make([]string, len(c.Proxies))
+	for i, raw := range c.Proxies {
+		proxies[i] = redact(raw)
+	}
+	c.Proxies = proxies
+	if c.Domains != nil {
+		domains := make(map[string]string, len(c.Domains))
+		for domain, raw := range c.Domains {
+			domains[domain] = redact(raw)
+		}
+		c.Domains = domains
+	}
+	return c
+}
+
+// Proxy один прокси выхода и его состояние
+type Proxy struct {
+	url   *url.URL
+	socks proxy.ContextDialer
+
+	mu          sync.Mutex
+	failures    int
+	unhealthy   bool
+	bannedUntil time.Time
+	// retryAt когда неисправный прокси возвращается в ротацию, если проверки отключены
+	retryAt time.Time
+}
+
+// Parse разбирает адрес прокси вида scheme://[user:password@]host:port
+func Parse(raw string) (*Proxy, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %v", raw, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %q: missing host", raw)
+	}
+
+	p := &Proxy{url: u}
+	switch u.Scheme {
+	case "http", "https":
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if u.User != nil {
+			password, _ := u.User.Password()
+			auth = &proxy.Auth{User: u.User.Username(), Password: password}
+		}
+		d, err := proxy.SOCKS5("tcp", u.Host, auth, proxy.Direct)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q: %v", raw, err)
+		}
+		p.socks = d.(proxy.ContextDialer)
+	default:
+		return nil, fmt.Errorf("invalid proxy %q: unsupported scheme %q", raw, u.Scheme)
+	}
+	return p, nil
+}
+
+// String адрес прокси без пароля; используется в логах и метриках
+func (p *Proxy) String() string {
+	return p.url.Redacted()
+}
+
+// URL адрес прокси вместе с авторизацией для http.Transport.Proxy
+func (p *Proxy) URL() *url.URL {
+	return p.url
+}
+
+// DialContext открывает через прокси TCP-соединение с addr: у HTTP-прокси методом CONNECT,
+// у SOCKS5 - командой CONNECT. Имя хоста разрешает сам прокси
+func (p *Proxy) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	if p.socks != nil {
+		conn, err := p.socks.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, fmt.Errorf("proxy %s: %v", p, err)
+		}
+		return conn, nil
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", p.url.Host)
+	if err != nil {
+		return nil, fmt.Errorf("proxy %s: %v", p, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	if p.url.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: p.url.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("proxy %s: %v", p, err)
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if p.url.User != nil {
+		password, _ := p.url.User.Password()
+		creds := base64.StdEncoding.EncodeToString([]byte(p.url.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+creds)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %v", p, err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("proxy %s: %v", p, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, &StatusError{Proxy: p.String(), Status: resp.StatusCode}
+	}
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// StatusError прокси отказал в CONNECT
+type StatusError struct {
+	Proxy  string
+	Status int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("proxy %s: CONNECT failed with status %d", e.Proxy, e.Status)
+}
+
+// bufferedConn отдает байты, прочитанные вместе с ответом на CONNECT
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// available сообщает, участвует ли прокси в ротации
+func (p *Proxy) available(now time.Time) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.unhealthy && !p.retryAt.IsZero() && !now.Before(p.retryAt) {
+		p.setHealthy(true)
+	}
+	return !p.unhealthy && !now.Before(p.bannedUntil)
+}
+
+// Pool пул прокси выхода с ротацией, закреплением за доменами, проверками и баном.
+// Методы допускают nil-пул: все загружается напрямую
+type Pool struct {
+	cfg     Config
+	proxies []*Proxy
+	// domains прокси домена; nil означает загрузку напрямую
+	domains map[string]*Proxy
+	next    uint64
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewPool создает пул и запускает фоновые проверки прокси. Без прокси и доменов возвращает nil
+func NewPool(cfg Config) (*Pool, error) {
+	if len(cfg.Proxies) == 0 && len(cfg.Domains) == 0 {
+		return nil, nil
+	}
+	switch cfg.Rotation {
+	case "", RotationRoundRobin, RotationRandom:
+	default:
+		return nil, fmt.Errorf("unknown proxy rotation %q", cfg.Rotation)
+	}
+
+	p := &Pool{
+		cfg:     cfg.withDefaults(),
+		domains: make(map[string]*Proxy),
+		done:    make(chan struct{}),
+	}
+	for _, raw := range cfg.Proxies {
+		pr, err := Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		p.proxies = append(p.proxies, pr)
+	}
+	for domain, raw := range cfg.Domains {
+		domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+		if raw == Direct {
+			p.domains[domain] = nil
+			continue
+		}
+		pr, err := Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		p.domains[domain] = pr
+	}
+
+	for _, pr := range p.all() {
+		metrics.ProxyHealthy.WithLabelValues(pr.String()).Set(1)
+	}
+	if p.cfg.HealthCheckSec > 0 && len(p.all()) > 0 {
+		p.wg.Add(1)
+		go p.healthLoop(time.Duration(p.cfg.HealthCheckSec) * time.Second)
+	}
+	return p, nil
+}
+
+// Close останавливает проверки
+func (p *Pool) Close() {
+	if p == nil {
+		return
+	}
+	close(p.done)
+	p.wg.Wait()
+}
+
+// Pick выбирает прокси для хоста: закрепленный за доменом или следующий в ротации.
+// nil без ошибки означает загрузку напрямую
+func (p *Pool) Pick(host string) (*Proxy, error) {
+	if p == nil {
+		return nil, nil
+	}
+	if pr, ok := p.Assigned(host); ok {
+		return pr, nil
+	}
+	if len(p.proxies) == 0 {
+		return nil, nil
+	}
+
+	now := time.Now()
+	var available []*Proxy
+	for _, pr := range p.proxies {
+		if pr.available(now) {
+			available = append(available, pr)
+		}
+	}
+	if len(available) == 0 {
+		return nil, ErrNoProxy
+	}
+
+	if p.cfg.Rotation == RotationRandom {
+		return available[rand.Intn(len(available))], nil
+	}
+	return available[int(atomic.AddUint64(&p.next, 1)-1)%len(available)], nil
+}
+
+// Assigned возвращает прокси, закрепленный за хостом или одним из его родительских доменов
+func (p *Pool) Assigned(host string) (*Proxy, bool) {
+	if p == nil {
+		return nil, false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for {
+		if pr, ok := p.domains[host]; ok {
+			return pr, true
+		}
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			return nil, false
+		}
+		host = host[i+1:]
+	}
+}
+
+// ReportStatus учитывает код ответа, полученного через прокси; коды из ban_statuses банят прокси
+func (p *Pool) ReportStatus(pr *Proxy, status int) {
+	if p == nil || pr == nil {
+		return
+	}
+	for _, ban := range p.cfg.BanStatuses {
+		if status == ban {
+			pr.mu.Lock()
+			pr.bannedUntil = time.Now().Add(time.Duration(p.cfg.BanCooldownSec) * time.Second)
+			pr.mu.Unlock()
+			slog.Warn("Proxy banned", "proxy", pr.String(), "status", status, "cooldown_sec", p.cfg.BanCooldownSec)
+			metrics.ProxyBans.WithLabelValues(pr.String()).Inc()
+			return
+		}
+	}
+}
+
+// ReportError учитывает ошибку соединения через прокси; nil сбрасывает счетчик ошибок
+func (p *Pool) ReportError(pr *Proxy, err error) {
+	if p == nil || pr == nil {
+		return
+	}
+	var status *StatusError
+	if errors.As(err, &status) {
+		p.ReportStatus(pr, status.Status)
+		return
+	}
+
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	if err == nil {
+		pr.failures = 0
+		return
+	}
+	pr.failures++
+	if pr.failures == p.cfg.FailureThreshold {
+		slog.Warn("Proxy is unhealthy", "proxy", pr.String(), "failures", pr.failures, logging.Err(err))
+		pr.setHealthy(false)
+		if p.cfg.HealthCheckSec <= 0 {
+			pr.retryAt = time.Now().Add(time.Duration(p.cfg.BanCooldownSec) * time.Second)
+		}
+	}
+}
+
+// setHealthy вызывается под pr.mu
+func (pr *Proxy) setHealthy(healthy bool) {
+	pr.unhealthy = !healthy
+	gauge := 0.0
+	if healthy {
+		pr.failures = 0
+		pr.retryAt = time.Time{}
+		gauge = 1
+	}
+	metrics.ProxyHealthy.WithLabelValues(pr.String()).Set(gauge)
+}
+
+// all все прокси пула, включая закрепленные за доменами
+func (p *Pool) all() []*Proxy {
+	out := append([]*Proxy(nil), p.proxies...)
+	for _, pr := range p.domains {
+		if pr != nil {
+			out = append(out, pr)
+		}
+	}
+	return out
+}
+
+func (p *Pool) healthLoop(interval time.Duration) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.checkAll()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// checkAll проверяет все прокси параллельно
+func (p *Pool) checkAll() {
+	var wg sync.WaitGroup
+	for _, pr := range p.all() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.check(pr)
+			pr.mu.Lock()
+			pr.setHealthy(err == nil)
+			pr.mu.Unlock()
+		}()
+	}
+	wg.Wait()
+}
+
+// check соединяется с прокси или, если задан health_check_url, загружает его через прокси
+func (p *Pool) check(pr *Proxy) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if p.cfg.HealthCheckURL == "" {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", pr.url.Host)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	transport := &http.Transport{Proxy: http.ProxyURL(pr.url)}
+	defer transport.CloseIdleConnections()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.HealthCheckURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("health check status %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,344 @@
+package egress
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testConnectProxy HTTP-прокси для тестов: CONNECT и запросы в absolute-form, с Basic-авторизацией
+type testConnectProxy struct {
+	addr     string
+	auth     string
+	requests int64
+}
+
+func newTestConnectProxy(t *testing.T, user, password string) *testConnectProxy {
+	p := &testConnectProxy{}
+	if user != "" {
+		p.auth = "Basic " + basicAuth(user, password)
+	}
+	srv := httptest.NewServer(p)
+	t.Cleanup(srv.Close)
+	p.addr = srv.Listener.Addr().String()
+	return p
+}
+
+func basicAuth(user, password string) string {
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	req.SetBasicAuth(user, password)
+	return req.Header.Get("Authorization")[len("Basic "):]
+}
+
+func (p *testConnectProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt64(&p.requests, 1)
+	if p.auth != "" && r.Header.Get("Proxy-Authorization") != p.auth {
+		w.WriteHeader(http.StatusProxyAuthRequired)
+		return
+	}
+
+	if r.Method != http.MethodConnect {
+		r.RequestURI = ""
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+		return
+	}
+
+	target, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	client, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		target.Close()
+		return
+	}
+	client.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	pipe(client, buf, target)
+}
+
+func pipe(client net.Conn, r io.Reader, target net.Conn) {
+	defer client.Close()
+	defer target.Close()
+	go io.Copy(target, r)
+	io.Copy(client, target)
+}
+
+// serveSOCKS5 минимальный SOCKS5-сервер (RFC 1928/1929) с авторизацией по паролю
+func serveSOCKS5(t *testing.T, user, password string) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handleSOCKS5(conn, user, password)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func handleSOCKS5(conn net.Conn, user, password string) {
+	r := bufio.NewReader(conn)
+	readN := func(n int) []byte {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			return nil
+		}
+		return b
+	}
+
+	greeting := readN(2)
+	if greeting == nil || readN(int(greeting[1])) == nil {
+		conn.Close()
+		return
+	}
+	conn.Write([]byte{5, 2})
+
+	// Авторизация: версия, логин, пароль
+	head := readN(2)
+	if head == nil {
+		conn.Close()
+		return
+	}
+	u := string(readN(int(head[1])))
+	plen := readN(1)
+	p := string(readN(int(plen[0])))
+	if u != user || p != password {
+		conn.Write([]byte{1, 1})
+		conn.Close()
+		return
+	}
+	conn.Write([]byte{1, 0})
+
+	req := readN(4)
+	var host string
+	switch req[3] {
+	case 1:
+		host = net.IP(readN(4)).String()
+	case 3:
+		n := readN(1)
+		host = string(readN(int(n[0])))
+	case 4:
+		host = net.IP(readN(16)).String()
+	}
+	port := binary.BigEndian.Uint16(readN(2))
+
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(int(port))))
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		conn.Close()
+		return
+	}
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	pipe(conn, r, target)
+}
+
+// get загружает url через соединение, открытое прокси
+func get(t *testing.T, pr *Proxy, url string) (string, error) {
+	transport := &http.Transport{DialContext: pr.DialContext}
+	defer transport.CloseIdleConnections()
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	return string(body), err
+}
+
+func TestProxyDial(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer site.Close()
+
+	t.Run("HTTP CONNECT with auth", func(t *testing.T) {
+		stub := newTestConnectProxy(t, "user", "secret")
+		pr, err := Parse("http://user:secret@" + stub.addr)
+		require.NoError(t, err)
+
+		body, err := get(t, pr, site.URL)
+		require.NoError(t, err)
+		assert.Equal(t, "ok", body)
+		assert.Equal(t, int64(1), atomic.LoadInt64(&stub.requests))
+		assert.Equal(t, "http://user:xxxxx@"+stub.addr, pr.String())
+	})
+
+	t.Run("wrong credentials", func(t *testing.T) {
+		stub := newTestConnectProxy(t, "user", "secret")
+		pr, err := Parse("http://user:wrong@" + stub.addr)
+		require.NoError(t, err)
+
+		_, err = pr.DialContext(context.Background(), "tcp", site.Listener.Addr().String())
+		var status *StatusError
+		require.True(t, errors.As(err, &status))
+		assert.Equal(t, http.StatusProxyAuthRequired, status.Status)
+	})
+
+	t.Run("SOCKS5 with auth", func(t *testing.T) {
+		addr := serveSOCKS5(t, "user", "secret")
+		pr, err := Parse("socks5://user:secret@" + addr)
+		require.NoError(t, err)
+
+		body, err := get(t, pr, site.URL)
+		require.NoError(t, err)
+		assert.Equal(t, "ok", body)
+
+		bad, err := Parse("socks5://user:wrong@" + addr)
+		require.NoError(t, err)
+		_, err = get(t, bad, site.URL)
+		assert.Error(t, err)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, raw := range []string{"ftp://proxy:21", "http://", "::"} {
+			_, err := Parse(raw)
+			assert.Error(t, err, raw)
+		}
+	})
+}
+
+func TestPool(t *testing.T) {
+	cfg := Config{
+		Proxies:        []string{"http://a.test:3128", "http://b.test:3128"},
+		Domains:        map[string]string{"example.com": "socks5://c.test:1080", "intranet.local": Direct},
+		HealthCheckSec: -1,
+	}
+
+	t.Run("round robin", func(t *testing.T) {
+		pool, err := NewPool(cfg)
+		require.NoError(t, err)
+		defer pool.Close()
+
+		var picked []string
+		for i := 0; i < 3; i++ {
+			pr, err := pool.Pick("site.org")
+			require.NoError(t, err)
+			picked = append(picked, pr.String())
+		}
+		assert.Equal(t, []string{"http://a.test:3128", "http://b.test:3128", "http://a.test:3128"}, picked)
+	})
+
+	t.Run("domain assignment", func(t *testing.T) {
+		pool, err := NewPool(cfg)
+		require.NoError(t, err)
+		defer pool.Close()
+
+		pr, err := pool.Pick("cdn.example.com")
+		require.NoError(t, err)
+		assert.Equal(t, "socks5://c.test:1080", pr.String())
+
+		pr, err = pool.Pick("intranet.local")
+		require.NoError(t, err)
+		assert.Nil(t, pr, "direct domain bypasses the pool")
+	})
+
+	t.Run("ban and unhealthy proxies leave rotation", func(t *testing.T) {
+		pool, err := NewPool(cfg)
+		require.NoError(t, err)
+		defer pool.Close()
+
+		a, b := pool.proxies[0], pool.proxies[1]
+		pool.ReportStatus(a, http.StatusOK)
+		pool.ReportStatus(a, http.StatusForbidden)
+		assert.True(t, a.available(time.Now()), "403 does not ban the proxy by default")
+		pool.ReportStatus(a, http.StatusTooManyRequests)
+		for i := 0; i < 3; i++ {
+			pr, err := pool.Pick("site.org")
+			require.NoError(t, err)
+			assert.Same(t, b, pr)
+		}
+
+		for i := 0; i < 3; i++ {
+			pool.ReportError(b, errors.New("connection refused"))
+		}
+		_, err = pool.Pick("site.org")
+		assert.ErrorIs(t, err, ErrNoProxy)
+
+		// Бан истекает по cooldown
+		a.mu.Lock()
+		a.bannedUntil = time.Now().Add(-time.Second)
+		a.mu.Unlock()
+		pr, err := pool.Pick("site.org")
+		require.NoError(t, err)
+		assert.Same(t, a, pr)
+
+		// Без проверок неисправный прокси возвращается в ротацию по cooldown
+		b.mu.Lock()
+		assert.False(t, b.retryAt.IsZero())
+		b.retryAt = time.Now().Add(-time.Second)
+		b.mu.Unlock()
+		assert.True(t, b.available(time.Now()))
+		b.mu.Lock()
+		assert.Zero(t, b.failures)
+		b.mu.Unlock()
+	})
+
+	t.Run("no proxies", func(t *testing.T) {
+		pool, err := NewPool(Config{})
+		require.NoError(t, err)
+		assert.Nil(t, pool)
+
+		pr, err := pool.Pick("site.org")
+		assert.NoError(t, err)
+		assert.Nil(t, pr)
+		pool.ReportStatus(pr, http.StatusForbidden)
+		pool.Close()
+	})
+
+	t.Run("unknown rotation", func(t *testing.T) {
+		_, err := NewPool(Config{Proxies: cfg.Proxies, Rotation: "sticky"})
+		assert.Error(t, err)
+	})
+}
+
+func TestPoolHealthCheck(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer site.Close()
+
+	good := newTestConnectProxy(t, "", "")
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dead := ln.Addr().String()
+	ln.Close()
+
+	pool, err := NewPool(Config{
+		Proxies:        []string{"http://" + good.addr, "http://" + dead},
+		HealthCheckURL: site.URL,
+		HealthCheckSec: -1,
+	})
+	require.NoError(t, err)
+	defer pool.Close()
+
+	pool.checkAll()
+	assert.True(t, pool.proxies[0].available(time.Now()))
+	assert.False(t, pool.proxies[1].available(time.Now()))
+	assert.Equal(t, int64(1), atomic.LoadInt64(&good.requests))
+}
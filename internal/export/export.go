@@ -0,0 +1,42 @@
+package export
+
+import (
+	"fmt"
+	"io"
+
+	"web_crawler/internal/db"
+)
+
+// Форматы выгрузки
+const (
+	FormatJSONL   = "jsonl"
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+	FormatSQLite  = "sqlite"
+)
+
+// Writer пишет страницы выгрузки по одной. Close дописывает хвост файла
+// (футер Parquet, коммит SQLite) и должен вызываться всегда
+type Writer interface {
+	Write(row *db.ExportRow) error
+	Close() error
+}
+
+// NewWriter создает writer для формата format. SQLite пишется в файл path,
+// остальные форматы - в out
+func NewWriter(format string, out io.Writer, path string) (Writer, error) {
+	switch format {
+	case FormatJSONL:
+		return newJSONLWriter(out), nil
+	case FormatCSV:
+		return newCSVWriter(out), nil
+	case FormatParquet:
+		return newParquetWriter(out), nil
+	case FormatSQLite:
+		if path == "" {
+			return nil, fmt.Errorf("sqlite export requires an output file")
+		}
+		return newSQLiteWriter(path)
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
@@ -0,0 +1,131 @@
+package export
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"web_crawler/internal/db"
+)
+
+func testRows() []*db.ExportRow {
+	crawled := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	return []*db.ExportRow{
+		{
+			RunID:       3,
+			Domain:      "example.com",
+			URL:         "https://example.com",
+			Title:       "Example",
+			TextContent: "Привет, мир",
+			Status:      200,
+			ContentType: "text/html",
+			ContentHash: "abc",
+			CrawledAt:   crawled,
+			Metadata:    map[string]string{"charset": "utf-8"},
+			Links:       []string{"https://example.com/a", "https://other.org"},
+		},
+		{
+			RunID:     3,
+			Domain:    "example.com",
+			URL:       "https://example.com/a",
+			Status:    404,
+			CrawledAt: crawled,
+		},
+	}
+}
+
+func writeAll(t *testing.T, w Writer) {
+	t.Helper()
+	for _, row := range testRows() {
+		require.NoError(t, w.Write(row))
+	}
+	require.NoError(t, w.Close())
+}
+
+func TestJSONLWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatJSONL, &buf, "")
+	require.NoError(t, err)
+	writeAll(t, w)
+
+	dec := json.NewDecoder(&buf)
+	for _, want := range testRows() {
+		var got db.ExportRow
+		require.NoError(t, dec.Decode(&got))
+		assert.Equal(t, want.URL, got.URL)
+		assert.Equal(t, want.Links, got.Links)
+		assert.Equal(t, want.Metadata, got.Metadata)
+	}
+	assert.False(t, dec.More())
+}
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatCSV, &buf, "")
+	require.NoError(t, err)
+	writeAll(t, w)
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+	assert.Equal(t, csvHeader, records[0])
+	assert.Equal(t, "https://example.com", records[1][2])
+	assert.Equal(t, "Привет, мир", records[1][4])
+	assert.Equal(t, `{"charset":"utf-8"}`, records[1][12])
+	assert.Equal(t, "https://example.com/a https://other.org", records[1][13])
+	assert.Equal(t, "404", records[2][5])
+}
+
+func TestParquetWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(FormatParquet, &buf, "")
+	require.NoError(t, err)
+	writeAll(t, w)
+
+	rows, err := parquet.Read[parquetRow](bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	assert.Equal(t, "https://example.com", rows[0].URL)
+	assert.Equal(t, []string{"https://example.com/a", "https://other.org"}, rows[0].Links)
+	assert.Equal(t, int32(404), rows[1].Status)
+	assert.True(t, rows[0].CrawledAt.Equal(testRows()[0].CrawledAt))
+}
+
+func TestSQLiteWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "export.sqlite")
+
+	_, err := NewWriter(FormatSQLite, nil, "")
+	assert.Error(t, err, "sqlite needs a file")
+
+	w, err := NewWriter(FormatSQLite, nil, path)
+	require.NoError(t, err)
+	writeAll(t, w)
+
+	conn, err := sql.Open("sqlite", path)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var pages, links int
+	require.NoError(t, conn.QueryRow("SELECT COUNT(*) FROM pages").Scan(&pages))
+	require.NoError(t, conn.QueryRow(`SELECT COUNT(*) FROM links l JOIN pages p ON p.id = l.page_id
+		WHERE p.url = 'https://example.com'`).Scan(&links))
+	assert.Equal(t, 2, pages)
+	assert.Equal(t, 2, links)
+
+	var metadata string
+	require.NoError(t, conn.QueryRow("SELECT metadata FROM pages WHERE status = 200").Scan(&metadata))
+	assert.Equal(t, `{"charset":"utf-8"}`, metadata)
+}
+
+func TestUnknownFormat(t *testing.T) {
+	_, err := NewWriter("xml", &bytes.Buffer{}, "")
+	assert.Error(t, err)
+}
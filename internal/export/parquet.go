@@ -0,0 +1,92 @@
+package export
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+
+	"web_crawler/internal/db"
+)
+
+// parquetRow схема Parquet-файла
+type parquetRow struct {
+	RunID         int64     `parquet:"run_id"`
+	Domain        string    `parquet:"domain,dict"`
+	URL           string    `parquet:"url"`
+	Title         string    `parquet:"title"`
+	TextContent   string    `parquet:"text_content,zstd"`
+	Status        int32     `parquet:"status"`
+	ContentType   string    `parquet:"content_type,dict"`
+	ContentLength int32     `parquet:"content_length"`
+	Depth         int32     `parquet:"depth"`
+	FetchMs       int64     `parquet:"fetch_ms"`
+	ContentHash   string    `parquet:"content_hash"`
+	CrawledAt     time.Time `parquet:"crawled_at,timestamp(millisecond)"`
+	Metadata      string    `parquet:"metadata,optional,json"`
+	Links         []string  `parquet:"links,list"`
+}
+
+// parquetBatch сколько строк копится перед передачей в parquet.Writer
+const parquetBatch = 1000
+
+type parquetWriter struct {
+	w   *parquet.GenericWriter[parquetRow]
+	buf []parquetRow
+}
+
+func newParquetWriter(out io.Writer) *parquetWriter {
+	return &parquetWriter{
+		w:   parquet.NewGenericWriter[parquetRow](out),
+		buf: make([]parquetRow, 0, parquetBatch),
+	}
+}
+
+func (w *parquetWriter) Write(row *db.ExportRow) error {
+	var metadata string
+	if len(row.Metadata) > 0 {
+		data, err := json.Marshal(row.Metadata)
+		if err != nil {
+			return err
+		}
+		metadata = string(data)
+	}
+
+	w.buf = append(w.buf, parquetRow{
+		RunID:         row.RunID,
+		Domain:        row.Domain,
+		URL:           row.URL,
+		Title:         row.Title,
+		TextContent:   row.TextContent,
+		Status:        int32(row.Status),
+		ContentType:   row.ContentType,
+		ContentLength: int32(row.ContentLength),
+		Depth:         int32(row.Depth),
+		FetchMs:       row.FetchMs,
+		ContentHash:   row.ContentHash,
+		CrawledAt:     row.CrawledAt,
+		Metadata:      metadata,
+		Links:         row.Links,
+	})
+	if len(w.buf) >= parquetBatch {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *parquetWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	_, err := w.w.Write(w.buf)
+	w.buf = w.buf[:0]
+	return err
+}
+
+func (w *parquetWriter) Close() error {
+	if err := w.flush(); err != nil {
+		return err
+	}
+	return w.w.Close()
+}
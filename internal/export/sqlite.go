@@ -0,0 +1,132 @@
+package export
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	_ "modernc.org/sqlite"
+
+	"web_crawler/internal/db"
+)
+
+const sqliteSchema = `CREATE TABLE pages (
+	id INTEGER PRIMARY KEY,
+	run_id INTEGER,
+	domain TEXT NOT NULL,
+	url TEXT NOT NULL,
+	title TEXT,
+	text_content TEXT,
+	status INTEGER,
+	content_type TEXT,
+	content_length INTEGER,
+	depth INTEGER,
+	fetch_ms INTEGER,
+	content_hash TEXT,
+	crawled_at TEXT NOT NULL,
+	metadata TEXT
+);
+CREATE TABLE links (
+	page_id INTEGER NOT NULL REFERENCES pages(id),
+	target_url TEXT NOT NULL
+);
+CREATE INDEX idx_pages_url ON pages(url);
+CREATE INDEX idx_links_page ON links(page_id);`
+
+// sqliteCommitEvery через сколько страниц фиксируется транзакция
+const sqliteCommitEvery = 10000
+
+type sqliteWriter struct {
+	db    *sql.DB
+	tx    *sql.Tx
+	page  *sql.Stmt
+	link  *sql.Stmt
+	count int
+}
+
+func newSQLiteWriter(path string) (*sqliteWriter, error) {
+	// Выгрузка всегда создает файл заново
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	conn, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite file: %v", err)
+	}
+	if _, err := conn.Exec(sqliteSchema); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to create sqlite schema: %v", err)
+	}
+
+	w := &sqliteWriter{db: conn}
+	if err := w.begin(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *sqliteWriter) begin() error {
+	var err error
+	if w.tx, err = w.db.Begin(); err != nil {
+		return err
+	}
+	w.page, err = w.tx.Prepare(`INSERT INTO pages (run_id, domain, url, title, text_content, status, content_type,
+		content_length, depth, fetch_ms, content_hash, crawled_at, metadata)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	w.link, err = w.tx.Prepare(`INSERT INTO links (page_id, target_url) VALUES (?, ?)`)
+	return err
+}
+
+func (w *sqliteWriter) Write(row *db.ExportRow) error {
+	var metadata interface{}
+	if len(row.Metadata) > 0 {
+		data, err := json.Marshal(row.Metadata)
+		if err != nil {
+			return err
+		}
+		metadata = string(data)
+	}
+
+	res, err := w.page.Exec(row.RunID, row.Domain, row.URL, row.Title, row.TextContent, row.Status,
+		row.ContentType, row.ContentLength, row.Depth, row.FetchMs, row.ContentHash,
+		row.CrawledAt.Format(time.RFC3339Nano), metadata)
+	if err != nil {
+		return err
+	}
+
+	if len(row.Links) > 0 {
+		pageID, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		for _, link := range row.Links {
+			if _, err := w.link.Exec(pageID, link); err != nil {
+				return err
+			}
+		}
+	}
+
+	w.count++
+	if w.count%sqliteCommitEvery == 0 {
+		if err := w.tx.Commit(); err != nil {
+			return err
+		}
+		return w.begin()
+	}
+	return nil
+}
+
+func (w *sqliteWriter) Close() error {
+	err := w.tx.Commit()
+	if cerr := w.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
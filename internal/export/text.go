@@ -0,0 +1,84 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"web_crawler/internal/db"
+)
+
+type jsonlWriter struct {
+	enc *json.Encoder
+}
+
+func newJSONLWriter(out io.Writer) *jsonlWriter {
+	return &jsonlWriter{enc: json.NewEncoder(out)}
+}
+
+func (w *jsonlWriter) Write(row *db.ExportRow) error {
+	return w.enc.Encode(row)
+}
+
+func (w *jsonlWriter) Close() error {
+	return nil
+}
+
+var csvHeader = []string{"run_id", "domain", "url", "title", "text_content", "status", "content_type",
+	"content_length", "depth", "fetch_ms", "content_hash", "crawled_at", "metadata", "links"}
+
+type csvWriter struct {
+	cw     *csv.Writer
+	header bool
+}
+
+func newCSVWriter(out io.Writer) *csvWriter {
+	return &csvWriter{cw: csv.NewWriter(out)}
+}
+
+// Write пишет страницу; metadata - JSON-объект, links - ссылки через пробел
+func (w *csvWriter) Write(row *db.ExportRow) error {
+	if !w.header {
+		w.header = true
+		if err := w.cw.Write(csvHeader); err != nil {
+			return err
+		}
+	}
+
+	metadata := ""
+	if len(row.Metadata) > 0 {
+		data, err := json.Marshal(row.Metadata)
+		if err != nil {
+			return err
+		}
+		metadata = string(data)
+	}
+
+	return w.cw.Write([]string{
+		strconv.FormatInt(row.RunID, 10),
+		row.Domain,
+		row.URL,
+		row.Title,
+		row.TextContent,
+		strconv.Itoa(row.Status),
+		row.ContentType,
+		strconv.Itoa(row.ContentLength),
+		strconv.Itoa(row.Depth),
+		strconv.FormatInt(row.FetchMs, 10),
+		row.ContentHash,
+		row.CrawledAt.Format(time.RFC3339),
+		metadata,
+		strings.Join(row.Links, " "),
+	})
+}
+
+func (w *csvWriter) Close() error {
+	if !w.header {
+		w.cw.Write(csvHeader)
+	}
+	w.cw.Flush()
+	return w.cw.Error()
+}
@@ -0,0 +1,70 @@
+package identity
+
+import (
+	"net/http"
+	"strings"
+)
+
+// redacted заменяет значения заголовков в снимке настроек
+const redacted = "xxxxx"
+
+// DefaultUserAgent подставляется, если user_agent не задан: по нему сайт узнает краулер
+const DefaultUserAgent = "Mozilla/5.0 (compatible; web_crawler/1.0)"
+
+// Config как краулер представляется сайтам
+type Config struct {
+	// UserAgent строка User-Agent, по умолчанию DefaultUserAgent
+	UserAgent string `json:"user_agent"`
+	// From адрес для связи с владельцем краулера, заголовок From
+	From string `json:"from"`
+	// ContactURL страница с описанием краулера, добавляется в User-Agent
+	ContactURL string `json:"contact_url"`
+	// AcceptLanguage значение Accept-Language
+	AcceptLanguage string `json:"accept_language"`
+	// Headers дополнительные заголовки каждого запроса
+	Headers map[string]string `json:"headers"`
+	// CookiesFile файл cookies.txt (формат Netscape), cookie из которого загружаются при старте
+	CookiesFile string `json:"cookies_file"`
+}
+
+// UserAgentString строка User-Agent вместе с адресом страницы краулера
+func (c Config) UserAgentString() string {
+	ua := c.UserAgent
+	if ua == "" {
+		ua = DefaultUserAgent
+	}
+	if c.ContactURL != "" && !strings.Contains(ua, c.ContactURL) {
+		ua += " (+" + c.ContactURL + ")"
+	}
+	return ua
+}
+
+// Header заголовки, которые краулер отправляет с каждым запросом, включая User-Agent
+func (c Config) Header() http.Header {
+	h := make(http.Header)
+	for k, v := range c.Headers {
+		h.Set(k, v)
+	}
+	h.Set("User-Agent", c.UserAgentString())
+	if c.From != "" {
+		h.Set("From", c.From)
+	}
+	if c.AcceptLanguage != "" {
+		h.Set("Accept-Language", c.AcceptLanguage)
+	}
+	return h
+}
+
+// Redacted копия настроек без значений дополнительных заголовков: в них бывают ключи API
+// и cookie. Используется для сохранения вместе с запуском
+func (c Config) Redacted() Config {
+	if c.Headers == nil {
+		return c
+	}
+	headers := make(map[string]string, len(c.Headers))
+	for k := range c.Headers {
+		headers[k] = redacted
+	}
+	c.Headers = headers
+	return c
+}
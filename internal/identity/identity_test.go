@@ -0,0 +1,150 @@
+package identity
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigHeader(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		h := Config{}.Header()
+		assert.Equal(t, DefaultUserAgent, h.Get("User-Agent"))
+		assert.Empty(t, h.Get("From"))
+		assert.Empty(t, h.Get("Accept-Language"))
+	})
+
+	t.Run("full identity", func(t *testing.T) {
+		cfg := Config{
+			UserAgent:      "MyCrawler/2.0",
+			From:           "crawler@example.com",
+			ContactURL:     "https://example.com/bot",
+			AcceptLanguage: "ru-RU,ru;q=0.9,en;q=0.8",
+			Headers:        map[string]string{"x-purpose": "research", "User-Agent": "ignored"},
+		}
+		h := cfg.Header()
+		assert.Equal(t, "MyCrawler/2.0 (+https://example.com/bot)", h.Get("User-Agent"))
+		assert.Equal(t, "crawler@example.com", h.Get("From"))
+		assert.Equal(t, "ru-RU,ru;q=0.9,en;q=0.8", h.Get("Accept-Language"))
+		assert.Equal(t, "research", h.Get("X-Purpose"))
+	})
+
+	t.Run("contact URL already in user agent", func(t *testing.T) {
+		cfg := Config{UserAgent: "Bot/1.0 (+https://example.com/bot)", ContactURL: "https://example.com/bot"}
+		assert.Equal(t, cfg.UserAgent, cfg.UserAgentString())
+	})
+}
+
+func TestConfigRedacted(t *testing.T) {
+	cfg := Config{
+		UserAgent: "MyCrawler/2.0",
+		Headers:   map[string]string{"X-Api-Key": "secret"},
+	}
+	r := cfg.Redacted()
+	assert.Equal(t, map[string]string{"X-Api-Key": redacted}, r.Headers)
+	assert.Equal(t, "MyCrawler/2.0", r.UserAgent)
+	// Исходные настройки не меняются
+	assert.Equal(t, "secret", cfg.Headers["X-Api-Key"])
+	assert.Nil(t, Config{}.Redacted().Headers)
+}
+
+func mustURL(t *testing.T, raw string) *url.URL {
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	return u
+}
+
+func names(cookies []*http.Cookie) []string {
+	out := make([]string, len(cookies))
+	for i, c := range cookies {
+		out[i] = c.Name + "=" + c.Value
+	}
+	return out
+}
+
+func TestJar(t *testing.T) {
+	t.Run("domain, path and secure matching", func(t *testing.T) {
+		jar := NewJar()
+		jar.SetCookies(mustURL(t, "https://shop.example.com/cart/items"), []*http.Cookie{
+			{Name: "host", Value: "1"},
+			{Name: "domain", Value: "2", Domain: ".example.com", Path: "/"},
+			{Name: "secure", Value: "3", Path: "/", Secure: true},
+			{Name: "deep", Value: "4", Path: "/cart"},
+		})
+
+		assert.Equal(t, []string{"deep=4", "host=1", "domain=2", "secure=3"},
+			names(jar.Cookies(mustURL(t, "https://shop.example.com/cart/x"))))
+		assert.Equal(t, []string{"domain=2"}, names(jar.Cookies(mustURL(t, "http://www.example.com/"))))
+		assert.Equal(t, []string{"domain=2"}, names(jar.Cookies(mustURL(t, "http://shop.example.com/cartoon"))))
+		assert.Empty(t, jar.Cookies(mustURL(t, "https://example.org/")))
+	})
+
+	t.Run("foreign domain and public suffix are rejected", func(t *testing.T) {
+		jar := NewJar()
+		jar.SetCookies(mustURL(t, "https://a.example.co.uk/"), []*http.Cookie{
+			{Name: "suffix", Value: "1", Domain: "co.uk"},
+			{Name: "foreign", Value: "2", Domain: "other.co.uk"},
+		})
+		assert.Empty(t, jar.All())
+	})
+
+	t.Run("expiry and deletion", func(t *testing.T) {
+		jar := NewJar()
+		u := mustURL(t, "https://example.com/")
+		jar.SetCookies(u, []*http.Cookie{
+			{Name: "a", Value: "1", MaxAge: 3600},
+			{Name: "b", Value: "2", Expires: time.Now().Add(-time.Hour)},
+		})
+		assert.Equal(t, []string{"a=1"}, names(jar.Cookies(u)))
+
+		jar.SetCookies(u, []*http.Cookie{{Name: "a", MaxAge: -1}})
+		assert.Empty(t, jar.Cookies(u))
+	})
+
+	t.Run("All and Load round trip", func(t *testing.T) {
+		jar := NewJar()
+		jar.SetCookies(mustURL(t, "https://example.com/app/"), []*http.Cookie{
+			{Name: "sid", Value: "abc", HttpOnly: true, MaxAge: 60},
+			{Name: "lang", Value: "ru", Domain: "example.com", Path: "/"},
+		})
+		all := jar.All()
+		require.Len(t, all, 2)
+		assert.Equal(t, Cookie{Domain: "example.com", Path: "/", Name: "lang", Value: "ru"}, all[0])
+		assert.Equal(t, "/app", all[1].Path)
+		assert.True(t, all[1].HostOnly)
+		assert.True(t, all[1].HttpOnly)
+
+		restored := NewJar()
+		restored.Load(all)
+		assert.Equal(t, all, restored.All())
+		assert.Equal(t, all, restored.Site("www.example.com"))
+	})
+}
+
+func TestParseNetscape(t *testing.T) {
+	data := strings.Join([]string{
+		"# Netscape HTTP Cookie File",
+		"",
+		".example.com\tTRUE\t/\tTRUE\t1893456000\tsid\tabc",
+		"#HttpOnly_shop.example.com\tFALSE\t/cart\tFALSE\t0\tcart\t",
+		"example.org\tFALSE\t/\tFALSE\t0\tempty",
+	}, "\n")
+
+	cookies, err := ParseNetscape(strings.NewReader(data))
+	require.NoError(t, err)
+	assert.Equal(t, []Cookie{
+		{Domain: "example.com", Path: "/", Name: "sid", Value: "abc", Secure: true, Expires: time.Unix(1893456000, 0)},
+		{Domain: "shop.example.com", Path: "/cart", Name: "cart", HttpOnly: true, HostOnly: true},
+		{Domain: "example.org", Path: "/", Name: "empty", HostOnly: true},
+	}, cookies)
+
+	_, err = ParseNetscape(strings.NewReader("example.com\tTRUE\t/\n"))
+	assert.Error(t, err)
+	_, err = ParseNetscape(strings.NewReader("example.com\tTRUE\t/\tFALSE\tsoon\ta\tb\n"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,308 @@
+package identity
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Cookie cookie вместе с атрибутами, которые нужны для сохранения и передачи в Chrome
+type Cookie struct {
+	Domain string
+	Path   string
+	Name   string
+	Value  string
+	// Expires нулевое значение у сессионной cookie
+	Expires  time.Time
+	Secure   bool
+	HttpOnly bool
+	// HostOnly cookie отправляется только самому Domain, без поддоменов
+	HostOnly bool
+}
+
+func (c *Cookie) expired(now time.Time) bool {
+	return !c.Expires.IsZero() && !c.Expires.After(now)
+}
+
+// matches подходит ли cookie для запроса к host и path
+func (c *Cookie) matches(host, path string, https bool, now time.Time) bool {
+	if c.expired(now) || (c.Secure && !https) {
+		return false
+	}
+	if c.HostOnly {
+		if host != c.Domain {
+			return false
+		}
+	} else if host != c.Domain && !strings.HasSuffix(host, "."+c.Domain) {
+		return false
+	}
+	return pathMatch(c.Path, path)
+}
+
+// pathMatch сопоставление пути по RFC 6265, 5.1.4
+func pathMatch(cookiePath, path string) bool {
+	if path == cookiePath {
+		return true
+	}
+	if !strings.HasPrefix(path, cookiePath) {
+		return false
+	}
+	return strings.HasSuffix(cookiePath, "/") || path[len(cookiePath)] == '/'
+}
+
+// Jar http.CookieJar краулера. Cookie хранятся по сайтам (домен второго уровня
+// относительно публичного суффикса) и переживают перезапуск через All и Load
+type Jar struct {
+	mu sync.Mutex
+	// sites сайт -> ключ domain;path;name -> cookie
+	sites map[string]map[string]Cookie
+}
+
+// NewJar создает пустой jar
+func NewJar() *Jar {
+	return &Jar{sites: make(map[string]map[string]Cookie)}
+}
+
+// site ключ хранения для хоста; у IP-адресов это сам адрес
+func site(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	if s, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return s
+	}
+	return host
+}
+
+func cookieKey(c Cookie) string {
+	return c.Domain + ";" + c.Path + ";" + c.Name
+}
+
+func canonicalHost(u *url.URL) string {
+	return strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+}
+
+// SetCookies сохраняет cookie из ответа на u
+func (j *Jar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	host := canonicalHost(u)
+	if host == "" {
+		return
+	}
+	now := time.Now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, hc := range cookies {
+		c := Cookie{
+			Domain:   host,
+			Path:     hc.Path,
+			Name:     hc.Name,
+			Value:    hc.Value,
+			Secure:   hc.Secure,
+			HttpOnly: hc.HttpOnly,
+			HostOnly: true,
+		}
+		if hc.Domain != "" {
+			domain := strings.ToLower(strings.TrimPrefix(hc.Domain, "."))
+			// Cookie на чужой домен или на публичный суффикс вроде co.uk не принимается
+			if domain != host && (net.ParseIP(host) != nil || !strings.HasSuffix(host, "."+domain)) {
+				continue
+			}
+			if suffix, _ := publicsuffix.PublicSuffix(domain); suffix == domain && domain != host {
+				continue
+			}
+			c.Domain = domain
+			c.HostOnly = net.ParseIP(host) != nil
+		}
+		if c.Path == "" || c.Path[0] != '/' {
+			c.Path = defaultPath(u.Path)
+		}
+		switch {
+		case hc.MaxAge < 0:
+			c.Expires = now
+		case hc.MaxAge > 0:
+			c.Expires = now.Add(time.Duration(hc.MaxAge) * time.Second)
+		default:
+			c.Expires = hc.Expires
+		}
+		j.put(c, now)
+	}
+}
+
+// put сохраняет cookie или удаляет ее, если срок истек; вызывается под j.mu
+func (j *Jar) put(c Cookie, now time.Time) {
+	key := site(c.Domain)
+	entries := j.sites[key]
+	if c.expired(now) {
+		delete(entries, cookieKey(c))
+		return
+	}
+	if entries == nil {
+		entries = make(map[string]Cookie)
+		j.sites[key] = entries
+	}
+	entries[cookieKey(c)] = c
+}
+
+// defaultPath путь cookie без атрибута Path по RFC 6265, 5.1.4
+func defaultPath(path string) string {
+	if path == "" || path[0] != '/' {
+		return "/"
+	}
+	i := strings.LastIndex(path, "/")
+	if i == 0 {
+		return "/"
+	}
+	return path[:i]
+}
+
+// Cookies возвращает cookie для запроса на u, более длинные пути первыми
+func (j *Jar) Cookies(u *url.URL) []*http.Cookie {
+	host := canonicalHost(u)
+	path := u.Path
+	if path == "" {
+		path = "/"
+	}
+	https := u.Scheme == "https" || u.Scheme == "wss"
+	now := time.Now()
+
+	j.mu.Lock()
+	var matched []Cookie
+	for _, c := range j.sites[site(host)] {
+		if c.matches(host, path, https, now) {
+			matched = append(matched, c)
+		}
+	}
+	j.mu.Unlock()
+
+	sort.SliceStable(matched, func(a, b int) bool {
+		if len(matched[a].Path) != len(matched[b].Path) {
+			return len(matched[a].Path) > len(matched[b].Path)
+		}
+		return matched[a].Name < matched[b].Name
+	})
+	out := make([]*http.Cookie, len(matched))
+	for i, c := range matched {
+		out[i] = &http.Cookie{Name: c.Name, Value: c.Value}
+	}
+	return out
+}
+
+// Site cookie сайта, к которому относится host, включая cookie его поддоменов
+func (j *Jar) Site(host string) []Cookie {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	now := time.Now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var out []Cookie
+	for _, c := range j.sites[site(host)] {
+		if !c.expired(now) {
+			out = append(out, c)
+		}
+	}
+	sortCookies(out)
+	return out
+}
+
+// All все cookie с неистекшим сроком, включая сессионные: обход продолжает сессию между запусками
+func (j *Jar) All() []Cookie {
+	now := time.Now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var out []Cookie
+	for _, entries := range j.sites {
+		for _, c := range entries {
+			if !c.expired(now) {
+				out = append(out, c)
+			}
+		}
+	}
+	sortCookies(out)
+	return out
+}
+
+// Load добавляет cookie, например сохраненные прошлым запуском или прочитанные из cookies.txt
+func (j *Jar) Load(cookies []Cookie) {
+	now := time.Now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range cookies {
+		c.Domain = strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+		if c.Domain == "" || c.Name == "" {
+			continue
+		}
+		if c.Path == "" {
+			c.Path = "/"
+		}
+		j.put(c, now)
+	}
+}
+
+func sortCookies(cookies []Cookie) {
+	sort.Slice(cookies, func(a, b int) bool {
+		return cookieKey(cookies[a]) < cookieKey(cookies[b])
+	})
+}
+
+// httpOnlyPrefix так curl и браузерные расширения помечают HttpOnly-cookie в cookies.txt
+const httpOnlyPrefix = "#HttpOnly_"
+
+// ParseNetscape читает cookies.txt в формате Netscape: domain, include subdomains,
+// path, secure, expires (unix-время, 0 - сессионная), name, value через табуляцию
+func ParseNetscape(r io.Reader) ([]Cookie, error) {
+	var cookies []Cookie
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := strings.HasPrefix(text, httpOnlyPrefix)
+		if httpOnly {
+			text = text[len(httpOnlyPrefix):]
+		}
+		if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		fields := strings.Split(text, "\t")
+		if len(fields) == 6 {
+			// Пустое значение в некоторых выгрузках обрезается вместе с табуляцией
+			fields = append(fields, "")
+		}
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("cookies.txt line %d: expected 7 fields, got %d", line, len(fields))
+		}
+		expires, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cookies.txt line %d: invalid expires %q", line, fields[4])
+		}
+
+		c := Cookie{
+			Domain:   strings.TrimPrefix(fields[0], "."),
+			HostOnly: !strings.EqualFold(fields[1], "TRUE"),
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			Name:     fields[5],
+			Value:    fields[6],
+			HttpOnly: httpOnly,
+		}
+		if expires > 0 {
+			c.Expires = time.Unix(expires, 0)
+		}
+		cookies = append(cookies, c)
+	}
+	return cookies, scanner.Err()
+}
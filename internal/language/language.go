@@ -0,0 +1,196 @@
+package language
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Unknown язык не определен: текста слишком мало или в нем нет признаков языка
+const Unknown = ""
+
+// minLetters меньше букв недостаточно для определения, тогда используется подсказка
+const minLetters = 20
+
+// sampleRunes сколько символов текста просматривается
+const sampleRunes = 20000
+
+// script письменность и языки, которые ею пользуются. Первый язык выбирается для письменности
+// без профилей, если подсказка не называет другой
+type script struct {
+	tables []*unicode.RangeTable
+	langs  []string
+}
+
+var scripts = []script{
+	{[]*unicode.RangeTable{unicode.Cyrillic}, []string{"ru", "uk", "be", "bg", "sr", "mk", "kk"}},
+	{[]*unicode.RangeTable{unicode.Latin}, []string{"en", "de", "fr", "es", "it", "pt", "nl", "pl"}},
+	{[]*unicode.RangeTable{unicode.Greek}, []string{"el"}},
+	{[]*unicode.RangeTable{unicode.Arabic}, []string{"ar", "fa", "ur"}},
+	{[]*unicode.RangeTable{unicode.Hebrew}, []string{"he", "yi"}},
+	{[]*unicode.RangeTable{unicode.Han}, []string{"zh", "ja"}},
+	{[]*unicode.RangeTable{unicode.Hiragana, unicode.Katakana}, []string{"ja"}},
+	{[]*unicode.RangeTable{unicode.Hangul}, []string{"ko"}},
+	{[]*unicode.RangeTable{unicode.Thai}, []string{"th"}},
+	{[]*unicode.RangeTable{unicode.Devanagari}, []string{"hi", "mr", "ne"}},
+	{[]*unicode.RangeTable{unicode.Armenian}, []string{"hy"}},
+	{[]*unicode.RangeTable{unicode.Georgian}, []string{"ka"}},
+}
+
+// Индексы иероглифов и каны в scripts
+const (
+	scriptHan  = 5
+	scriptKana = 6
+)
+
+// profile признаки языка: частые служебные слова и буквы, которых нет у соседних языков
+type profile struct {
+	words   map[string]bool
+	letters string
+}
+
+func words(s string) map[string]bool {
+	m := make(map[string]bool)
+	for _, w := range strings.Fields(s) {
+		m[w] = true
+	}
+	return m
+}
+
+var profiles = map[string]profile{
+	"ru": {words("и в не на что с по это как к из для он она так но мы вы то было его же или только уже ещё все при"), "ыэъё"},
+	"uk": {words("і в у не на що з до це як та для від він вона але ми ви є його також або було із при"), "іїєґ"},
+	"be": {words("і у не на што з да гэта як для ад ён яна але мы вы ёсць яго таксама або было"), "ў"},
+	"bg": {words("и в на не да се от за е че с по са като това но ще със му който"), ""},
+	"sr": {words("и у је да се на за од са не су то из као што али"), "јљњћђџ"},
+	"mk": {words("и на во да се од за не со е ќе што како но тоа"), "ѓќѕ"},
+	"en": {words("the and of to in is that for it with as was on are be this by you not or from have at"), ""},
+	"de": {words("der die und das ist nicht mit sich des auf für ein eine dem den zu von im auch wird"), "äöüß"},
+	"fr": {words("le la les et des est un une du en que pour dans pas sur au avec qui ce sont par"), "êèàç"},
+	"es": {words("el la los las de que y en un una es por con para del se no al lo como más"), "ñ"},
+	"it": {words("il di che e la per un una non sono del della con gli le si da al nel è"), ""},
+	"pt": {words("o a os as de que e do da em um uma para com não dos das é se por mais"), "ãõ"},
+	"nl": {words("de het een en van is dat niet op te zijn met voor die er ook aan naar"), ""},
+	"pl": {words("i w z na się nie do to że jest jak o od po przez dla ale są czy"), "ąęłśźżń"},
+}
+
+// Normalize код языка из атрибута lang или заголовка Content-Language: "en-US" -> "en".
+// Для списка берется первый язык; "und", "*" и пустое значение дают Unknown
+func Normalize(tag string) string {
+	tag, _, _ = strings.Cut(tag, ",")
+	tag, _, _ = strings.Cut(tag, ";")
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	tag, _, _ = strings.Cut(strings.ReplaceAll(tag, "_", "-"), "-")
+	if len(tag) < 2 || len(tag) > 3 || tag == "und" {
+		return Unknown
+	}
+	for _, r := range tag {
+		if r < 'a' || r > 'z' {
+			return Unknown
+		}
+	}
+	return tag
+}
+
+// Detect определяет язык текста по письменности, служебным словам и характерным буквам
+// и возвращает код ISO 639-1. hints - атрибут lang и Content-Language страницы: подсказка
+// используется для короткого текста и выбирает между языками одной письменности, если по тексту
+// ее язык набирает не меньше половины очков лучшего. Текст, явно написанный на другом языке,
+// подсказку перевешивает
+func Detect(text string, hints ...string) string {
+	hint := Unknown
+	for _, h := range hints {
+		if hint = Normalize(h); hint != Unknown {
+			break
+		}
+	}
+
+	counts := make([]int, len(scripts))
+	scores := make(map[string]int)
+	letters := 0
+	var word strings.Builder
+	flush := func() {
+		if word.Len() == 0 {
+			return
+		}
+		w := strings.ToLower(word.String())
+		word.Reset()
+		for lang, p := range profiles {
+			if p.words[w] {
+				scores[lang]++
+			}
+			if p.letters != "" && strings.ContainsAny(w, p.letters) {
+				scores[lang]++
+			}
+		}
+	}
+
+	n := 0
+	for _, r := range text {
+		if n++; n > sampleRunes {
+			break
+		}
+		if !unicode.IsLetter(r) {
+			flush()
+			continue
+		}
+		letters++
+		word.WriteRune(r)
+		for i, s := range scripts {
+			if unicode.In(r, s.tables...) {
+				counts[i]++
+				break
+			}
+		}
+	}
+	flush()
+
+	if letters < minLetters {
+		return hint
+	}
+	best := 0
+	for i, c := range counts {
+		if c > counts[best] {
+			best = i
+		}
+	}
+	if counts[best] == 0 {
+		return hint
+	}
+	// Японский текст пишется иероглифами вперемешку с каной
+	if best == scriptHan && counts[scriptKana]*10 >= counts[scriptHan] {
+		best = scriptKana
+	}
+	return pick(scripts[best].langs, scores, hint)
+}
+
+// pick выбирает язык письменности по очкам профилей
+func pick(langs []string, scores map[string]int, hint string) string {
+	hinted := false
+	for _, l := range langs {
+		hinted = hinted || l == hint
+	}
+
+	top, profiled := Unknown, false
+	for _, l := range langs {
+		if _, ok := profiles[l]; !ok {
+			continue
+		}
+		profiled = true
+		if top == Unknown || scores[l] > scores[top] {
+			top = l
+		}
+	}
+	switch {
+	case !profiled:
+		// Письменность без профилей: решает подсказка или основной язык письменности
+		if hinted {
+			return hint
+		}
+		return langs[0]
+	case hinted && scores[hint]*2 >= scores[top]:
+		return hint
+	case scores[top] == 0:
+		return Unknown
+	}
+	return top
+}
@@ -0,0 +1,50 @@
+package language
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalize(t *testing.T) {
+	for tag, want := range map[string]string{
+		"en-US":      "en",
+		"RU":         "ru",
+		" pt_BR ":    "pt",
+		"de, en;q=1": "de",
+		"und":        Unknown,
+		"*":          Unknown,
+		"":           Unknown,
+		"english":    Unknown,
+	} {
+		assert.Equal(t, want, Normalize(tag), tag)
+	}
+}
+
+func TestDetect(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		text  string
+		hints []string
+		want  string
+	}{
+		{"russian", "Погода в Москве на выходные: синоптики обещают, что снег будет идти только в субботу", nil, "ru"},
+		{"ukrainian", "Погода у Києві на вихідні: синоптики обіцяють, що сніг піде лише в суботу, а також буде вітер", nil, "uk"},
+		{"english", "The weather in London this weekend is expected to be cold, and it will rain on Saturday", nil, "en"},
+		{"german", "Das Wetter in Berlin ist am Wochenende kalt und es wird auch nicht besser, sagt der Dienst", nil, "de"},
+		{"french", "Le temps à Paris sera froid pendant le week-end et il va pleuvoir dans la soirée avec du vent", nil, "fr"},
+		{"greek", "Ο καιρός στην Αθήνα το Σαββατοκύριακο θα είναι κρύος", nil, "el"},
+		{"japanese", "東京の天気は週末に寒くなり、土曜日には雨が降るでしょう", nil, "ja"},
+		{"chinese", "北京周末天气寒冷，星期六有雨，星期日转晴，气温回升", nil, "zh"},
+		{"text outweighs hint", "Погода в Москве на выходные: синоптики обещают, что снег будет идти только в субботу", []string{"en"}, "ru"},
+		{"hint for short text", "Главная", []string{"", "ru-RU"}, "ru"},
+		{"serbian", "Београд је главни град Србије и највећи град у земљи", nil, "sr"},
+		{"hint within script", "Кратко описание товара, цена указана в рублях", []string{"bg"}, "bg"},
+		{"no evidence", "Lorem ipsum dolor sit amet consectetur adipiscing", nil, Unknown},
+		{"empty", "", nil, Unknown},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, Detect(tc.text, tc.hints...))
+		})
+	}
+}
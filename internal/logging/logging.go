@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Имена полей, общие для всех пакетов
+const (
+	KeyWorker  = "worker"
+	KeyURL     = "url"
+	KeyHost    = "host"
+	KeyRunID   = "run_id"
+	KeyAttempt = "attempt"
+	KeyError   = "err"
+)
+
+// Config настройки логирования из settings.json
+type Config struct {
+	// Level debug, info, warn или error; по умолчанию info
+	Level string `json:"level"`
+	// Format text или json; по умолчанию text
+	Format string `json:"format"`
+}
+
+// New создает логгер по настройкам
+func New(cfg Config, w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if cfg.Level != "" {
+		if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+			return nil, fmt.Errorf("invalid log level %q", cfg.Level)
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	switch strings.ToLower(cfg.Format) {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q", cfg.Format)
+	}
+}
+
+type ctxKey struct{}
+
+// With возвращает контекст, логгер которого дополнен полями args
+func With(ctx context.Context, args ...any) context.Context {
+	return context.WithValue(ctx, ctxKey{}, FromContext(ctx).With(args...))
+}
+
+// FromContext возвращает логгер из контекста или slog.Default()
+func FromContext(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(ctxKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// Err поле с ошибкой
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
@@ -0,0 +1,57 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("json with level", func(t *testing.T) {
+		var buf bytes.Buffer
+		logger, err := New(Config{Level: "warn", Format: "json"}, &buf)
+		require.NoError(t, err)
+
+		logger.Info("skipped")
+		logger.Warn("written", KeyHost, "example.com")
+
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+		assert.Equal(t, "written", entry["msg"])
+		assert.Equal(t, "WARN", entry["level"])
+		assert.Equal(t, "example.com", entry[KeyHost])
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := New(Config{Level: "loud"}, &bytes.Buffer{})
+		assert.Error(t, err)
+
+		_, err = New(Config{Format: "xml"}, &bytes.Buffer{})
+		assert.Error(t, err)
+	})
+}
+
+func TestContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(Config{Format: "json"}, &buf)
+	require.NoError(t, err)
+
+	ctx := With(context.Background())
+	assert.NotNil(t, FromContext(ctx))
+
+	ctx = context.WithValue(context.Background(), ctxKey{}, logger)
+	ctx = With(ctx, KeyRunID, 3)
+	ctx = With(ctx, KeyURL, "https://example.com")
+	FromContext(ctx).Error("fetch failed", Err(errors.New("timeout")))
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, float64(3), entry[KeyRunID])
+	assert.Equal(t, "https://example.com", entry[KeyURL])
+	assert.Equal(t, "timeout", entry[KeyError])
+}
@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "crawler"
+
+var (
+	// PagesFetched загруженные страницы по коду ответа и хосту; ошибки загрузки идут со status="error"
+	PagesFetched = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pages_fetched_total",
+		Help:      "Pages fetched by status code and host.",
+	}, []string{"status", "host"})
+
+	FetchDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "fetch_duration_seconds",
+		Help:      "Full page fetch latency including DNS and browser start.",
+		Buckets:   []float64{0.5, 1, 2, 3, 4, 5, 7.5, 10, 15, 20, 30},
+	})
+
+	// ActiveBrowsers число запущенных в данный момент экземпляров Chrome
+	ActiveBrowsers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_browsers",
+		Help:      "Chrome instances currently running.",
+	})
+
+	DNSDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "dns_lookup_duration_seconds",
+		Help:      "Upstream DNS lookup latency (cache misses only).",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	})
+
+	// DNSCache обращения к кешу DNS: result="memory", "redis" или "miss"
+	DNSCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dns_cache_requests_total",
+		Help:      "DNS cache lookups by result.",
+	}, []string{"result"})
+
+	// DNSCacheDegraded 1, пока Redis недоступен и кеш DNS работает только в памяти
+	DNSCacheDegraded = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dns_cache_degraded",
+		Help:      "Whether the DNS cache works without Redis.",
+	})
+
+	// DNSUpstreamRequests запросы к DNS-серверу: result="success" или "error"
+	DNSUpstreamRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dns_upstream_requests_total",
+		Help:      "DNS upstream queries by upstream and result.",
+	}, []string{"upstream", "result"})
+
+	DNSUpstreamLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "dns_upstream_duration_seconds",
+		Help:      "DNS upstream query latency by upstream.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"upstream"})
+
+	// DNSUpstreamOpen 1, если circuit breaker сервера разомкнут
+	DNSUpstreamOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dns_upstream_open",
+		Help:      "Whether the DNS upstream circuit breaker is open.",
+	}, []string{"upstream"})
+
+	// ProxyBans баны прокси выхода по коду ответа
+	ProxyBans = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_bans_total",
+		Help:      "Egress proxy bans by proxy.",
+	}, []string{"proxy"})
+
+	// ProxyHealthy 1, если прокси выхода прошел последнюю проверку
+	ProxyHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "proxy_healthy",
+		Help:      "Whether the egress proxy passed its last health check.",
+	}, []string{"proxy"})
+
+	// Logins выполненные сценарии входа по домену и результату
+	Logins = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Scripted login runs by domain and result.",
+	}, []string{"domain", "result"})
+
+	// Captures снимки страниц по виду (screenshot, pdf) и результату
+	Captures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "captures_total",
+		Help:      "Page screenshots and PDF captures by kind and result.",
+	}, []string{"kind", "result"})
+
+	// FetchLimits загрузки, прерванные ограничением: limit="body_size", "ttfb_timeout" и т.д.
+	FetchLimits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "fetch_limits_total",
+		Help:      "Fetches aborted by a size, content type, time or redirect limit.",
+	}, []string{"limit"})
+
+	// DBDuration длительность запросов к базе по операции
+	DBDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Database query latency by operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+	}, []string{"op"})
+
+	// QueueDepth число URL, ожидающих воркера
+	QueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "URLs waiting in the crawl queue.",
+	})
+
+	// Retries повторные попытки по операции
+	Retries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retries_total",
+		Help:      "Retried operations by operation name.",
+	}, []string{"op"})
+)
+
+// ObservePage учитывает загрузку страницы; при ошибке код ответа не известен
+func ObservePage(host string, status int, err error, d time.Duration) {
+	label := strconv.Itoa(status)
+	if err != nil {
+		label = "error"
+	}
+	PagesFetched.WithLabelValues(label, host).Inc()
+	FetchDuration.Observe(d.Seconds())
+}
+
+// ObserveDB возвращает функцию, которая записывает длительность операции op:
+//
+//	defer metrics.ObserveDB("insert_batch")()
+func ObserveDB(op string) func() {
+	start := time.Now()
+	return func() {
+		DBDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler отдает метрики в формате Prometheus
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestObservePage(t *testing.T) {
+	ObservePage("example.com", 200, nil, time.Second)
+	ObservePage("example.com", 0, errors.New("timeout"), time.Second)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(PagesFetched.WithLabelValues("200", "example.com")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(PagesFetched.WithLabelValues("error", "example.com")))
+}
+
+func TestObserveDB(t *testing.T) {
+	ObserveDB("test_op")()
+	assert.Equal(t, 1, testutil.CollectAndCount(DBDuration, "crawler_db_query_duration_seconds"))
+}
+
+func TestHandler(t *testing.T) {
+	QueueDepth.Set(3)
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	body, err := io.ReadAll(rec.Body)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), "crawler_queue_depth 3")
+	assert.Contains(t, string(body), "crawler_active_browsers")
+}
@@ -0,0 +1,70 @@
+package phash
+
+import (
+	"fmt"
+	"image"
+	"math/bits"
+	"strconv"
+)
+
+// Size число бит хеша
+const Size = 64
+
+// DHash разностный перцептивный хеш (dHash): картинка сжимается до 9x8 в оттенках серого,
+// и каждый бит показывает, светлее ли пиксель своего соседа справа. Хеш почти не меняется
+// от сжатия, масштаба и мелких правок, поэтому похожие снимки отличаются на несколько бит.
+// Результат - 16 шестнадцатеричных символов
+func DHash(img image.Image) string {
+	gray := shrink(img, 9, 8)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if gray[y][x] > gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return fmt.Sprintf("%016x", hash)
+}
+
+// shrink средняя яркость по блокам: картинка делится на w x h прямоугольников
+func shrink(img image.Image, w, h int) [][]float64 {
+	b := img.Bounds()
+	out := make([][]float64, h)
+	for y := 0; y < h; y++ {
+		out[y] = make([]float64, w)
+		y0 := b.Min.Y + y*b.Dy()/h
+		y1 := max(b.Min.Y+(y+1)*b.Dy()/h, y0+1)
+		for x := 0; x < w; x++ {
+			x0 := b.Min.X + x*b.Dx()/w
+			x1 := max(b.Min.X+(x+1)*b.Dx()/w, x0+1)
+
+			var sum float64
+			for py := y0; py < y1 && py < b.Max.Y; py++ {
+				for px := x0; px < x1 && px < b.Max.X; px++ {
+					r, g, bl, _ := img.At(px, py).RGBA()
+					// Яркость по ITU-R BT.601
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(bl)
+				}
+			}
+			out[y][x] = sum / float64((y1-y0)*(x1-x0))
+		}
+	}
+	return out
+}
+
+// Distance расстояние Хэмминга между хешами: 0 - одинаковые снимки, 64 - совсем разные.
+// Для некорректных хешей возвращает ошибку
+func Distance(a, b string) (int, error) {
+	x, err := strconv.ParseUint(a, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hash %q", a)
+	}
+	y, err := strconv.ParseUint(b, 16, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid hash %q", b)
+	}
+	return bits.OnesCount64(x ^ y), nil
+}
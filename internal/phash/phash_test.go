@@ -0,0 +1,53 @@
+package phash
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gradient картинка с яркостью, убывающей слева направо, и темной полосой block
+func gradient(w, h int, block image.Rectangle) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(255 - x*255/w)
+			if (image.Point{x, y}).In(block) {
+				v = 0
+			}
+			img.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	base := DHash(gradient(900, 1600, image.Rectangle{}))
+	assert.Equal(t, "ffffffffffffffff", base)
+	assert.Len(t, DHash(image.NewGray(image.Rect(0, 0, 3, 2))), 16, "smaller than the hash grid")
+
+	t.Run("scaled copy is the same", func(t *testing.T) {
+		d, err := Distance(base, DHash(gradient(450, 800, image.Rectangle{})))
+		require.NoError(t, err)
+		assert.Equal(t, 0, d)
+	})
+
+	t.Run("changed layout differs", func(t *testing.T) {
+		changed := DHash(gradient(900, 1600, image.Rect(0, 0, 450, 800)))
+		d, err := Distance(base, changed)
+		require.NoError(t, err)
+		assert.Greater(t, d, 10)
+	})
+}
+
+func TestDistance(t *testing.T) {
+	d, err := Distance("0000000000000000", "000000000000000f")
+	require.NoError(t, err)
+	assert.Equal(t, 4, d)
+
+	_, err = Distance("zz", "0")
+	assert.Error(t, err)
+}
@@ -0,0 +1,108 @@
+package report
+
+import (
+	"html/template"
+	"io"
+)
+
+// barView строка графика: ширина столбца в процентах от максимума таблицы
+type barView struct {
+	Key   string
+	Count int
+	Width float64
+}
+
+type tableView struct {
+	Title string
+	Rows  []barView
+}
+
+type htmlView struct {
+	Lang    string
+	Title   string
+	Domain  string
+	Header  []metric
+	Summary []metric
+	Tables  []tableView
+
+	SummaryLabel string
+}
+
+// renderHTML выводит самодостаточную страницу: стили и графики (SVG) встроены
+func renderHTML(w io.Writer, r *Report, lang string) error {
+	view := htmlView{
+		Lang:         lang,
+		Title:        label(lang, "title"),
+		Domain:       r.Domain,
+		Header:       r.header(lang),
+		SummaryLabel: label(lang, "summary"),
+	}
+	for _, m := range r.metrics() {
+		view.Summary = append(view.Summary, metric{label(lang, m.Key), m.Value})
+	}
+
+	for _, t := range r.tables() {
+		max := 0
+		for _, row := range t.Rows {
+			if row.Count > max {
+				max = row.Count
+			}
+		}
+
+		tv := tableView{Title: tableLabel(lang, t.Key)}
+		for _, row := range t.Rows {
+			width := 0.0
+			if max > 0 {
+				width = float64(row.Count) / float64(max) * 100
+			}
+			tv.Rows = append(tv.Rows, barView{Key: row.Key, Count: row.Count, Width: width})
+		}
+		view.Tables = append(view.Tables, tv)
+	}
+
+	return htmlTemplate.Execute(w, view)
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}: {{.Domain}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1.5em; }
+td, th { padding: 2px 8px; text-align: left; }
+td.num { text-align: right; }
+.chart td.key { max-width: 40em; overflow: hidden; text-overflow: ellipsis; white-space: nowrap; }
+.chart svg { display: block; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}: {{.Domain}}</h1>
+<ul>
+{{- range .Header}}
+<li><b>{{.Key}}:</b> {{.Value}}</li>
+{{- end}}
+</ul>
+<h2>{{.SummaryLabel}}</h2>
+<table>
+{{- range .Summary}}
+<tr><td>{{.Key}}</td><td class="num">{{.Value}}</td></tr>
+{{- end}}
+</table>
+{{- range .Tables}}
+<h2>{{.Title}}</h2>
+{{- if .Rows}}
+<table class="chart">
+{{- range .Rows}}
+<tr><td class="key" title="{{.Key}}">{{.Key}}</td><td class="num">{{.Count}}</td>
+<td><svg width="300" height="14"><rect width="{{printf "%.1f" .Width}}%" height="14" fill="#4a7bd0"></rect></svg></td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>—</p>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
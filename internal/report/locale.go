@@ -0,0 +1,97 @@
+package report
+
+// labels подписи для человекочитаемых форматов
+var labels = map[string]map[string]string{
+	"ru": {
+		"title":            "Статистика обхода",
+		"domain":           "Домен",
+		"run":              "Запуск",
+		"period":           "Период",
+		"generated_at":     "Сформирован",
+		"summary":          "Сводка",
+		"metric":           "Показатель",
+		"value":            "Значение",
+		"count":            "Количество",
+		"total_pages":      "Общее количество ссылок",
+		"internal_pages":   "Количество внутренних ссылок главного домена",
+		"subdomain_pages":  "Количество страниц на поддоменах",
+		"broken_pages":     "Количество неработающих страниц",
+		"duplicate_pages":  "Количество дубликатов по содержимому",
+		"avg_fetch_ms":     "Среднее время загрузки, мс",
+		"external_links":   "Количество ссылок на внешние ресурсы",
+		"external_domains": "Количество уникальных внешних доменов",
+		"file_links":       "Количество ссылок на файлы",
+		"status_codes":     "Коды ответа",
+		"pages_per_host":   "Страниц по хостам",
+		"pages_per_depth":  "Страниц по глубине",
+		"content_types":    "Типы содержимого",
+		"languages":        "Страниц по языкам",
+		"crawl_rate":       "Скорость обхода",
+		"largest_pages":    "Самые большие страницы, байт",
+		"file_links_ext":   "Файлы по расширению",
+		"documents":        "Количество скачанных документов",
+		"documents_mime":   "Документы по типу содержимого",
+		"limit_hits":       "Загрузки, прерванные ограничениями",
+		"dns_queries":      "Запросов к DNS-серверам",
+		"dns_failures":     "Ошибок DNS-серверов",
+		"dns_latency_ms":   "Средняя задержка DNS-серверов, мс",
+	},
+	"en": {
+		"title":            "Crawl statistics",
+		"domain":           "Domain",
+		"run":              "Run",
+		"period":           "Period",
+		"generated_at":     "Generated at",
+		"summary":          "Summary",
+		"metric":           "Metric",
+		"value":            "Value",
+		"count":            "Count",
+		"total_pages":      "Total pages",
+		"internal_pages":   "Pages on the main domain",
+		"subdomain_pages":  "Pages on subdomains",
+		"broken_pages":     "Broken pages",
+		"duplicate_pages":  "Duplicate pages by content",
+		"avg_fetch_ms":     "Average fetch time, ms",
+		"external_links":   "Links to external resources",
+		"external_domains": "Unique external domains",
+		"file_links":       "Links to files",
+		"status_codes":     "Status codes",
+		"pages_per_host":   "Pages per host",
+		"pages_per_depth":  "Pages per depth",
+		"content_types":    "Content types",
+		"languages":        "Pages per language",
+		"crawl_rate":       "Crawl rate",
+		"largest_pages":    "Largest pages, bytes",
+		"file_links_ext":   "Files by extension",
+		"documents":        "Downloaded documents",
+		"documents_mime":   "Documents by content type",
+		"limit_hits":       "Fetches aborted by limits",
+		"dns_queries":      "DNS server queries",
+		"dns_failures":     "DNS server failures",
+		"dns_latency_ms":   "DNS server average latency, ms",
+	},
+}
+
+// label возвращает подпись на языке lang, по умолчанию русскую
+func label(lang, key string) string {
+	if l, ok := labels[lang]; ok {
+		if s, ok := l[key]; ok {
+			return s
+		}
+	}
+	if s, ok := labels["ru"][key]; ok {
+		return s
+	}
+	return key
+}
+
+// tableLabel подпись таблицы: у file_links и documents она отличается от показателя сводки
+func tableLabel(lang, key string) string {
+	switch key {
+	case "file_links":
+		return label(lang, "file_links_ext")
+	case "documents":
+		return label(lang, "documents_mime")
+	}
+	return label(lang, key)
+}
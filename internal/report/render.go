@@ -0,0 +1,109 @@
+package report
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func renderJSON(w io.Writer, r *Report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// renderCSV пишет отчет в "длинном" виде: section,key,value
+func renderCSV(w io.Writer, r *Report) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"section", "key", "value"})
+
+	for _, m := range r.metrics() {
+		cw.Write([]string{"summary", m.Key, m.Value})
+	}
+	for _, t := range r.tables() {
+		for _, row := range t.Rows {
+			cw.Write([]string{t.Key, row.Key, strconv.Itoa(row.Count)})
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func renderText(w io.Writer, r *Report, lang string) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s: %s\n", label(lang, "title"), r.Domain)
+	for _, line := range r.header(lang) {
+		fmt.Fprintf(&sb, "%s: %s\n", line.Key, line.Value)
+	}
+	sb.WriteString("\n")
+
+	for _, m := range r.metrics() {
+		fmt.Fprintf(&sb, "%s: %s\n", label(lang, m.Key), m.Value)
+	}
+	for _, t := range r.tables() {
+		fmt.Fprintf(&sb, "\n%s:\n", tableLabel(lang, t.Key))
+		for _, row := range t.Rows {
+			fmt.Fprintf(&sb, "\t%s\t%d\n", row.Key, row.Count)
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func renderMarkdown(w io.Writer, r *Report, lang string) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# %s: %s\n\n", label(lang, "title"), mdEscape(r.Domain))
+	for _, line := range r.header(lang) {
+		fmt.Fprintf(&sb, "- **%s:** %s\n", line.Key, mdEscape(line.Value))
+	}
+
+	fmt.Fprintf(&sb, "\n## %s\n\n| %s | %s |\n|---|---:|\n", label(lang, "summary"), label(lang, "metric"), label(lang, "value"))
+	for _, m := range r.metrics() {
+		fmt.Fprintf(&sb, "| %s | %s |\n", label(lang, m.Key), m.Value)
+	}
+
+	for _, t := range r.tables() {
+		fmt.Fprintf(&sb, "\n## %s\n\n", tableLabel(lang, t.Key))
+		if len(t.Rows) == 0 {
+			sb.WriteString("—\n")
+			continue
+		}
+		fmt.Fprintf(&sb, "| %s | %s |\n|---|---:|\n", label(lang, "value"), label(lang, "count"))
+		for _, row := range t.Rows {
+			fmt.Fprintf(&sb, "| %s | %d |\n", mdEscape(row.Key), row.Count)
+		}
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func mdEscape(s string) string {
+	return strings.NewReplacer("|", `\|`, "*", `\*`, "_", `\_`).Replace(s)
+}
+
+// header строки заголовка отчета: запуск, период, время формирования
+func (r *Report) header(lang string) []metric {
+	var out []metric
+	if r.RunID != 0 {
+		out = append(out, metric{label(lang, "run"), strconv.FormatInt(r.RunID, 10)})
+	}
+	if r.From != nil || r.To != nil {
+		from, to := "…", "…"
+		if r.From != nil {
+			from = r.From.Format(time.RFC3339)
+		}
+		if r.To != nil {
+			to = r.To.Format(time.RFC3339)
+		}
+		out = append(out, metric{label(lang, "period"), from + " — " + to})
+	}
+	out = append(out, metric{label(lang, "generated_at"), r.GeneratedAt.Format(time.RFC3339)})
+	return out
+}
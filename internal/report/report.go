@@ -0,0 +1,207 @@
+package report
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"web_crawler/internal/db"
+)
+
+// Форматы вывода отчета
+const (
+	FormatText     = "text"
+	FormatJSON     = "json"
+	FormatCSV      = "csv"
+	FormatMarkdown = "markdown"
+	FormatHTML     = "html"
+)
+
+// Count строка таблицы отчета
+type Count struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// Summary основные показатели обхода
+type Summary struct {
+	TotalPages      int     `json:"total_pages"`
+	InternalPages   int     `json:"internal_pages"`
+	SubdomainPages  int     `json:"subdomain_pages"`
+	BrokenPages     int     `json:"broken_pages"`
+	DuplicatePages  int     `json:"duplicate_pages"`
+	AvgFetchMs      float64 `json:"avg_fetch_ms"`
+	ExternalLinks   int     `json:"external_links"`
+	ExternalDomains int     `json:"external_domains"`
+	FileLinks       int     `json:"file_links"`
+	Documents       int     `json:"documents"`
+}
+
+// Report структурированный отчет по обходу, который умеют выводить все форматы
+type Report struct {
+	Domain      string     `json:"domain"`
+	GeneratedAt time.Time  `json:"generated_at"`
+	RunID       int64      `json:"run_id,omitempty"`
+	From        *time.Time `json:"from,omitempty"`
+	To          *time.Time `json:"to,omitempty"`
+
+	Summary         Summary `json:"summary"`
+	StatusCodes     []Count `json:"status_codes"`
+	PagesPerHost    []Count `json:"pages_per_host"`
+	PagesPerDepth   []Count `json:"pages_per_depth"`
+	ContentTypes    []Count `json:"content_types"`
+	Languages       []Count `json:"languages"`
+	CrawlRate       []Count `json:"crawl_rate"`
+	LargestPages    []Count `json:"largest_pages"`
+	ExternalDomains []Count `json:"external_domains"`
+	FileLinks       []Count `json:"file_links"`
+	Documents       []Count `json:"documents"`
+	// LimitHits загрузки, прерванные ограничениями; есть только для одного запуска
+	LimitHits []Count `json:"limit_hits"`
+
+	// DNS статистика DNS-серверов запуска
+	DNS []db.DNSUpstream `json:"dns,omitempty"`
+}
+
+// New собирает отчет из статистики базы
+func New(filter db.StatFilter, st *db.Stats) *Report {
+	r := &Report{
+		Domain:      filter.Domain,
+		GeneratedAt: time.Now(),
+		RunID:       filter.RunID,
+
+		StatusCodes:     counts(st.StatusCodes),
+		PagesPerHost:    counts(st.PagesPerHost),
+		PagesPerDepth:   counts(st.PagesPerDepth),
+		ContentTypes:    counts(st.ContentTypes),
+		Languages:       counts(st.Languages),
+		ExternalDomains: counts(st.ExternalDomains),
+		FileLinks:       counts(st.FileLinks),
+		Documents:       counts(st.Documents),
+		LimitHits:       counts(st.LimitHits),
+		DNS:             st.DNS,
+	}
+	if !filter.From.IsZero() {
+		r.From = &filter.From
+	}
+	if !filter.To.IsZero() {
+		r.To = &filter.To
+	}
+
+	files := 0
+	for _, row := range st.FileLinks {
+		files += row.Count
+	}
+	documents := 0
+	for _, row := range st.Documents {
+		documents += row.Count
+	}
+	r.Summary = Summary{
+		TotalPages:      st.Total,
+		InternalPages:   st.Internal,
+		SubdomainPages:  st.Subdomains,
+		BrokenPages:     st.Broken,
+		DuplicatePages:  st.Duplicates,
+		AvgFetchMs:      float64(st.AvgFetch.Microseconds()) / 1000,
+		ExternalLinks:   st.ExternalLinks,
+		ExternalDomains: st.UniqueExternal,
+		FileLinks:       files,
+		Documents:       documents,
+	}
+
+	r.CrawlRate = make([]Count, 0, len(st.CrawlRate))
+	for _, p := range st.CrawlRate {
+		r.CrawlRate = append(r.CrawlRate, Count{Key: p.Bucket.Format(time.RFC3339), Count: p.Count})
+	}
+	r.LargestPages = make([]Count, 0, len(st.LargestPages))
+	for _, p := range st.LargestPages {
+		r.LargestPages = append(r.LargestPages, Count{Key: p.URL, Count: p.Bytes})
+	}
+	return r
+}
+
+func counts(rows []db.CountRow) []Count {
+	out := make([]Count, 0, len(rows))
+	for _, row := range rows {
+		out = append(out, Count{Key: row.Key, Count: row.Count})
+	}
+	return out
+}
+
+// Render выводит отчет в формате format; lang (ru/en) влияет на подписи
+// в текстовом, Markdown и HTML форматах
+func Render(w io.Writer, r *Report, format, lang string) error {
+	switch format {
+	case FormatText, "":
+		return renderText(w, r, lang)
+	case FormatJSON:
+		return renderJSON(w, r)
+	case FormatCSV:
+		return renderCSV(w, r)
+	case FormatMarkdown, "md":
+		return renderMarkdown(w, r, lang)
+	case FormatHTML:
+		return renderHTML(w, r, lang)
+	}
+	return fmt.Errorf("unknown report format %q", format)
+}
+
+// metric показатель сводки с ключом подписи
+type metric struct {
+	Key   string
+	Value string
+}
+
+func (r *Report) metrics() []metric {
+	s := r.Summary
+	return []metric{
+		{"total_pages", fmt.Sprint(s.TotalPages)},
+		{"internal_pages", fmt.Sprint(s.InternalPages)},
+		{"subdomain_pages", fmt.Sprint(s.SubdomainPages)},
+		{"broken_pages", fmt.Sprint(s.BrokenPages)},
+		{"duplicate_pages", fmt.Sprint(s.DuplicatePages)},
+		{"avg_fetch_ms", fmt.Sprintf("%.1f", s.AvgFetchMs)},
+		{"external_links", fmt.Sprint(s.ExternalLinks)},
+		{"external_domains", fmt.Sprint(s.ExternalDomains)},
+		{"file_links", fmt.Sprint(s.FileLinks)},
+		{"documents", fmt.Sprint(s.Documents)},
+	}
+}
+
+// table таблица отчета с ключом подписи
+type table struct {
+	Key  string
+	Rows []Count
+}
+
+func (r *Report) tables() []table {
+	tables := []table{
+		{"status_codes", r.StatusCodes},
+		{"pages_per_host", r.PagesPerHost},
+		{"pages_per_depth", r.PagesPerDepth},
+		{"content_types", r.ContentTypes},
+		{"languages", r.Languages},
+		{"crawl_rate", r.CrawlRate},
+		{"largest_pages", r.LargestPages},
+		{"external_domains", r.ExternalDomains},
+		{"file_links", r.FileLinks},
+		{"documents", r.Documents},
+		{"limit_hits", r.LimitHits},
+	}
+	if len(r.DNS) == 0 {
+		return tables
+	}
+
+	var queries, failures, latency []Count
+	for _, u := range r.DNS {
+		queries = append(queries, Count{Key: u.Upstream, Count: int(u.Queries)})
+		failures = append(failures, Count{Key: u.Upstream, Count: int(u.Failures)})
+		latency = append(latency, Count{Key: u.Upstream, Count: int(math.Round(u.LatencyMs))})
+	}
+	return append(tables,
+		table{"dns_queries", queries},
+		table{"dns_failures", failures},
+		table{"dns_latency_ms", latency},
+	)
+}
@@ -0,0 +1,124 @@
+package report
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"web_crawler/internal/db"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testReport() *Report {
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	st := &db.Stats{
+		Total:          10,
+		Internal:       8,
+		Subdomains:     2,
+		Broken:         1,
+		Duplicates:     3,
+		AvgFetch:       1500 * time.Millisecond,
+		StatusCodes:    []db.CountRow{{Key: "200", Count: 9}, {Key: "404", Count: 1}},
+		PagesPerHost:   []db.CountRow{{Key: "example.com", Count: 8}},
+		Languages:      []db.CountRow{{Key: "ru", Count: 7}, {Key: "en", Count: 3}},
+		CrawlRate:      []db.RatePoint{{Bucket: from, Count: 10}},
+		LargestPages:   []db.PageSize{{URL: "https://example.com/big", Bytes: 4096}},
+		ExternalLinks:  5,
+		UniqueExternal: 2,
+		FileLinks:      []db.CountRow{{Key: "pdf", Count: 2}, {Key: "docx", Count: 1}},
+		Documents:      []db.CountRow{{Key: "application/pdf", Count: 2}},
+		LimitHits:      []db.CountRow{{Key: "body_size", Count: 1}},
+		DNS:            []db.DNSUpstream{{Upstream: "udp://1.1.1.1:53", Queries: 12, Failures: 1, LatencyMs: 4.6}},
+	}
+	r := New(db.StatFilter{Domain: "example.com", RunID: 3, From: from}, st)
+	r.GeneratedAt = from
+	return r
+}
+
+func TestNew(t *testing.T) {
+	r := testReport()
+
+	assert.Equal(t, "example.com", r.Domain)
+	assert.Equal(t, int64(3), r.RunID)
+	require.NotNil(t, r.From)
+	assert.Nil(t, r.To)
+	assert.Equal(t, 3, r.Summary.FileLinks)
+	assert.Equal(t, 2, r.Summary.Documents)
+	assert.Equal(t, 1500.0, r.Summary.AvgFetchMs)
+	assert.Equal(t, []Count{{"2025-01-01T00:00:00Z", 10}}, r.CrawlRate)
+	assert.Equal(t, []Count{{"https://example.com/big", 4096}}, r.LargestPages)
+	assert.NotNil(t, r.ContentTypes, "empty tables must render as [] in JSON")
+}
+
+func TestRender(t *testing.T) {
+	r := testReport()
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Render(&buf, r, FormatJSON, "ru"))
+
+		var decoded Report
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+		assert.Equal(t, r.Summary, decoded.Summary)
+		assert.Equal(t, r.FileLinks, decoded.FileLinks)
+		assert.Equal(t, r.DNS, decoded.DNS)
+		assert.Contains(t, buf.String(), `"total_pages": 10`)
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Render(&buf, r, FormatCSV, "ru"))
+
+		records, err := csv.NewReader(&buf).ReadAll()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"section", "key", "value"}, records[0])
+		assert.Contains(t, records, []string{"summary", "total_pages", "10"})
+		assert.Contains(t, records, []string{"status_codes", "404", "1"})
+		assert.Contains(t, records, []string{"file_links", "pdf", "2"})
+		assert.Contains(t, records, []string{"documents", "application/pdf", "2"})
+		assert.Contains(t, records, []string{"languages", "ru", "7"})
+		assert.Contains(t, records, []string{"limit_hits", "body_size", "1"})
+		assert.Contains(t, records, []string{"dns_queries", "udp://1.1.1.1:53", "12"})
+		assert.Contains(t, records, []string{"dns_latency_ms", "udp://1.1.1.1:53", "5"})
+	})
+
+	t.Run("markdown localized", func(t *testing.T) {
+		var ru, en bytes.Buffer
+		require.NoError(t, Render(&ru, r, FormatMarkdown, "ru"))
+		require.NoError(t, Render(&en, r, FormatMarkdown, "en"))
+
+		assert.Contains(t, ru.String(), "| Количество неработающих страниц | 1 |")
+		assert.Contains(t, en.String(), "| Broken pages | 1 |")
+		assert.Contains(t, en.String(), "## Files by extension")
+		assert.Contains(t, en.String(), "| pdf | 2 |")
+		assert.Contains(t, en.String(), "## Documents by content type")
+		assert.Contains(t, en.String(), "## Pages per language")
+	})
+
+	t.Run("html", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Render(&buf, r, FormatHTML, "en"))
+
+		out := buf.String()
+		assert.True(t, strings.HasPrefix(out, "<!DOCTYPE html>"))
+		assert.Contains(t, out, `<html lang="en">`)
+		assert.Contains(t, out, "<svg")
+		assert.Contains(t, out, `width="100.0%"`)
+		assert.NotContains(t, out, "<script", "report must be self-contained")
+	})
+
+	t.Run("text", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, Render(&buf, r, FormatText, "ru"))
+		assert.Contains(t, buf.String(), "Общее количество ссылок: 10")
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		assert.Error(t, Render(&bytes.Buffer{}, r, "xml", "ru"))
+	})
+}
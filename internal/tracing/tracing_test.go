@@ -0,0 +1,48 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+func TestSetup(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("disabled by default", func(t *testing.T) {
+		shutdown, err := Setup(ctx, Config{})
+		require.NoError(t, err)
+		assert.NoError(t, shutdown(ctx))
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		_, err := Setup(ctx, Config{Exporter: "zipkin"})
+		assert.Error(t, err)
+
+		_, err = Setup(ctx, Config{Exporter: ExporterFile})
+		assert.Error(t, err)
+	})
+
+	t.Run("file exporter", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "spans.json")
+		shutdown, err := Setup(ctx, Config{Exporter: ExporterFile, File: path})
+		require.NoError(t, err)
+
+		_, span := Tracer("test").Start(ctx, "dns.resolve")
+		span.SetAttributes(attribute.Bool("dns.cache_hit", true))
+		End(span, errors.New("no such host"))
+		require.NoError(t, shutdown(ctx))
+
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Contains(t, string(data), `"Name":"dns.resolve"`)
+		assert.Contains(t, string(data), "dns.cache_hit")
+		assert.Contains(t, string(data), "no such host")
+	})
+}
@@ -6,49 +6,71 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
-	"main/internal/db"
-	"main/internal/downloader"
+	"log/slog"
+	"net/http"
 	"os"
 	"strings"
-	"sync"
-	"time"
+	"web_crawler/internal/blob"
+	"web_crawler/internal/db"
+	"web_crawler/internal/downloader"
+	"web_crawler/internal/egress"
+	"web_crawler/internal/identity"
+	"web_crawler/internal/logging"
+	"web_crawler/internal/metrics"
+	"web_crawler/internal/report"
+	"web_crawler/internal/tracing"
 )
 
 var settingsPath string = "./settings.json"
 
 type settings struct {
-	Mode        string            `json:"mode"`
-	MainHost    string            `json:"main_domain"`
-	DnsServers  []string          `json:"dns_servers"`
-	ToDownload  string            `json:"toDownload"`
-	DBConfig    db.DatabaseConfig `json:"dbconfig"`
-	RedisConfig struct {
+	Mode       string                    `json:"mode"`
+	MainHost   string                    `json:"main_domain"`
+	DnsServers []string                  `json:"dns_servers"`
+	DNS        downloader.DNSConfig      `json:"dns"`
+	ToDownload string                    `json:"toDownload"`
+	DBConfig   db.DatabaseConfig         `json:"dbconfig"`
+	Batch      db.BatchConfig            `json:"batch"`
+	Proxy      egress.Config             `json:"proxy"`
+	Identity   identity.Config           `json:"identity"`
+	Auth       downloader.AuthConfig     `json:"auth"`
+	Scripts    []downloader.PageScript   `json:"scripts"`
+	Capture    downloader.CaptureConfig  `json:"capture"`
+	Documents  downloader.DocumentConfig `json:"documents"`
+	Limits     downloader.LimitsConfig   `json:"limits"`
+	// MetricsAddr адрес /metrics в режиме spider; в режиме serve метрики отдает API
+	MetricsAddr string `json:"metrics_addr"`
+	// ProgressInterval период строки прогресса в секундах, 0 - не печатать
+	ProgressInterval int `json:"progress_interval"`
+	// FollowLanguages языки страниц, ссылки с которых обходятся в режиме spider; пустой - любые
+	FollowLanguages []string       `json:"follow_languages"`
+	Log             logging.Config `json:"log"`
+	Tracing         tracing.Config `json:"tracing"`
+	RedisConfig     struct {
 		Host       string `json:"host"`
 		Expiration int    `json:"expiration"`
 	} `json:"redisconfig"`
 }
 
-func (s *settings) SetSettings(fileName string) {
+func (s *settings) SetSettings(fileName string) error {
 	jsonFile, err := os.Open(fileName)
 	if err != nil {
-		fmt.Println("Ошибка открытия файла:", err)
-		return
+		return fmt.Errorf("failed to open settings file: %v", err)
 	}
 	defer jsonFile.Close()
 
 	byteValue, err := ioutil.ReadAll(jsonFile)
 	if err != nil {
-		fmt.Println("Ошибка чтения файла: ", err)
-		return
+		return fmt.Errorf("failed to read settings file: %v", err)
 	}
 
-	err = json.Unmarshal(byteValue, &s)
-	if err != nil {
-		fmt.Println("Ошибка декодирования JSON: ", err)
+	if err := json.Unmarshal(byteValue, &s); err != nil {
+		return fmt.Errorf("failed to decode settings: %v", err)
 	}
+	return nil
 }
 
 func hashMD5(content string) string {
@@ -62,203 +84,272 @@ func hashSHA256(content string) string {
 	return hex.EncodeToString(hasher.Sum(nil))
 }
 
-type Worker struct {
-	id       int
-	resolver *downloader.DNSResolver
-	storage  *db.PostgresStorage
-	wg       *sync.WaitGroup
-	timeout  time.Duration
-	pool     downloader.URLsPool
-	host     string
+type Crawler struct {
+	// fetcher резолвер, прокси выхода, идентификация и cookie, общие для всех запусков
+	fetcher *downloader.Fetcher
+	// blobs хранилище снимков страниц; nil - снимки не настроены
+	blobs   blob.Store
+	storage *db.PostgresStorage
+	batch   db.BatchConfig
+	// snapshot копия настроек без пароля, сохраняется вместе с запуском
+	snapshot settings
 }
 
-func (w *Worker) Start(ctx context.Context, in chan string) {
-	defer w.wg.Done()
-
-	for {
-		select {
-		case url := <-in:
-			htmlPage, status, err := downloader.FetchDynamicHTML(ctx, url, w.resolver)
-
-			if err != nil {
-				fmt.Println("Error fetching HTML: ", err)
-				continue
-			}
-			host, err := downloader.GetHost(url)
-			if err != nil {
-				fmt.Printf("Getting host from url falied: %v\n", err)
-				continue
-			}
-
-			content := &db.CrawledContent{
-				DOMAIN:      host,
-				URL:         url,
-				TextContent: "empty",
-				Title:       "empty",
-				Status:      status,
-				Metadata:    nil,
-				ContentHash: hashMD5(htmlPage[int(float64(len(htmlPage))*0.8):]),
-				CrawledAt:   time.Now(),
-			}
-
-			// =================<>==================
-
-			exists, err := w.storage.ExistsByURL(context.Background(), content.URL)
-
-			if err != nil {
-				log.Printf("Error checking content: %v", err)
-			}
-			if exists {
-				log.Println("Content already exists, skipping ", content.URL, " by worker ", w.id)
-				continue
-			}
-
-			if err := w.storage.Save(context.Background(), content); err != nil {
-				log.Printf("Failed to save content: %v", err)
-			} else {
-				log.Println("Content saved successfully ", content.URL, " by worker ", w.id)
-			}
-
-			if host == w.host || strings.Contains(host, w.host) {
-				links := downloader.ExtractLinks(htmlPage, "http://"+host)
-
-				for _, link := range links {
-					if !w.pool.Exist(link) {
-						w.pool.Add(link)
-						in <- link
-					}
-				}
-			}
-		case <-time.After(w.timeout * time.Second):
-			fmt.Println("STOP WORKER ", w.id)
-			return
-		}
+func BuildCrawler(settings *settings) (*Crawler, error) {
+	cache := downloader.NewDNSCache(settings.RedisConfig.Host, settings.DNS)
+	dnsConfig := settings.DNS
+	// Старый параметр expiration (в часах) ограничивает TTL, если max_ttl не задан
+	if dnsConfig.MaxTTL == 0 && settings.RedisConfig.Expiration > 0 {
+		dnsConfig.MaxTTL = uint32(settings.RedisConfig.Expiration) * 60 * 60
+	}
+	resolver, err := downloader.NewDNSResolver(settings.DnsServers, dnsConfig, cache)
+	if err != nil {
+		return nil, err
 	}
-}
 
-type Crawler struct {
-	resolver *downloader.DNSResolver
-	storage  *db.PostgresStorage
-}
+	sessions, err := downloader.NewSessions(settings.Auth)
+	if err != nil {
+		return nil, err
+	}
 
-func BuildCrawler(settings *settings) (*Crawler, error) {
-	cache := downloader.NewDNSCache(settings.RedisConfig.Host, time.Duration(settings.RedisConfig.Expiration)*time.Hour)
-	storage, err := db.NewPostgresStorage(settings.DBConfig)
+	scripts, err := downloader.NewScripts(settings.Scripts)
+	if err != nil {
+		return nil, err
+	}
+
+	limits, err := downloader.NewLimits(settings.Limits)
 	if err != nil {
-		fmt.Println("Error create connection to database: ", err)
-		//log.Fatalf("Failed to initialize storage: %v", err)
 		return nil, err
 	}
 
+	capture, err := downloader.NewCapture(settings.Capture)
+	if err != nil {
+		return nil, err
+	}
+	blobs, err := blob.New(settings.Capture.Store)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure capture store: %v", err)
+	}
+	if capture != nil && blobs == nil {
+		return nil, fmt.Errorf("capture.store is required for screenshots and PDF")
+	}
+
+	proxies, err := egress.NewPool(settings.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure proxies: %v", err)
+	}
+
+	storage, err := db.NewPostgresStorage(settings.DBConfig)
+	if err != nil {
+		proxies.Close()
+		return nil, fmt.Errorf("failed to connect to database: %v", err)
+	}
+
 	if err := storage.Init(); err != nil {
-		log.Fatalf("Failed to init database: %v", err)
+		storage.Close()
+		proxies.Close()
+		return nil, fmt.Errorf("failed to init database: %v", err)
+	}
+
+	jar, err := loadCookies(storage, settings.Identity)
+	if err != nil {
+		storage.Close()
+		proxies.Close()
 		return nil, err
 	}
 
+	snapshot := *settings
+	snapshot.DBConfig.Password = ""
+	snapshot.Proxy = settings.Proxy.Redacted()
+	snapshot.Auth = settings.Auth.Redacted()
+	snapshot.Identity = settings.Identity.Redacted()
+	snapshot.Capture.Store = settings.Capture.Store.Redacted()
+
 	return &Crawler{
-		resolver: downloader.NewDNSResolver(settings.DnsServers, *cache),
+		fetcher: &downloader.Fetcher{
+			Resolver:  resolver,
+			Proxies:   proxies,
+			Identity:  settings.Identity,
+			Jar:       jar,
+			Auth:      sessions,
+			Scripts:   scripts,
+			Capture:   capture,
+			Documents: settings.Documents,
+			Limits:    limits,
+		},
+		blobs:    blobs,
 		storage:  storage,
+		batch:    settings.Batch,
+		snapshot: snapshot,
 	}, nil
 }
 
-func (c *Crawler) Run(maindomain string, starturl string, numWorkers int) {
-	defer c.storage.Close()
-	var m sync.RWMutex
-	var wg sync.WaitGroup
-	ctx := context.Background()
-	pool := downloader.CreatePool(&m)
+// loadCookies создает jar из cookie прошлых запусков и файла cookies_file; cookie из файла важнее
+func loadCookies(storage *db.PostgresStorage, cfg identity.Config) (*identity.Jar, error) {
+	jar := identity.NewJar()
+	saved, err := storage.LoadCookies(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to load cookies: %v", err)
+	}
+	cookies := make([]identity.Cookie, len(saved))
+	for i, c := range saved {
+		cookies[i] = identity.Cookie(c)
+	}
+	jar.Load(cookies)
 
-	urlChan := make(chan string, 100000)
+	if cfg.CookiesFile == "" {
+		return jar, nil
+	}
+	f, err := os.Open(cfg.CookiesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cookies file: %v", err)
+	}
+	defer f.Close()
+	cookies, err = identity.ParseNetscape(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cookies file: %v", err)
+	}
+	jar.Load(cookies)
+	return jar, nil
+}
 
-	defer close(urlChan)
+// Close закрывает соединение с базой и останавливает проверки прокси
+func (c *Crawler) Close() error {
+	c.fetcher.Proxies.Close()
+	return c.storage.Close()
+}
 
-	urlChan <- starturl
+func (c *Crawler) ShowStat(opts statOptions) error {
+	ctx := context.Background()
 
-	for i := 1; i <= numWorkers; i++ {
-		wg.Add(1)
-		worker := Worker{
-			id:       i,
-			resolver: c.resolver,
-			storage:  c.storage,
-			wg:       &wg,
-			timeout:  10,
-			pool:     *pool,
-			host:     maindomain,
+	// Без -run и -all показывается последний запуск, чтобы не смешивать обходы
+	if opts.filter.RunID == 0 && !opts.allRuns {
+		runID, err := c.storage.LatestRunID(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to find latest run: %v", err)
 		}
-		go worker.Start(ctx, urlChan)
+		opts.filter.RunID = runID
 	}
 
-	wg.Wait()
-}
-
-func (c *Crawler) ShowStat(maindomain string) {
-	defer c.storage.Close()
-	var out = make([]db.StatContent, 0, 100)
-	err := c.storage.GetAll(&out)
+	st, err := c.storage.Stats(ctx, opts.filter)
 	if err != nil {
-		return
+		return fmt.Errorf("failed to collect stats: %v", err)
 	}
 
-	fmt.Println("Общее количество ссылок: ", len(out))
+	return report.Render(os.Stdout, report.New(opts.filter, st), opts.format, opts.lang)
+}
 
-	UnderDomain := 0
-	for _, row := range out {
-		if strings.Contains(row.Domain, maindomain) {
-			UnderDomain += 1
-		}
-	}
-	fmt.Println("Количество внутренних ссылок главного домена: ", UnderDomain)
+// statOptions параметры команды stat
+type statOptions struct {
+	filter  db.StatFilter
+	allRuns bool
+	format  string
+	lang    string
+}
 
-	NotWorked := 0
-	for _, row := range out {
-		if row.Status != 200 {
-			NotWorked += 1
-		}
+// parseStatOptions разбирает флаги команды stat: -run, -all, -from, -to (RFC3339 или YYYY-MM-DD),
+// -format и -lang
+func parseStatOptions(domain string, args []string) (statOptions, error) {
+	opts := statOptions{filter: db.StatFilter{Domain: domain}}
+	filter := &opts.filter
+
+	fs := flag.NewFlagSet("stat", flag.ContinueOnError)
+	fs.StringVar(&opts.format, "format", report.FormatText, "output format: text, json, csv, markdown or html")
+	fs.StringVar(&opts.lang, "lang", "ru", "labels language for text, markdown and html: ru or en")
+	fs.Int64Var(&filter.RunID, "run", 0, "crawl run id (latest run by default)")
+	fs.BoolVar(&opts.allRuns, "all", false, "aggregate all crawl runs")
+	from := fs.String("from", "", "start of time range (RFC3339 or YYYY-MM-DD)")
+	to := fs.String("to", "", "end of time range (RFC3339 or YYYY-MM-DD)")
+	fs.StringVar(&filter.RateBucket, "bucket", "minute", "crawl rate bucket: minute, hour or day")
+	if err := fs.Parse(args); err != nil {
+		return opts, err
 	}
-	fmt.Println("Количество неработающих страниц: ", NotWorked)
 
-	InterDomain := 0
-	for _, row := range out {
-		if strings.Contains(row.Domain, maindomain) && len(row.Domain) > len(maindomain) {
-			InterDomain += 1
-		}
+	var err error
+	if filter.From, err = db.ParseTime(*from); err != nil {
+		return opts, err
 	}
-	fmt.Println("Колличество внутренних поддоменов: ", InterDomain)
-
-	OuterDomain := 0
-	UniqOuterDomain := make(map[string]bool, 0)
-	for _, row := range out {
-		if !strings.Contains(row.Domain, maindomain) {
-			OuterDomain += 1
-			UniqOuterDomain[row.Domain] = true
-		}
+	if filter.To, err = db.ParseTime(*to); err != nil {
+		return opts, err
 	}
-	fmt.Println("Колличество ссылок на внешние ресурсы : ", OuterDomain)
-	fmt.Println("Количество уникальных внешних ссылок: ", len(UniqOuterDomain))
+	return opts, nil
+}
 
-	Files := 0
-	for _, row := range out {
-		if strings.Contains(row.Url, ".doc") || strings.Contains(row.Url, ".docx") || strings.Contains(row.Url, ".pdf") {
-			Files += 1
-		}
+// parseCommand возвращает команду и ее аргументы: первый аргумент без "-"
+// переопределяет mode из settings.json
+func parseCommand(mode string, args []string) (string, []string) {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		return args[0], args[1:]
 	}
-	fmt.Println("Количество уникальных ссылок на файлы doc/docx/pdf: ", Files)
+	return mode, args
+}
+
+// fatal пишет ошибку в лог и завершает процесс. Вызывается только из main
+func fatal(msg string, err error) {
+	slog.Error(msg, logging.Err(err))
+	os.Exit(1)
 }
 
 func main() {
 
 	settings := &settings{}
-	settings.SetSettings(settingsPath)
+	if err := settings.SetSettings(settingsPath); err != nil {
+		fatal("Failed to read settings", err)
+	}
+	logger, err := logging.New(settings.Log, os.Stderr)
+	if err != nil {
+		fatal("Invalid log settings", err)
+	}
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), settings.Tracing)
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+
 	C, err := BuildCrawler(settings)
 	if err != nil {
-		log.Fatalf("Failed to Build Crawler: %v", err)
+		fatal("Failed to build crawler", err)
+	}
+
+	command, args := parseCommand(settings.Mode, os.Args[1:])
+	err = runCommand(C, settings, command, args)
+	C.Close()
+	if serr := shutdownTracing(context.Background()); serr != nil {
+		slog.Warn("Failed to flush traces", logging.Err(serr))
+	}
+	if err != nil {
+		fatal("Command "+command+" failed", err)
 	}
+}
 
-	switch settings.Mode {
+func runCommand(C *Crawler, settings *settings, command string, args []string) error {
+	switch command {
 	case "spider":
-		C.Run(settings.MainHost, settings.ToDownload, 5)
+		if settings.MetricsAddr != "" {
+			go func() {
+				slog.Info("Metrics listening", "addr", settings.MetricsAddr)
+				if err := http.ListenAndServe(settings.MetricsAddr, metrics.Handler()); err != nil {
+					slog.Error("Metrics server failed", logging.Err(err))
+				}
+			}()
+		}
+		return C.Run(settings.MainHost, settings.ToDownload, 5)
+	case "serve":
+		return C.Serve(args)
 	case "stat":
-		C.ShowStat(settings.MainHost)
+		opts, err := parseStatOptions(settings.MainHost, args)
+		if err != nil {
+			return fmt.Errorf("invalid stat arguments: %v", err)
+		}
+		return C.ShowStat(opts)
+	case "runs":
+		return C.Runs(args)
+	case "export":
+		return C.Export(args)
+	case "search":
+		return C.Search(args)
+	default:
+		return fmt.Errorf("unknown command %q", command)
 	}
-
 }
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"web_crawler/internal/db"
+	"web_crawler/internal/logging"
+)
+
+const runsUsage = `usage:
+  runs [list] [-format text|json]
+  runs compare [-format text|json] BASE_ID TARGET_ID
+  runs delete [-force] RUN_ID`
+
+// Runs выполняет команды управления запусками: list, compare, delete
+func (c *Crawler) Runs(args []string) error {
+	ctx := context.Background()
+
+	sub := "list"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		sub, args = args[0], args[1:]
+	}
+
+	fs := flag.NewFlagSet("runs "+sub, flag.ContinueOnError)
+	format := fs.String("format", "text", "output format: text or json")
+	force := fs.Bool("force", false, "delete a run that has not finished")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	ids, err := parseRunIDs(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case sub == "list" && len(ids) == 0:
+		runs, err := c.storage.ListRuns(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list runs: %v", err)
+		}
+		if *format == "json" {
+			return writeJSON(runs)
+		}
+		printRuns(runs)
+	case sub == "compare" && len(ids) == 2:
+		diff, err := c.storage.CompareRuns(ctx, ids[0], ids[1])
+		if err != nil {
+			return fmt.Errorf("failed to compare runs: %v", err)
+		}
+		if *format == "json" {
+			return writeJSON(diff)
+		}
+		printDiff(diff)
+	case sub == "delete" && len(ids) == 1:
+		refs, err := c.storage.DeleteRun(ctx, ids[0], *force)
+		if errors.Is(err, db.ErrRunActive) {
+			return fmt.Errorf("run %d has not finished; use -force if it is no longer running", ids[0])
+		}
+		if err != nil {
+			return fmt.Errorf("failed to delete run %d: %v", ids[0], err)
+		}
+		fmt.Println("Run deleted: ", ids[0])
+		c.deleteBlobs(ctx, refs)
+	default:
+		return fmt.Errorf("invalid runs arguments\n%s", runsUsage)
+	}
+	return nil
+}
+
+// deleteBlobs удаляет снимки и PDF удаленного запуска; то, что удалить не удалось, печатается
+func (c *Crawler) deleteBlobs(ctx context.Context, refs []string) {
+	if len(refs) == 0 {
+		return
+	}
+	if c.blobs == nil {
+		slog.Warn("Capture store is not configured, run files are left in place", "files", len(refs))
+		for _, ref := range refs {
+			fmt.Println("\t", ref)
+		}
+		return
+	}
+	failed := 0
+	for _, ref := range refs {
+		if err := c.blobs.Delete(ctx, ref); err != nil {
+			failed++
+			slog.Warn("Failed to delete run file", "ref", ref, logging.Err(err))
+		}
+	}
+	fmt.Printf("Files deleted: %d of %d\n", len(refs)-failed, len(refs))
+}
+
+func parseRunIDs(args []string) ([]int64, error) {
+	ids := make([]int64, 0, len(args))
+	for _, arg := range args {
+		id, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid run id %q", arg)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func writeJSON(v interface{}) error {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func printRuns(runs []db.CrawlRun) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATUS\tSTARTED\tDURATION\tFETCHED\tSAVED\tERRORS\tSEEDS")
+	for _, run := range runs {
+		duration := "-"
+		if run.FinishedAt != nil {
+			duration = run.FinishedAt.Sub(run.StartedAt).Round(time.Second).String()
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%d\t%d\t%d\t%s\n",
+			run.ID, run.Status, run.StartedAt.Format(time.RFC3339), duration,
+			run.Counters.Fetched, run.Counters.Saved, run.Counters.FetchErrors+run.Counters.SaveErrors,
+			strings.Join(run.Seeds, " "))
+	}
+	tw.Flush()
+}
+
+func printDiff(diff *db.RunDiff) {
+	fmt.Printf("Сравнение запусков %d -> %d\n", diff.Base, diff.Target)
+
+	fmt.Printf("Новые страницы (%d):\n", len(diff.New))
+	for _, url := range diff.New {
+		fmt.Println("\t+", url)
+	}
+	fmt.Printf("Удаленные страницы (%d):\n", len(diff.Removed))
+	for _, url := range diff.Removed {
+		fmt.Println("\t-", url)
+	}
+	fmt.Printf("Измененные страницы (%d):\n", len(diff.Changed))
+	for _, ch := range diff.Changed {
+		fmt.Println("\t~", ch.URL)
+	}
+	fmt.Printf("Регрессии статуса (%d):\n", len(diff.Regressions))
+	for _, ch := range diff.Regressions {
+		fmt.Printf("\t! %s\t%d -> %d\n", ch.URL, ch.OldStatus, ch.NewStatus)
+	}
+	fmt.Printf("Визуальные изменения (%d):\n", len(diff.VisualChanges))
+	for _, ch := range diff.VisualChanges {
+		fmt.Printf("\t* %s\t%d/64\n", ch.URL, ch.Distance)
+	}
+}
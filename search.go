@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	"web_crawler/internal/db"
+)
+
+// Search ищет по тексту собранных страниц: search [флаги] запрос
+func (c *Crawler) Search(args []string) error {
+	ctx := context.Background()
+
+	sq := db.SearchQuery{StartSel: "**", StopSel: "**"}
+	fs := flag.NewFlagSet("search", flag.ContinueOnError)
+	fs.StringVar(&sq.Lang, "lang", "", "search language: ru or en (all languages by default)")
+	fs.StringVar(&sq.Domain, "domain", "", "search only this domain and its subdomains")
+	fs.Int64Var(&sq.RunID, "run", 0, "crawl run id (latest run by default)")
+	all := fs.Bool("all", false, "search all crawl runs")
+	page := fs.Int("page", 1, "results page")
+	fs.IntVar(&sq.Limit, "per-page", 10, "results per page")
+	format := fs.String("format", "text", "output format: text or json")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	sq.Text = strings.Join(fs.Args(), " ")
+	if sq.Text == "" {
+		return fmt.Errorf("usage: search [flags] QUERY")
+	}
+	if *page < 1 || sq.Limit < 1 {
+		return fmt.Errorf("-page and -per-page must be at least 1")
+	}
+	sq.Offset = (*page - 1) * sq.Limit
+
+	if sq.RunID == 0 && !*all {
+		runID, err := c.storage.LatestRunID(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to find latest run: %v", err)
+		}
+		sq.RunID = runID
+	}
+
+	res, err := c.storage.Search(ctx, sq)
+	if err != nil {
+		return fmt.Errorf("search failed: %v", err)
+	}
+
+	if *format == "json" {
+		return writeJSON(res)
+	}
+
+	fmt.Printf("Найдено: %d, страница %d\n", res.Total, *page)
+	for i, hit := range res.Hits {
+		fmt.Printf("\n%d. %s (%.3f)\n   %s\n   %s\n", sq.Offset+i+1, hit.Title, hit.Rank, hit.URL, hit.Snippet)
+	}
+	return nil
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"web_crawler/internal/api"
+	"web_crawler/internal/metrics"
+)
+
+// Server HTTP API поверх Crawler. Одновременно выполняется не больше одного обхода
+type Server struct {
+	crawler *Crawler
+	// start запускает обход, обычно crawler.StartCrawl
+	start func(spec CrawlSpec) (*Crawl, error)
+	// token если задан, запросы должны передавать его в заголовке Authorization: Bearer
+	token string
+
+	mu    sync.Mutex
+	crawl *Crawl
+}
+
+// Serve запускает HTTP API: serve [-addr 127.0.0.1:8080]. По умолчанию API доступен только
+// с этой машины; токен доступа берется из переменной окружения CRAWLER_API_TOKEN
+func (c *Crawler) Serve(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "listen address")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	srv := &Server{crawler: c, start: c.StartCrawl, token: os.Getenv("CRAWLER_API_TOKEN")}
+	if srv.token == "" && !api.Loopback(*addr) {
+		slog.Warn("API is listening on a public address without CRAWLER_API_TOKEN", "addr", *addr)
+	}
+	httpSrv := &http.Server{
+		Addr:              *addr,
+		Handler:           srv.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      60 * time.Second,
+		IdleTimeout:       120 * time.Second,
+	}
+	slog.Info("API listening", "addr", *addr)
+	return httpSrv.ListenAndServe()
+}
+
+// Handler возвращает маршруты API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/crawl", s.startCrawl)
+	mux.HandleFunc("GET /api/crawl", s.progress)
+	mux.HandleFunc("POST /api/crawl/urls", s.submitURLs)
+	mux.HandleFunc("POST /api/crawl/{action}", s.controlCrawl)
+	data := &api.Data{Storage: s.crawler.storage, MainHost: s.crawler.snapshot.MainHost}
+	data.Register(mux)
+	mux.Handle("GET /metrics", metrics.Handler())
+	if s.token == "" {
+		return mux
+	}
+	return api.Authorize(s.token, mux)
+}
+
+// active возвращает текущий незавершенный обход или nil
+func (s *Server) active() *Crawl {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.crawl == nil {
+		return nil
+	}
+	select {
+	case <-s.crawl.Done():
+		return nil
+	default:
+		return s.crawl
+	}
+}
+
+func (s *Server) startCrawl(w http.ResponseWriter, r *http.Request) {
+	var spec CrawlSpec
+	if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+		api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid crawl spec: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.crawl != nil {
+		select {
+		case <-s.crawl.Done():
+		default:
+			api.WriteError(w, http.StatusConflict, fmt.Errorf("crawl run %d is already active", s.crawl.run.ID))
+			return
+		}
+	}
+
+	if err := spec.validate(); err != nil {
+		api.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	crawl, err := s.start(spec)
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, err)
+		return
+	}
+	s.crawl = crawl
+	api.WriteResponse(w, http.StatusCreated, crawl.Progress())
+}
+
+func (s *Server) progress(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	crawl := s.crawl
+	s.mu.Unlock()
+	if crawl == nil {
+		api.WriteError(w, http.StatusNotFound, errors.New("no crawl has been started"))
+		return
+	}
+	api.WriteResponse(w, http.StatusOK, crawl.Progress())
+}
+
+func (s *Server) controlCrawl(w http.ResponseWriter, r *http.Request) {
+	crawl := s.active()
+	if crawl == nil {
+		api.WriteError(w, http.StatusConflict, errors.New("no active crawl"))
+		return
+	}
+
+	switch r.PathValue("action") {
+	case "stop":
+		crawl.Stop()
+	case "pause":
+		crawl.Pause()
+	case "resume":
+		crawl.Resume()
+	default:
+		api.WriteError(w, http.StatusNotFound, fmt.Errorf("unknown action %q", r.PathValue("action")))
+		return
+	}
+	api.WriteResponse(w, http.StatusOK, crawl.Progress())
+}
+
+func (s *Server) submitURLs(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		URLs []string `json:"urls"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid body: %v", err))
+		return
+	}
+
+	crawl := s.active()
+	if crawl == nil {
+		api.WriteError(w, http.StatusConflict, errors.New("no active crawl"))
+		return
+	}
+
+	accepted, err := crawl.Submit(body.URLs)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errQueueFull) {
+			status = http.StatusServiceUnavailable
+		}
+		api.WriteError(w, status, fmt.Errorf("accepted %d urls: %v", accepted, err))
+		return
+	}
+	api.WriteResponse(w, http.StatusAccepted, map[string]int{"accepted": accepted})
+}
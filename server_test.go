@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"web_crawler/internal/db"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestServer сервер, который вместо запуска воркеров создает обход с очередью на queue URL
+func newTestServer(t *testing.T, token string, queue int) *Server {
+	conn, _, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	s := &Server{crawler: &Crawler{storage: db.NewStorage(conn)}, token: token}
+	s.start = func(spec CrawlSpec) (*Crawl, error) {
+		cr := newTestCrawl(spec, queue)
+		t.Cleanup(cr.Stop)
+		return cr, nil
+	}
+	return s
+}
+
+func request(h http.Handler, method, target, body, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func progressOf(t *testing.T, rec *httptest.ResponseRecorder) Progress {
+	var p Progress
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+	return p
+}
+
+const testSpec = `{"seeds":["https://site.test/"]}`
+
+func TestServerCrawl(t *testing.T) {
+	t.Run("start and conflict", func(t *testing.T) {
+		h := newTestServer(t, "", 10).Handler()
+
+		assert.Equal(t, http.StatusNotFound, request(h, http.MethodGet, "/api/crawl", "", "").Code)
+		assert.Equal(t, http.StatusBadRequest, request(h, http.MethodPost, "/api/crawl", `{"seeds":[]}`, "").Code)
+		assert.Equal(t, http.StatusBadRequest, request(h, http.MethodPost, "/api/crawl", `{`, "").Code)
+
+		rec := request(h, http.MethodPost, "/api/crawl", testSpec, "")
+		require.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, crawlRunning, progressOf(t, rec).State)
+
+		rec = request(h, http.MethodPost, "/api/crawl", testSpec, "")
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		assert.Contains(t, rec.Body.String(), "already active")
+	})
+
+	t.Run("new crawl after the previous one finished", func(t *testing.T) {
+		s := newTestServer(t, "", 10)
+		h := s.Handler()
+		require.Equal(t, http.StatusCreated, request(h, http.MethodPost, "/api/crawl", testSpec, "").Code)
+		close(s.crawl.done)
+
+		assert.Equal(t, http.StatusConflict, request(h, http.MethodPost, "/api/crawl/pause", "", "").Code)
+		assert.Equal(t, http.StatusCreated, request(h, http.MethodPost, "/api/crawl", testSpec, "").Code)
+	})
+
+	t.Run("pause, resume and stop", func(t *testing.T) {
+		h := newTestServer(t, "", 10).Handler()
+		assert.Equal(t, http.StatusConflict, request(h, http.MethodPost, "/api/crawl/pause", "", "").Code, "no active crawl")
+		require.Equal(t, http.StatusCreated, request(h, http.MethodPost, "/api/crawl", testSpec, "").Code)
+
+		for _, step := range []struct{ action, state string }{
+			{"pause", crawlPaused},
+			{"pause", crawlPaused},
+			{"resume", crawlRunning},
+			{"pause", crawlPaused},
+			{"stop", crawlStopping},
+			{"resume", crawlStopping},
+		} {
+			rec := request(h, http.MethodPost, "/api/crawl/"+step.action, "", "")
+			require.Equal(t, http.StatusOK, rec.Code, step.action)
+			assert.Equal(t, step.state, progressOf(t, rec).State, step.action)
+		}
+		assert.Equal(t, crawlStopping, progressOf(t, request(h, http.MethodGet, "/api/crawl", "", "")).State)
+		assert.Equal(t, http.StatusNotFound, request(h, http.MethodPost, "/api/crawl/restart", "", "").Code)
+	})
+
+	t.Run("submit urls", func(t *testing.T) {
+		h := newTestServer(t, "", 2).Handler()
+		assert.Equal(t, http.StatusConflict, request(h, http.MethodPost, "/api/crawl/urls", `{"urls":["https://site.test/a"]}`, "").Code)
+		require.Equal(t, http.StatusCreated, request(h, http.MethodPost, "/api/crawl", testSpec, "").Code)
+
+		assert.Equal(t, http.StatusBadRequest, request(h, http.MethodPost, "/api/crawl/urls", `{"urls":`, "").Code)
+		assert.Equal(t, http.StatusBadRequest, request(h, http.MethodPost, "/api/crawl/urls", `{"urls":["://bad"]}`, "").Code)
+
+		rec := request(h, http.MethodPost, "/api/crawl/urls", `{"urls":["https://site.test/a","https://site.test/a"]}`, "")
+		require.Equal(t, http.StatusAccepted, rec.Code)
+		assert.JSONEq(t, `{"accepted":1}`, rec.Body.String())
+
+		rec = request(h, http.MethodPost, "/api/crawl/urls", `{"urls":["https://site.test/b","https://site.test/c"]}`, "")
+		assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+		assert.Contains(t, rec.Body.String(), "accepted 1 urls")
+		assert.Equal(t, 2, progressOf(t, request(h, http.MethodGet, "/api/crawl", "", "")).Queued)
+	})
+}
+
+func TestServerToken(t *testing.T) {
+	h := newTestServer(t, "secret", 10).Handler()
+
+	for name, token := range map[string]string{"missing": "", "invalid": "wrong"} {
+		t.Run(name, func(t *testing.T) {
+			for _, target := range []string{"/api/crawl", "/api/crawl/stop", "/api/runs", "/metrics"} {
+				method := http.MethodGet
+				if target == "/api/crawl/stop" {
+					method = http.MethodPost
+				}
+				rec := request(h, method, target, "", token)
+				assert.Equal(t, http.StatusUnauthorized, rec.Code, target)
+			}
+			assert.Equal(t, http.StatusUnauthorized, request(h, http.MethodPost, "/api/crawl", testSpec, token).Code)
+		})
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		assert.Equal(t, http.StatusCreated, request(h, http.MethodPost, "/api/crawl", testSpec, "secret").Code)
+		assert.Equal(t, http.StatusOK, request(h, http.MethodGet, "/api/crawl", "", "secret").Code)
+	})
+}